@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"bitbucket.com/daemontech/muskoka-web-api/webserver"
+)
+
+func serveCommand(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	migrate := flags.Bool("migrate", true, "create missing tables before serving, or else require migrate to have run")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	shutdownTracing, err := muskoka.InitTracing()
+	if err != nil {
+		muskoka.GetLogger().Error("unable to start tracing", "error", err)
+		return exitFailure
+	}
+	defer shutdownTracing(context.Background())
+
+	if err := muskoka.ConnectDB(context.Background()); err != nil {
+		muskoka.GetLogger().Error("unable to connect to database", "error", err)
+		return exitUnavailable
+	}
+	defer muskoka.CloseDb()
+
+	muskoka.InitSES()
+	muskoka.InitS3()
+
+	if *migrate {
+		err = muskoka.MigrateSchema()
+	} else {
+		err = muskoka.VerifySchema(context.Background())
+	}
+	if err != nil {
+		muskoka.GetLogger().Error("schema is not ready", "error", err)
+		return exitFailure
+	}
+
+	if err := muskoka.StartCacheListener(); err != nil {
+		muskoka.GetLogger().Error("unable to listen for cache invalidations", "error", err)
+	}
+	if err := muskoka.StartEventBroker(); err != nil {
+		muskoka.GetLogger().Error("unable to listen for catalog events", "error", err)
+	}
+	muskoka.StartWebhookWorker()
+
+	if err := muskoka.CreateApp(); err != nil {
+		muskoka.GetLogger().Error("server stopped", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func migrateCommand(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if err := muskoka.ConnectDB(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect to database:", err)
+		return exitUnavailable
+	}
+	defer muskoka.CloseDb()
+
+	if err := muskoka.MigrateSchema(); err != nil {
+		fmt.Fprintln(os.Stderr, "migration failed:", err)
+		return exitFailure
+	}
+	fmt.Println("schema is up to date")
+	return exitOK
+}
+
+func createAdminCommand(args []string) int {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := flags.String("username", "", "username of the admin (required)")
+	email := flags.String("email", "", "email of a new admin")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: muskoka-web-api create-admin -username name [-email address] < password")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "An existing user is made a verified admin. Otherwise a new one is created")
+		fmt.Fprintln(flags.Output(), "with the password on the first line of standard input.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if len(*username) == 0 {
+		fmt.Fprintln(os.Stderr, "-username is required")
+		return exitUsage
+	}
+
+	ctx := context.Background()
+	if err := muskoka.ConnectDB(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect to database:", err)
+		return exitUnavailable
+	}
+	defer muskoka.CloseDb()
+
+	promoted, err := muskoka.PromoteAdmin(ctx, *username)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to update user:", err)
+		return exitFailure
+	}
+	if promoted {
+		fmt.Printf("%s is now an admin\n", *username)
+		return exitOK
+	}
+
+	if len(*email) == 0 {
+		fmt.Fprintf(os.Stderr, "no user called %s, -email is required to create one\n", *username)
+		return exitUsage
+	}
+	// Without a newline at the end ReadString still returns the password
+	password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) == 0 {
+		fmt.Fprintln(os.Stderr, "a password is required on standard input")
+		return exitUsage
+	}
+
+	if _, err := muskoka.CreateAdmin(ctx, *username, *email, password); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to create admin:", err)
+		return exitFailure
+	}
+	fmt.Printf("created admin %s\n", *username)
+	return exitOK
+}
+
+func seedCommand(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	file := flags.String("file", "", "JSON fixtures to add (required)")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if len(*file) == 0 {
+		fmt.Fprintln(os.Stderr, "-file is required")
+		return exitUsage
+	}
+
+	fixtures, err := muskoka.LoadFixtures(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	ctx := context.Background()
+	if err := muskoka.ConnectDB(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect to database:", err)
+		return exitUnavailable
+	}
+	defer muskoka.CloseDb()
+
+	result, err := fixtures.Seed(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "seed failed, nothing was added:", err)
+		return exitFailure
+	}
+
+	entities := []string{}
+	for entity := range result {
+		entities = append(entities, entity)
+	}
+	sort.Strings(entities)
+	for _, entity := range entities {
+		fmt.Printf("%s: %d added\n", entity, result[entity])
+	}
+	if len(entities) == 0 {
+		fmt.Println("nothing to add")
+	}
+	return exitOK
+}
+
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	connect := flags.Bool("connect", false, "also run the readiness checks against the database, S3 and SES")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	// run has loaded the config by now
+	fmt.Println("config: ok")
+	if !*connect {
+		return exitOK
+	}
+
+	ctx := context.Background()
+	if err := muskoka.ConnectDB(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "database: unable to connect:", err)
+		return exitUnavailable
+	}
+	defer muskoka.CloseDb()
+	muskoka.InitSES()
+	muskoka.InitS3()
+	// The migrations check reports it if this fails
+	muskoka.VerifySchema(ctx)
+
+	results := muskoka.RunReadinessChecks(ctx)
+	names := []string{}
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	code := exitOK
+	for _, name := range names {
+		result := results[name]
+		if result.Status != "ok" {
+			fmt.Printf("%s: %s (%s)\n", name, result.Status, result.Error)
+			code = exitUnavailable
+			continue
+		}
+		fmt.Printf("%s: ok in %s\n", name, result.Latency)
+	}
+	return code
+}
+
+func routesCommand(args []string) int {
+	flags := flag.NewFlagSet("routes", flag.ContinueOnError)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tHANDLER")
+	for _, route := range muskoka.Routes() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", route.Method, route.Path, route.Handler)
+	}
+	w.Flush()
+	return exitOK
+}
@@ -1,25 +1,98 @@
 package main
 
-import "bitbucket.com/daemontech/muskoka-web-api/webserver"
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"bitbucket.com/daemontech/muskoka-web-api/webserver"
+)
+
+// Exit codes, so scripts and orchestrators can tell failures apart
+const (
+	exitOK = 0
+	// The command ran and failed
+	exitFailure = 1
+	// Unknown command or bad flags
+	exitUsage = 2
+	// A MUSKOKA_* variable is invalid
+	exitConfig = 3
+	// The database or another dependency can't be reached
+	exitUnavailable = 4
+)
+
+type command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) int
+}
+
+var commands = []command{
+	{"serve", "run the API server, the default with no command", serveCommand},
+	{"migrate", "create missing tables, indices and views", migrateCommand},
+	{"create-admin", "create an admin, or make an existing user one", createAdminCommand},
+	{"seed", "add catalog fixtures from a JSON file", seedCommand},
+	{"check", "validate the config, and with -connect its dependencies", checkCommand},
+	{"routes", "print the route table", routesCommand},
+}
 
 func main() {
-	muskoka.GetDBConnection()
-	defer muskoka.CloseDb()
-
-	muskoka.InitSES()
-	muskoka.InitS3()
-
-	muskoka.InitColour()
-	muskoka.InitWood()
-	muskoka.InitDoorStyleType()
-	muskoka.InitDoorStyle()
-	muskoka.InitDoorStyleDoorStyleType()
-	muskoka.InitImageType()
-	muskoka.InitDoorSample()
-	muskoka.InitGallerySample()
-	muskoka.InitImage()
-	muskoka.InitDealer()
-	muskoka.InitUser()
-
-	muskoka.CreateApp()
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	// No command serves, so existing deploys keep working
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return exitOK
+	}
+
+	for _, c := range commands {
+		if c.Name != name {
+			continue
+		}
+		if err := muskoka.InitConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid config:", err)
+			return exitConfig
+		}
+		return c.Run(args)
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: muskoka-web-api [command] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run a command with -h for its flags. Config is read from MUSKOKA_* variables.")
+}
+
+// parseFlags returns ok false with the exit code when the command shouldn't
+// run, e.g. for -h
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q\n", flags.Arg(0))
+		return exitUsage, false
+	}
+	return exitOK, true
 }
@@ -1,33 +1,80 @@
 package muskoka
 
 import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
 	"github.com/kataras/iris"
-	"github.com/rs/cors"
 )
 
-func CreateApp() {
+// RouteInfo is a registered route and the name of the handler that ends it
+type RouteInfo struct {
+	Method  string
+	Path    string
+	Handler string
+}
+
+// CreateApp serves until SIGINT or SIGTERM, then returns once in-flight
+// requests have drained and background workers have stopped.
+func CreateApp() error {
+	app := NewApp()
 
-	app := iris.New()
-	//app.UseFunc(SecureMiddleware)
-	//app.Adapt(httprouter.New())
-	//app.Adapt(iris.DevLogger())
-
-	corsOptions := cors.Options{
-		AllowedMethods:   []string{"OPTIONS", "GET", "PUT", "POST", "DELETE"},
-		AllowCredentials: true,
-		AllowedOrigins:   []string{"*"},
-		AllowedHeaders:   []string{"X-Requested-With", "Content-Type"},
+	runner, err := NewRunner()
+	if err != nil {
+		// Stop the workers started before the app
+		runShutdownHooks()
+		return err
+	}
+
+	go shutdownOnSignal(app)
+
+	err = app.Run(runner,
+		iris.WithoutVersionChecker,
+		iris.WithoutInterruptHandler,
+		iris.WithoutServerError(iris.ErrServerClosed))
+
+	// Run returns as soon as the listener closes, the hooks close the database
+	// so wait for the requests still in flight first
+	if err == nil {
+		<-drained
 	}
-	corsWrapper := cors.New(corsOptions).ServeHTTP
+	runShutdownHooks()
+	return err
+}
+
+// NewApp registers the middleware and every route without serving
+func NewApp() *iris.Application {
+	app := iris.New()
+	app.Use(RequestInfoMiddleware)
+	app.Use(SecureMiddleware)
+
+	corsWrapper := NewCORS().ServeHTTP
 	app.WrapRouter(corsWrapper)
+	// The last wrapper runs first, so preflight requests are traced and
+	// logged too
+	app.WrapRouter(MetricsWrapper)
+	app.WrapRouter(RequestLogWrapper)
+	app.WrapRouter(TracingWrapper)
+
+	config := GetConfig()
 
 	authAPI := app.Party("/auth")
 	{
-		authAPI.Post("/register", RegisterHandler)
-		authAPI.Post("/verify", VerifyHandler)
-		authAPI.Post("/login", LoginHandler)
+		authAPI.Post("/register", RateLimitMiddleware(RateLimitPolicy{
+			Name: "register", Limit: config.RegisterRateLimit, KeyFunc: KeyByIP,
+		}), RegisterHandler)
+		authAPI.Post("/verify", RateLimitMiddleware(RateLimitPolicy{
+			Name: "verify", Limit: config.VerifyRateLimit, KeyFunc: KeyByIP,
+		}), VerifyHandler)
+		authAPI.Post("/login", RateLimitMiddleware(RateLimitPolicy{
+			Name: "login", Limit: config.LoginRateLimit, KeyFunc: KeyByIP,
+		}), LoginHandler)
 	}
 
+	CreateHealthAPI(app)
+	CreateMetricsAPI(app.Party("/metrics"))
 	CreateUploadAPI(app.Party("/upload"))
 	CreateColourAPI(app.Party("/colour"))
 	CreateDoorSampleAPI(app.Party("/door-sample"))
@@ -37,8 +84,33 @@ func CreateApp() {
 	CreateImageTypeAPI(app.Party("/image-type"))
 	CreateWoodAPI(app.Party("/wood"))
 	CreateDealerAPI(app.Party("/dealer"))
+	CreateGraphQLAPI(app.Party("/graphql"))
+	CreateWebhookAPI(app.Party("/webhook"))
+	CreateWebhookDeliveryAPI(app.Party("/webhook-delivery"))
+	CreateEventsAPI(app.Party("/events"))
+	CreateTranslationAPI(app.Party("/translation"))
+
+	return app
+}
 
-	app.Run(iris.Addr(":8080"), iris.WithoutVersionChecker)
+// Routes lists the app's routes by path and then method
+func Routes() []RouteInfo {
+	routes := []RouteInfo{}
+	for _, route := range NewApp().GetRoutes() {
+		handler := ""
+		if len(route.Handlers) > 0 {
+			last := route.Handlers[len(route.Handlers)-1]
+			handler = runtime.FuncForPC(reflect.ValueOf(last).Pointer()).Name()
+			handler = handler[strings.LastIndex(handler, "/")+1:]
+		}
+		routes = append(routes, RouteInfo{Method: route.Method, Path: route.Path, Handler: handler})
+	}
 
-	defer CloseDb()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
 }
@@ -51,7 +51,7 @@ func RegisterHandler(ctx context.Context) {
 		PasswordHash:      passwordHash,
 		VerificationToken: token,
 	}
-	user, err = user.Insert()
+	user, err = user.Insert(ctx.Request().Context())
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -71,10 +71,11 @@ func RegisterHandler(ctx context.Context) {
 		https://www.muskokacabco.com/verify?username=%[1]s&token=%[2]s`,
 			user.Username, user.VerificationToken),
 	}
-	err = verifyEmail.Send()
+	err = verifyEmail.Send(ctx.Request().Context())
 	if err != nil {
+		LoggerFrom(ctx.Request().Context()).Error("unable to send verification email", "error", err)
 		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(map[string]interface{}{"error": err}) //"Unable to send verification email"})
+		ctx.JSON(map[string]interface{}{"error": "Unable to send verification email"})
 		return
 	}
 
@@ -97,7 +98,9 @@ func VerifyHandler(ctx context.Context) {
 		return
 	}
 
-	stmt, err := GetDBConnection().Prepare(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := GetDBConnection().PrepareContext(queryCtx, `
 		UPDATE users
 		SET is_verified = TRUE
 		WHERE username = $1 AND verification_token = $2 AND is_verified = FALSE`)
@@ -107,7 +110,7 @@ func VerifyHandler(ctx context.Context) {
 		return
 	}
 
-	_, err = stmt.Exec(userVerification.Username, userVerification.Token)
+	_, err = stmt.ExecContext(queryCtx, userVerification.Username, userVerification.Token)
 	if err != nil {
 		code, errObj := HandleDBError(err)
 		ctx.StatusCode(code)
@@ -124,7 +127,9 @@ func VerifyHandler(ctx context.Context) {
 	}
 
 	var isAdmin bool
-	err = GetDBConnection().QueryRow(`
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = GetDBConnection().QueryRowContext(queryCtx, `
 			SELECT is_admin
 			FROM users 
 			WHERE username = $1 AND verification_token = $2`,
@@ -140,7 +145,7 @@ func VerifyHandler(ctx context.Context) {
 		"username": userVerification.Username,
 		"isAdmin":  isAdmin,
 	})
-	tokenString, err := token.SignedString(JWT_SECRET)
+	tokenString, err := token.SignedString(GetConfig().JWTSecret)
 	if err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(map[string]interface{}{"error": "Unable to create user token"})
@@ -167,21 +172,24 @@ func LoginHandler(ctx context.Context) {
 	}
 
 	user := &User{}
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
 	if isEmail {
 		user.Email = credentials.ID
-		err = GetDBConnection().QueryRow(`
+		err = GetDBConnection().QueryRowContext(queryCtx, `
 			SELECT username, password_hash, is_admin
 			FROM users 
 			WHERE email = $1 AND is_verified = TRUE`, credentials.ID).Scan(&user.Username, &user.PasswordHash, &user.IsAdmin)
 	} else {
 		user.Username = credentials.ID
-		err = GetDBConnection().QueryRow(`
+		err = GetDBConnection().QueryRowContext(queryCtx, `
 			SELECT email, password_hash, is_admin
 			FROM users 
 			WHERE username = $1 AND is_verified = TRUE`, credentials.ID).Scan(&user.Email, &user.PasswordHash, &user.IsAdmin)
 	}
 
 	if err != nil {
+		loginsTotal.WithLabelValues("failure").Inc()
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(result)
@@ -190,6 +198,7 @@ func LoginHandler(ctx context.Context) {
 
 	err = bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(credentials.Password))
 	if err != nil {
+		loginsTotal.WithLabelValues("failure").Inc()
 		ctx.StatusCode(iris.StatusNotFound)
 		ctx.JSON(map[string]interface{}{"error": "Password mismatch"})
 		return
@@ -199,13 +208,14 @@ func LoginHandler(ctx context.Context) {
 		"username": user.Username,
 		"isAdmin":  user.IsAdmin,
 	})
-	tokenString, err := token.SignedString(JWT_SECRET)
+	tokenString, err := token.SignedString(GetConfig().JWTSecret)
 	if err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(map[string]interface{}{"error": "Error creating user token"})
 		return
 	}
 
+	loginsTotal.WithLabelValues("success").Inc()
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(map[string]interface{}{"token": tokenString})
 }
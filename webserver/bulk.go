@@ -0,0 +1,439 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
+
+const (
+	// BulkAtomic commits every operation or none of them
+	BulkAtomic = "atomic"
+	// BulkPartial commits the operations that succeed and reports the rest
+	BulkPartial = "partial"
+)
+
+var bulkWebhookActions = map[string]string{
+	"create": "created",
+	"update": "updated",
+	"delete": "deleted",
+}
+
+type BulkRequest struct {
+	Mode       string          `json:"mode"`
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkOperation is one of create, update or delete. Create and update take
+// the same body as the single item endpoints in data, delete takes an id.
+type BulkOperation struct {
+	Op   string          `json:"op"`
+	ID   int64           `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+type BulkResult struct {
+	Index            int                    `json:"index"`
+	Status           int                    `json:"status"`
+	ID               int64                  `json:"id,omitempty"`
+	Data             interface{}            `json:"data,omitempty"`
+	Error            string                 `json:"error,omitempty"`
+	ValidationErrors map[string]interface{} `json:"validationErrors,omitempty"`
+}
+
+// ValidationErrors maps a camel case field name to its message, the same
+// shape HandleDBError gives unique violations
+type ValidationErrors map[string]interface{}
+
+func (v ValidationErrors) Error() string {
+	messages := []string{}
+	for _, message := range v {
+		messages = append(messages, fmt.Sprint(message))
+	}
+	return strings.Join(messages, " ")
+}
+
+// BulkChange is what a single operation did. AfterCommit, if set, runs
+// once the transaction commits, e.g. to remove files from S3 that must stay
+// while a rollback is still possible.
+type BulkChange struct {
+	ID          int64
+	Data        interface{}
+	AfterCommit func()
+}
+
+// BulkResource runs single operations inside the bulk transaction
+type BulkResource struct {
+	Entity string
+	Create func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error)
+	Update func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error)
+	Delete func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error)
+}
+
+// BulkHandler accepts {"mode": "atomic"|"partial", "operations": [...]} and
+// runs every operation in one transaction. Atomic mode stops and rolls back
+// at the first failure. Partial mode wraps each operation in a savepoint so
+// a failure only undoes that operation.
+func BulkHandler(resource BulkResource) context.Handler {
+	return func(ctx context.Context) {
+		request := BulkRequest{Mode: BulkAtomic}
+		if err := ctx.ReadJSON(&request); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "Unable to read bulk operations"})
+			return
+		}
+		if request.Mode != BulkAtomic && request.Mode != BulkPartial {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "Mode must be atomic or partial"})
+			return
+		}
+		if len(request.Operations) == 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "No operations"})
+			return
+		}
+		if maxOperations := GetConfig().BulkMaxOperations; len(request.Operations) > maxOperations {
+			ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+			ctx.JSON(map[string]interface{}{
+				"error": fmt.Sprintf("No more than %d operations are allowed", maxOperations),
+			})
+			return
+		}
+
+		reqCtx := ctx.Request().Context()
+		tx, err := GetDBConnection().BeginTx(reqCtx, nil)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+		defer tx.Rollback()
+
+		results := make([]BulkResult, len(request.Operations))
+		afterCommit := []func(){}
+		succeeded := 0
+		failed := -1
+		for i, operation := range request.Operations {
+			if request.Mode == BulkPartial {
+				if _, err := tx.ExecContext(reqCtx, "SAVEPOINT bulk_operation"); err != nil {
+					statusCode, errObj := HandleDBError(err)
+					ctx.StatusCode(statusCode)
+					ctx.JSON(errObj)
+					return
+				}
+			}
+
+			result, cleanup := runBulkOperation(reqCtx, tx, resource, operation)
+			result.Index = i
+			results[i] = result
+
+			if result.Status >= iris.StatusBadRequest {
+				if request.Mode == BulkAtomic {
+					failed = i
+					break
+				}
+				if _, err := tx.ExecContext(reqCtx, "ROLLBACK TO SAVEPOINT bulk_operation"); err != nil {
+					statusCode, errObj := HandleDBError(err)
+					ctx.StatusCode(statusCode)
+					ctx.JSON(errObj)
+					return
+				}
+				continue
+			}
+
+			if request.Mode == BulkPartial {
+				if _, err := tx.ExecContext(reqCtx, "RELEASE SAVEPOINT bulk_operation"); err != nil {
+					statusCode, errObj := HandleDBError(err)
+					ctx.StatusCode(statusCode)
+					ctx.JSON(errObj)
+					return
+				}
+			}
+			if cleanup != nil {
+				afterCommit = append(afterCommit, cleanup)
+			}
+			succeeded++
+		}
+
+		if failed >= 0 {
+			// Nothing was kept, so the other operations didn't happen either
+			for i := range results {
+				if i < failed {
+					results[i] = BulkResult{Index: i, Status: iris.StatusFailedDependency,
+						Error: "Rolled back"}
+				} else if i > failed {
+					results[i] = BulkResult{Index: i, Status: iris.StatusFailedDependency,
+						Error: "Not run"}
+				}
+			}
+			ctx.StatusCode(results[failed].Status)
+			ctx.JSON(map[string]interface{}{"committed": false, "results": results})
+			return
+		}
+
+		// The events are saved with the changes, so neither happens alone
+		for i, result := range results {
+			if result.Status >= iris.StatusBadRequest {
+				continue
+			}
+			operation := request.Operations[i]
+			data := result.Data
+			if operation.Op == "delete" {
+				data = map[string]interface{}{"id": result.ID}
+			}
+			err := EmitCatalogEventTx(reqCtx, tx, WebhookEventName(resource.Entity, bulkWebhookActions[operation.Op]), data)
+			if err != nil {
+				statusCode, errObj := HandleDBError(err)
+				ctx.StatusCode(statusCode)
+				ctx.JSON(errObj)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+
+		for _, cleanup := range afterCommit {
+			cleanup()
+		}
+		if succeeded > 0 {
+			InvalidateCache(reqCtx, resource.Entity)
+			wakeWebhookWorker()
+		}
+
+		statusCode := iris.StatusOK
+		if succeeded < len(results) {
+			statusCode = iris.StatusMultiStatus
+		}
+		ctx.StatusCode(statusCode)
+		ctx.JSON(map[string]interface{}{"committed": true, "results": results})
+	}
+}
+
+func runBulkOperation(ctx stdContext.Context, tx *sql.Tx, resource BulkResource, operation BulkOperation) (BulkResult, func()) {
+	var change BulkChange
+	var err error
+
+	switch operation.Op {
+	case "create":
+		change, err = resource.Create(ctx, tx, operation.Data)
+	case "update":
+		change, err = resource.Update(ctx, tx, operation.Data)
+	case "delete":
+		change, err = resource.Delete(ctx, tx, operation.ID)
+		change.ID = operation.ID
+	default:
+		return BulkResult{Status: iris.StatusBadRequest, Error: "Op must be create, update or delete"}, nil
+	}
+
+	if err != nil {
+		result := bulkErrorResult(err)
+		result.ID = change.ID
+		return result, nil
+	}
+	return BulkResult{Status: iris.StatusOK, ID: change.ID, Data: change.Data}, change.AfterCommit
+}
+
+func bulkErrorResult(err error) BulkResult {
+	if validationErrors, ok := err.(ValidationErrors); ok {
+		return BulkResult{Status: iris.StatusBadRequest, ValidationErrors: validationErrors}
+	}
+	if _, ok := err.(*json.SyntaxError); ok {
+		return BulkResult{Status: iris.StatusBadRequest, Error: "Unable to read data"}
+	}
+	if _, ok := err.(*json.UnmarshalTypeError); ok {
+		return BulkResult{Status: iris.StatusBadRequest, Error: "Unable to read data"}
+	}
+
+	statusCode, errObj := HandleDBError(err)
+	if statusCode == 0 {
+		return BulkResult{Status: iris.StatusInternalServerError, Error: "Unknown Error"}
+	}
+	result := BulkResult{Status: statusCode}
+	result.Error, _ = errObj["error"].(string)
+	result.ValidationErrors, _ = errObj["validationErrors"].(map[string]interface{})
+	return result
+}
+
+type namedEntity struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// namedEntityBulkResource is the bulk resource for a table with just an id
+// and a name, like colours and wood
+func namedEntityBulkResource(entity string, table string) BulkResource {
+	read := func(data json.RawMessage) (*namedEntity, error) {
+		item := &namedEntity{}
+		if err := json.Unmarshal(data, item); err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(item.Name)) == 0 {
+			return nil, ValidationErrors{"name": "Name is required."}
+		}
+		return item, nil
+	}
+
+	return BulkResource{
+		Entity: entity,
+		Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+			item, err := read(data)
+			if err != nil {
+				return BulkChange{}, err
+			}
+
+			queryCtx, cancel := withQueryTimeout(ctx)
+			defer cancel()
+			err = tx.QueryRowContext(queryCtx, `INSERT INTO `+table+` (name)
+				VALUES($1) returning id;`, item.Name).Scan(&item.ID)
+			return BulkChange{ID: item.ID, Data: item}, err
+		},
+		Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+			item, err := read(data)
+			if err != nil {
+				return BulkChange{}, err
+			}
+
+			queryCtx, cancel := withQueryTimeout(ctx)
+			defer cancel()
+			res, err := tx.ExecContext(queryCtx, `UPDATE `+table+` SET name=$1 WHERE id=$2`,
+				item.Name, item.ID)
+			if err != nil {
+				return BulkChange{ID: item.ID}, err
+			}
+			return BulkChange{ID: item.ID, Data: item}, requireRowsAffected(res)
+		},
+		Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+			queryCtx, cancel := withQueryTimeout(ctx)
+			defer cancel()
+			res, err := tx.ExecContext(queryCtx, `DELETE FROM `+table+` WHERE id=$1`, id)
+			if err != nil {
+				return BulkChange{}, err
+			}
+			return BulkChange{}, requireRowsAffected(res)
+		},
+	}
+}
+
+// SingleHandler runs one create, update or delete of resource in its own
+// transaction, for single item endpoints whose writes touch more than one
+// row. It answers the way the other single item endpoints do: the item for
+// creates, {} for updates and the id for deletes.
+func SingleHandler(resource BulkResource, op string) context.Handler {
+	return func(ctx context.Context) {
+		operation := BulkOperation{Op: op}
+		if op == "delete" {
+			id, err := ctx.Params().GetInt64("id")
+			if err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.JSON(map[string]interface{}{"error": "Unable to read " + resource.Entity + " id"})
+				return
+			}
+			operation.ID = id
+		} else if err := ctx.ReadJSON(&operation.Data); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "Unable to read " + resource.Entity})
+			return
+		}
+
+		change, err := RunSingleOperation(ctx.Request().Context(), resource, operation)
+		if err != nil {
+			writeBulkError(ctx, err)
+			return
+		}
+
+		ctx.StatusCode(iris.StatusOK)
+		switch op {
+		case "create":
+			ctx.JSON(change.Data)
+		case "update":
+			ctx.JSON(map[string]interface{}{})
+		default:
+			ctx.JSON(map[string]interface{}{"id": strconv.FormatInt(change.ID, 10)})
+		}
+	}
+}
+
+// RunSingleOperation runs operation and records its event in one
+// transaction and, once it has committed, clears the cache, for
+// SingleHandler and the GraphQL mutations
+func RunSingleOperation(ctx stdContext.Context, resource BulkResource, operation BulkOperation) (BulkChange, error) {
+	tx, err := GetDBConnection().BeginTx(ctx, nil)
+	if err != nil {
+		return BulkChange{}, err
+	}
+	defer tx.Rollback()
+
+	var change BulkChange
+	switch operation.Op {
+	case "create":
+		change, err = resource.Create(ctx, tx, operation.Data)
+	case "update":
+		change, err = resource.Update(ctx, tx, operation.Data)
+	default:
+		change, err = resource.Delete(ctx, tx, operation.ID)
+		change.ID = operation.ID
+	}
+	if err != nil {
+		return change, err
+	}
+
+	data := change.Data
+	if operation.Op == "delete" {
+		data = map[string]interface{}{"id": change.ID}
+	}
+	err = EmitCatalogEventTx(ctx, tx, WebhookEventName(resource.Entity, bulkWebhookActions[operation.Op]), data)
+	if err != nil {
+		return change, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return change, err
+	}
+	if change.AfterCommit != nil {
+		change.AfterCommit()
+	}
+	InvalidateCache(ctx, resource.Entity)
+	wakeWebhookWorker()
+	return change, nil
+}
+
+// requireRowsAffected turns an update or delete that matched nothing into
+// sql.ErrNoRows, which HandleDBError reports as not found
+func requireRowsAffected(res sql.Result) error {
+	affect, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affect < 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func namedEntityPatchResource(entity string, table string) PatchResource {
+	return PatchResource{
+		Entity: entity,
+		Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+			queryCtx, cancel := withQueryTimeout(ctx)
+			defer cancel()
+
+			item := &namedEntity{ID: id}
+			err := tx.QueryRowContext(queryCtx, `SELECT name FROM `+table+` WHERE id=$1 FOR UPDATE`,
+				id).Scan(&item.Name)
+			return item, err
+		},
+		Update: namedEntityBulkResource(entity, table).Update,
+	}
+}
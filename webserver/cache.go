@@ -0,0 +1,240 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Catalog reads are cached per entity and dropped whenever something they
+// were built from changes. Door sample results depend on colours, wood and
+// door styles as well, so e.g. renaming a colour invalidates them. Door
+// styles list the woods and colours they're made in. Anything with images
+// carries their image types. An entity missing here is cached without tags
+// and only expires.
+var cacheDependencies = map[string][]string{
+	"colour":          {"colour", "image-type"},
+	"wood":            {"wood", "image-type"},
+	"door-style":      {"door-style", "door-style-type", "wood", "colour", "image-type"},
+	"door-style-type": {"door-style-type"},
+	"door-sample":     {"door-sample", "door-style", "wood", "colour", "image-type"},
+	"dealer":          {"dealer"},
+}
+
+const cacheNotifyChannel = "muskoka_cache_invalidate"
+
+var (
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "muskoka_cache_requests_total",
+		Help: "Catalog cache lookups by entity and result.",
+	}, []string{"entity", "result"})
+
+	cacheInvalidationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "muskoka_cache_invalidations_total",
+		Help: "Catalog cache invalidations by entity and source.",
+	}, []string{"entity", "source"})
+)
+
+type cacheEntry struct {
+	value   interface{}
+	tags    []string
+	expires time.Time
+}
+
+type CatalogCache struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry
+	ttl        time.Duration
+	maxEntries int
+	instanceID string
+	// generation changes on every invalidation, so a read that raced
+	// with a write doesn't store what it loaded
+	generation uint64
+}
+
+var catalogCache *CatalogCache
+var catalogCacheOnce sync.Once
+
+func GetCatalogCache() *CatalogCache {
+	catalogCacheOnce.Do(func() {
+		config := GetConfig()
+		catalogCache = &CatalogCache{
+			entries:    map[string]cacheEntry{},
+			ttl:        config.CacheTTL,
+			maxEntries: config.CacheMaxEntries,
+			instanceID: newRequestID(),
+		}
+	})
+	return catalogCache
+}
+
+// cachedRead returns the cached value for key or loads and stores it. The
+// entity decides which writes invalidate the value.
+func cachedRead(entity string, key string, load func() (interface{}, error)) (interface{}, error) {
+	cache := GetCatalogCache()
+	if cache.ttl <= 0 {
+		return load()
+	}
+
+	key = entity + ":" + key
+	if value, ok := cache.get(key); ok {
+		cacheRequestsTotal.WithLabelValues(entity, "hit").Inc()
+		return value, nil
+	}
+	cacheRequestsTotal.WithLabelValues(entity, "miss").Inc()
+
+	generation := cache.currentGeneration()
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	cache.set(key, value, cacheDependencies[entity], generation)
+	return value, nil
+}
+
+func (c *CatalogCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *CatalogCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *CatalogCache) set(key string, value interface{}, tags []string, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	now := time.Now()
+	if len(c.entries) >= c.maxEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	// Still full, drop arbitrary entries rather than grow without bound
+	for k := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			break
+		}
+		delete(c.entries, k)
+	}
+
+	c.entries[key] = cacheEntry{value: value, tags: tags, expires: now.Add(c.ttl)}
+}
+
+func (c *CatalogCache) invalidate(entity string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key, entry := range c.entries {
+		for _, tag := range entry.tags {
+			if tag == entity {
+				delete(c.entries, key)
+				break
+			}
+		}
+	}
+}
+
+func (c *CatalogCache) flush() {
+	c.mu.Lock()
+	c.generation++
+	c.entries = map[string]cacheEntry{}
+	c.mu.Unlock()
+}
+
+// InvalidateCache drops everything built from entity here and tells the
+// other instances to do the same.
+func InvalidateCache(ctx stdContext.Context, entity string) {
+	cache := GetCatalogCache()
+	cache.invalidate(entity)
+	cacheInvalidationsTotal.WithLabelValues(entity, "local").Inc()
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err := GetDBConnection().ExecContext(queryCtx, `SELECT pg_notify($1, $2)`,
+		cacheNotifyChannel, cache.instanceID+":"+entity)
+	if err != nil {
+		LoggerFrom(ctx).Error("unable to notify cache invalidation", "entity", entity, "error", err)
+	}
+}
+
+// StartCacheListener applies invalidations from other instances. Anything
+// could have changed while the listener was disconnected, so a reconnect
+// flushes the whole cache.
+func StartCacheListener() error {
+	cache := GetCatalogCache()
+	if cache.ttl <= 0 {
+		return nil
+	}
+
+	listener := pq.NewListener(GetConfig().DB.ConnectionString(), 10*time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				GetLogger().Warn("cache listener", "event", event, "error", err)
+			}
+		})
+	if err := listener.Listen(cacheNotifyChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	OnShutdown(func() {
+		close(stop)
+		<-done
+		listener.Close()
+	})
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case notification := <-listener.Notify:
+				if notification == nil {
+					cache.flush()
+					cacheInvalidationsTotal.WithLabelValues("all", "reconnect").Inc()
+					continue
+				}
+
+				parts := strings.SplitN(notification.Extra, ":", 2)
+				if len(parts) != 2 || parts[0] == cache.instanceID {
+					continue
+				}
+				cache.invalidate(parts[1])
+				cacheInvalidationsTotal.WithLabelValues(parts[1], "remote").Inc()
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
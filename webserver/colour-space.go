@@ -0,0 +1,146 @@
+package muskoka
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColourRGB is an sRGB colour, each channel 0 to 255
+type ColourRGB struct {
+	R int `json:"r"`
+	G int `json:"g"`
+	B int `json:"b"`
+}
+
+// ColourLAB is a CIE L*a*b* colour under the D65 white point
+type ColourLAB struct {
+	L float64 `json:"l"`
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+}
+
+// D65 reference white
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// parseHexColour reads #rrggbb or #rgb, with or without the #, and returns
+// it as #rrggbb in lower case
+func parseHexColour(value string) (string, ColourRGB, error) {
+	hex := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "#"))
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return "", ColourRGB{}, fmt.Errorf("%q is not a hex colour", value)
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return "", ColourRGB{}, fmt.Errorf("%q is not a hex colour", value)
+	}
+	return "#" + hex, ColourRGB{R: int(n >> 16), G: int(n >> 8 & 0xff), B: int(n & 0xff)}, nil
+}
+
+// LAB converts through linear sRGB and CIE XYZ
+func (c ColourRGB) LAB() ColourLAB {
+	linear := func(channel int) float64 {
+		v := float64(channel) / 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	r, g, b := linear(c.R), linear(c.G), linear(c.B)
+
+	x := 0.4124564*r + 0.3575761*g + 0.1804375*b
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := 0.0193339*r + 0.1191920*g + 0.9503041*b
+
+	f := func(t float64) float64 {
+		const delta = 6.0 / 29
+		if t > delta*delta*delta {
+			return math.Cbrt(t)
+		}
+		return t/(3*delta*delta) + 4.0/29
+	}
+	fx, fy, fz := f(x/whiteX), f(y/whiteY), f(z/whiteZ)
+
+	return ColourLAB{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+// DeltaE is the CIEDE2000 colour difference, where about 1 is the smallest
+// difference people notice and above 10 the colours look unrelated
+func (c ColourLAB) DeltaE(other ColourLAB) float64 {
+	const pow25To7 = 6103515625.0
+	degrees := func(radians float64) float64 { return radians * 180 / math.Pi }
+	radians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	hue := func(b float64, a float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		h := degrees(math.Atan2(b, a))
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+
+	c1 := math.Hypot(c.A, c.B)
+	c2 := math.Hypot(other.A, other.B)
+	cBar7 := math.Pow((c1+c2)/2, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25To7)))
+
+	a1 := (1 + g) * c.A
+	a2 := (1 + g) * other.A
+	c1p := math.Hypot(a1, c.B)
+	c2p := math.Hypot(a2, other.B)
+	h1p := hue(c.B, a1)
+	h2p := hue(other.B, a2)
+
+	deltaL := other.L - c.L
+	deltaC := c2p - c1p
+	deltaH := 0.0
+	if c1p*c2p != 0 {
+		deltaH = h2p - h1p
+		if deltaH > 180 {
+			deltaH -= 360
+		} else if deltaH < -180 {
+			deltaH += 360
+		}
+	}
+	deltaHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(radians(deltaH/2))
+
+	lBar := (c.L + other.L) / 2
+	cBarp := (c1p + c2p) / 2
+	hBarp := h1p + h2p
+	if c1p*c2p != 0 {
+		switch {
+		case math.Abs(h1p-h2p) <= 180:
+			hBarp /= 2
+		case hBarp < 360:
+			hBarp = (hBarp + 360) / 2
+		default:
+			hBarp = (hBarp - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos(radians(hBarp-30)) + 0.24*math.Cos(radians(2*hBarp)) +
+		0.32*math.Cos(radians(3*hBarp+6)) - 0.20*math.Cos(radians(4*hBarp-63))
+	deltaTheta := 30 * math.Exp(-math.Pow((hBarp-275)/25, 2))
+	cBarp7 := math.Pow(cBarp, 7)
+	rc := 2 * math.Sqrt(cBarp7/(cBarp7+pow25To7))
+	lBar50 := (lBar - 50) * (lBar - 50)
+	sl := 1 + 0.015*lBar50/math.Sqrt(20+lBar50)
+	sc := 1 + 0.045*cBarp
+	sh := 1 + 0.015*cBarp*t
+	rt := -math.Sin(radians(2*deltaTheta)) * rc
+
+	l := deltaL / sl
+	cc := deltaC / sc
+	h := deltaHp / sh
+	return math.Sqrt(l*l + cc*cc + h*h + rt*cc*h)
+}
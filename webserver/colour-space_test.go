@@ -0,0 +1,63 @@
+package muskoka
+
+import (
+	"math"
+	"testing"
+)
+
+// The test data from Sharma, Wu and Dalal, "The CIEDE2000 Color-Difference
+// Formula: Implementation Notes, Supplementary Test Data, and Mathematical
+// Observations" (2005)
+var ciede2000Pairs = []struct {
+	first  ColourLAB
+	second ColourLAB
+	deltaE float64
+}{
+	{ColourLAB{50.0000, 2.6772, -79.7751}, ColourLAB{50.0000, 0.0000, -82.7485}, 2.0425},
+	{ColourLAB{50.0000, 3.1571, -77.2803}, ColourLAB{50.0000, 0.0000, -82.7485}, 2.8615},
+	{ColourLAB{50.0000, 2.8361, -74.0200}, ColourLAB{50.0000, 0.0000, -82.7485}, 3.4412},
+	{ColourLAB{50.0000, -1.3802, -84.2814}, ColourLAB{50.0000, 0.0000, -82.7485}, 1.0000},
+	{ColourLAB{50.0000, -1.1848, -84.8006}, ColourLAB{50.0000, 0.0000, -82.7485}, 1.0000},
+	{ColourLAB{50.0000, -0.9009, -85.5211}, ColourLAB{50.0000, 0.0000, -82.7485}, 1.0000},
+	{ColourLAB{50.0000, 0.0000, 0.0000}, ColourLAB{50.0000, -1.0000, 2.0000}, 2.3669},
+	{ColourLAB{50.0000, -1.0000, 2.0000}, ColourLAB{50.0000, 0.0000, 0.0000}, 2.3669},
+	{ColourLAB{50.0000, 2.4900, -0.0010}, ColourLAB{50.0000, -2.4900, 0.0009}, 7.1792},
+	{ColourLAB{50.0000, 2.4900, -0.0010}, ColourLAB{50.0000, -2.4900, 0.0010}, 7.1792},
+	{ColourLAB{50.0000, 2.4900, -0.0010}, ColourLAB{50.0000, -2.4900, 0.0011}, 7.2195},
+	{ColourLAB{50.0000, 2.4900, -0.0010}, ColourLAB{50.0000, -2.4900, 0.0012}, 7.2195},
+	{ColourLAB{50.0000, -0.0010, 2.4900}, ColourLAB{50.0000, 0.0009, -2.4900}, 4.8045},
+	{ColourLAB{50.0000, -0.0010, 2.4900}, ColourLAB{50.0000, 0.0010, -2.4900}, 4.8045},
+	{ColourLAB{50.0000, -0.0010, 2.4900}, ColourLAB{50.0000, 0.0011, -2.4900}, 4.7461},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{50.0000, 0.0000, -2.5000}, 4.3065},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{73.0000, 25.0000, -18.0000}, 27.1492},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{61.0000, -5.0000, 29.0000}, 22.8977},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{56.0000, -27.0000, -3.0000}, 31.9030},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{58.0000, 24.0000, 15.0000}, 19.4535},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{50.0000, 3.1736, 0.5854}, 1.0000},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{50.0000, 3.2972, 0.0000}, 1.0000},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{50.0000, 1.8634, 0.5757}, 1.0000},
+	{ColourLAB{50.0000, 2.5000, 0.0000}, ColourLAB{50.0000, 3.2592, 0.3350}, 1.0000},
+	{ColourLAB{60.2574, -34.0099, 36.2677}, ColourLAB{60.4626, -34.1751, 39.4387}, 1.2644},
+	{ColourLAB{63.0109, -31.0961, -5.8663}, ColourLAB{62.8187, -29.7946, -4.0864}, 1.2630},
+	{ColourLAB{61.2901, 3.7196, -5.3901}, ColourLAB{61.4292, 2.2480, -4.9620}, 1.8731},
+	{ColourLAB{35.0831, -44.1164, 3.7933}, ColourLAB{35.0232, -40.0716, 1.5901}, 1.8645},
+	{ColourLAB{22.7233, 20.0904, -46.6940}, ColourLAB{23.0331, 14.9730, -42.5619}, 2.0373},
+	{ColourLAB{36.4612, 47.8580, 18.3852}, ColourLAB{36.2715, 50.5065, 21.2231}, 1.4146},
+	{ColourLAB{90.8027, -2.0831, 1.4410}, ColourLAB{91.1528, -1.6435, 0.0447}, 1.4441},
+	{ColourLAB{90.9257, -0.5406, -0.9208}, ColourLAB{88.6381, -0.8985, -0.7239}, 1.5381},
+	{ColourLAB{6.7747, -0.2908, -2.4247}, ColourLAB{5.8714, -0.0985, -2.2286}, 0.6377},
+	{ColourLAB{2.0776, 0.0795, -1.1350}, ColourLAB{0.9033, -0.0636, -0.5514}, 0.9082},
+}
+
+func TestDeltaE(t *testing.T) {
+	for i, pair := range ciede2000Pairs {
+		got := pair.first.DeltaE(pair.second)
+		if math.Abs(got-pair.deltaE) > 0.0001 {
+			t.Errorf("pair %d: DeltaE = %.4f, want %.4f", i+1, got, pair.deltaE)
+		}
+		// The formula is symmetric
+		if reverse := pair.second.DeltaE(pair.first); math.Abs(reverse-got) > 1e-9 {
+			t.Errorf("pair %d: reversed DeltaE = %.4f, want %.4f", i+1, reverse, got)
+		}
+	}
+}
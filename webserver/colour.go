@@ -1,46 +1,133 @@
 package muskoka
 
 import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/kataras/iris"
 	"github.com/kataras/iris/context"
 	"github.com/kataras/iris/core/router"
 )
 
+const (
+	ColourFinishPaint = "paint"
+	ColourFinishStain = "stain"
+)
+
 type Colour struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
+	// Hex is #rrggbb, RGB and LAB are worked out from it
+	Hex    string     `json:"hex,omitempty"`
+	RGB    *ColourRGB `json:"rgb,omitempty"`
+	LAB    *ColourLAB `json:"lab,omitempty"`
+	Family string     `json:"family,omitempty"`
+	Finish string     `json:"finish,omitempty"`
+	Swatch *Image     `json:"swatch,omitempty"`
 }
 
 func InitColour() {
 	createColourTable()
+	migrateColourTable()
 	createColourIndices()
 }
 
 func createColourTable() {
 	_, err := GetDBConnection().Exec(`CREATE TABLE IF NOT EXISTS colours (
 			id BIGSERIAL PRIMARY KEY,
-			name text NOT NULL
+			name text NOT NULL,
+			hex text,
+			rgb_red smallint,
+			rgb_green smallint,
+			rgb_blue smallint,
+			lab_l double precision,
+			lab_a double precision,
+			lab_b double precision,
+			family text,
+			finish text CHECK (finish IN ('paint', 'stain'))
 		);`)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// migrateColourTable adds the colour attributes to older databases
+func migrateColourTable() {
+	_, err := GetDBConnection().Exec(`
+		ALTER TABLE colours
+			ADD COLUMN IF NOT EXISTS hex text,
+			ADD COLUMN IF NOT EXISTS rgb_red smallint,
+			ADD COLUMN IF NOT EXISTS rgb_green smallint,
+			ADD COLUMN IF NOT EXISTS rgb_blue smallint,
+			ADD COLUMN IF NOT EXISTS lab_l double precision,
+			ADD COLUMN IF NOT EXISTS lab_a double precision,
+			ADD COLUMN IF NOT EXISTS lab_b double precision,
+			ADD COLUMN IF NOT EXISTS family text,
+			ADD COLUMN IF NOT EXISTS finish text CHECK (finish IN ('paint', 'stain'));`)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func createColourIndices() {
 	_, err := GetDBConnection().Exec(`CREATE UNIQUE INDEX IF NOT EXISTS colours__name__key ON colours (lower(name));`)
 	if err != nil {
 		panic(err)
 	}
+
+	_, err = GetDBConnection().Exec(`CREATE INDEX IF NOT EXISTS colours__family__idx ON colours (family);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// colourColumns are what scanColour reads, the swatch is joined with
+// joinColourSwatches
+const colourColumns = `colours.id, colours.name, COALESCE(colours.hex, ''),
+	COALESCE(colours.rgb_red, 0), COALESCE(colours.rgb_green, 0), COALESCE(colours.rgb_blue, 0),
+	COALESCE(colours.lab_l, 0), COALESCE(colours.lab_a, 0), COALESCE(colours.lab_b, 0),
+	COALESCE(colours.family, ''), COALESCE(colours.finish, ''),
+	COALESCE(swatches.id, 0), COALESCE(swatches.filename, ''), COALESCE(swatches.size, 0),
+	COALESCE(swatches.image_type_id, 0)`
+
+const joinColourSwatches = "LEFT JOIN images swatches ON swatches.colour_id = colours.id"
+
+func scanColour(row rowScanner) (*Colour, error) {
+	colour := &Colour{}
+	rgb := ColourRGB{}
+	lab := ColourLAB{}
+	swatch := Image{}
+	err := row.Scan(&colour.ID, &colour.Name, &colour.Hex, &rgb.R, &rgb.G, &rgb.B,
+		&lab.L, &lab.A, &lab.B, &colour.Family, &colour.Finish,
+		&swatch.ID, &swatch.Filename, &swatch.Size, &swatch.ImageType.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(colour.Hex) > 0 {
+		colour.RGB = &rgb
+		colour.LAB = &lab
+	}
+	if swatch.ID > 0 {
+		colour.Swatch = &swatch
+	}
+	return colour, nil
 }
 
 func CreateColourAPI(party router.Party) {
-	party.Get("/findOne/:id", findOneColourHandler)
-	party.Get("", findColoursHandler)
-	party.Post("", insertColourHandler)
-	party.Put("", updateOneColourHandler)
-	party.Delete("/:id", removeOneColourHandler)
+	cachePolicy := CatalogCachePolicy("colour")
+	party.Get("/findOne/:id", CacheMiddleware(cachePolicy), findOneColourHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findColoursHandler)
+	party.Post("", IdempotencyMiddleware, SingleHandler(colourBulkResource, "create"))
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(colourBulkResource))
+	party.Put("", SingleHandler(colourBulkResource, "update"))
+	party.Patch("/:id", AdminMiddleware, PatchHandler(colourPatchResource))
+	party.Delete("/:id", SingleHandler(colourBulkResource, "delete"))
 }
 
 func findOneColourHandler(ctx context.Context) {
@@ -51,179 +138,375 @@ func findOneColourHandler(ctx context.Context) {
 		return
 	}
 
-	colour := Colour{ID: id}
-	dbErr := GetDBConnection().QueryRow(`
-		SELECT name 
-		FROM colours
-		WHERE id = $1`,
-		colour.ID).Scan(&colour.Name)
-	if dbErr != nil {
+	if serveShaped(ctx, colourShape, shapeQuery{Where: "colours.id = $1", Args: []interface{}{id}, One: true},
+		"colour/"+strconv.FormatInt(id, 10)) {
+		return
+	}
+
+	colour, err := FindColourFromID(ctx.Request().Context(), id)
+	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(colour)
+	ServeCacheable(ctx, localize(ctx, colour), "colour/"+strconv.FormatInt(id, 10))
+}
+
+func FindColourFromID(ctx stdContext.Context, id int64) (*Colour, error) {
+	value, err := cachedRead("colour", strconv.FormatInt(id, 10), func() (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		return scanColour(GetDBConnection().QueryRowContext(queryCtx, `
+			SELECT `+colourColumns+`
+			FROM colours
+			`+joinColourSwatches+`
+			WHERE colours.id = $1`,
+			id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Colour), nil
+}
+
+// colourFilter reads the family and finish filters of the colour list
+func colourFilter(ctx context.Context) (family string, finish string, where string, args []interface{}) {
+	family = strings.ToLower(strings.TrimSpace(ctx.URLParam("family")))
+	finish = strings.ToLower(strings.TrimSpace(ctx.URLParam("finish")))
+
+	whereQueries := []string{}
+	if len(family) > 0 {
+		args = append(args, family)
+		whereQueries = append(whereQueries, fmt.Sprintf("colours.family = $%d", len(args)))
+	}
+	if len(finish) > 0 {
+		args = append(args, finish)
+		whereQueries = append(whereQueries, fmt.Sprintf("colours.finish = $%d", len(args)))
+	}
+	return family, finish, strings.Join(whereQueries, " AND "), args
 }
 
+// findColoursHandler lists the colours, optionally only a ?family= such as
+// grey or a ?finish= of paint or stain
 func findColoursHandler(ctx context.Context) {
-	rows, err := GetDBConnection().Query(`
-		SELECT id, name 
-		FROM colours
-		ORDER BY name`)
+	family, finish, where, args := colourFilter(ctx)
+	if serveExport(ctx, exportQuery{Name: "colours", From: "colours", Where: where, Args: args,
+		Order: localizedSQL("colour", "name"), Columns: colourExportColumns}) {
+		return
+	}
+	if serveShaped(ctx, colourShape, shapeQuery{Where: where, Args: args, Order: localizedSQL("colour", "name")}) {
+		return
+	}
+
+	colours, err := FindColours(ctx.Request().Context())
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
-	defer rows.Close()
 
-	colours := []Colour{}
-	for rows.Next() {
-		colour := Colour{}
-		err = rows.Scan(&colour.ID, &colour.Name)
-		if err != nil {
-			statusCode, errObj := HandleDBError(err)
-			ctx.StatusCode(statusCode)
-			ctx.JSON(errObj)
-			return
+	if len(family) > 0 || len(finish) > 0 {
+		// The cached list is shared, so filter a copy
+		filtered := []Colour{}
+		for _, colour := range *colours {
+			if (len(family) == 0 || colour.Family == family) && (len(finish) == 0 || colour.Finish == finish) {
+				filtered = append(filtered, colour)
+			}
 		}
-		colours = append(colours, colour)
+		colours = &filtered
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(colours)
+	ServeCacheable(ctx, localize(ctx, colours))
 }
 
-func insertColourHandler(ctx context.Context) {
-	colour := &Colour{}
-	if err := ctx.ReadJSON(colour); err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read colour"})
-		return
-	}
+func FindColours(ctx stdContext.Context) (*[]Colour, error) {
+	value, err := cachedRead("colour", "list", func() (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		rows, err := GetDBConnection().QueryContext(queryCtx, `
+			SELECT `+colourColumns+`
+			FROM colours
+			`+joinColourSwatches+`
+			ORDER BY colours.name`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		colours := []Colour{}
+		for rows.Next() {
+			colour, err := scanColour(rows)
+			if err != nil {
+				return nil, err
+			}
+			colours = append(colours, *colour)
+		}
 
-	err := GetDBConnection().QueryRow(`
-		INSERT INTO colours (name)
-		VALUES($1) returning id;`,
-		colour.Name).Scan(&colour.ID)
+		return &colours, rows.Err()
+	})
 	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+		return nil, err
 	}
-
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(colour)
+	return value.(*[]Colour), nil
 }
 
-func updateOneColourHandler(ctx context.Context) {
-	colour := &Colour{}
-	if err := ctx.ReadJSON(colour); err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read colour"})
-		return
+// readColour reads a colour sent to be saved over colour, validates it and
+// works out its RGB and LAB values from the hex. Fields left out of data
+// keep their value in colour.
+func readColour(colour *Colour, data json.RawMessage) (*Colour, error) {
+	if err := json.Unmarshal(data, colour); err != nil {
+		return nil, err
 	}
 
-	stmt, err := GetDBConnection().Prepare(`
-		UPDATE colours 
-		SET name=$1  
-		WHERE id=$2
-	`)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	validationErrors := ValidationErrors{}
+	if len(strings.TrimSpace(colour.Name)) == 0 {
+		validationErrors["name"] = "Name is required."
 	}
 
-	res, err := stmt.Exec(colour.Name, colour.ID)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	colour.RGB = nil
+	colour.LAB = nil
+	if len(colour.Hex) > 0 {
+		hex, rgb, err := parseHexColour(colour.Hex)
+		if err != nil {
+			validationErrors["hex"] = "Hex must be a colour like #a1b2c3."
+		} else {
+			lab := rgb.LAB()
+			// Two places is finer than anyone can see
+			lab.L = math.Round(lab.L*100) / 100
+			lab.A = math.Round(lab.A*100) / 100
+			lab.B = math.Round(lab.B*100) / 100
+			colour.Hex, colour.RGB, colour.LAB = hex, &rgb, &lab
+		}
 	}
 
-	err = stmt.Close()
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+	colour.Family = strings.ToLower(strings.TrimSpace(colour.Family))
+	colour.Finish = strings.ToLower(strings.TrimSpace(colour.Finish))
+	if colour.Finish != "" && colour.Finish != ColourFinishPaint && colour.Finish != ColourFinishStain {
+		validationErrors["finish"] = "Finish must be paint or stain."
 	}
 
-	affect, err := res.RowsAffected()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	if colour.Swatch != nil {
+		if len(colour.Swatch.Filename) == 0 {
+			validationErrors["swatch"] = "Swatch needs a filename."
+		} else if colour.Swatch.ImageType.ID < 1 {
+			validationErrors["imageType"] = "Image Type is required."
+		}
 	}
 
-	if affect < 1 {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No colour found"})
-		return
+	if len(validationErrors) > 0 {
+		return nil, validationErrors
 	}
+	return colour, nil
+}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(map[string]interface{}{})
+// colourValues are the colour's columns after the name, NULL when unset
+func colourValues(colour *Colour) []interface{} {
+	values := []interface{}{nil, nil, nil, nil, nil, nil, nil, nil, nil}
+	if colour.RGB != nil && colour.LAB != nil {
+		values[0] = colour.Hex
+		values[1], values[2], values[3] = colour.RGB.R, colour.RGB.G, colour.RGB.B
+		values[4], values[5], values[6] = colour.LAB.L, colour.LAB.A, colour.LAB.B
+	}
+	if len(colour.Family) > 0 {
+		values[7] = colour.Family
+	}
+	if len(colour.Finish) > 0 {
+		values[8] = colour.Finish
+	}
+	return values
 }
 
-func removeOneColourHandler(ctx context.Context) {
-	id, err := ctx.Params().GetInt64("id")
-	if err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read colour id"})
-		return
+// saveColourSwatch makes the colour's swatch image match colour.Swatch and
+// returns the upload to delete once committed, if any
+func saveColourSwatch(ctx stdContext.Context, tx *sql.Tx, colour *Colour) (string, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	oldSwatch := Image{}
+	err := tx.QueryRowContext(queryCtx, `
+		SELECT id, filename, size, image_type_id
+		FROM images
+		WHERE colour_id = $1`,
+		colour.ID).Scan(&oldSwatch.ID, &oldSwatch.Filename, &oldSwatch.Size, &oldSwatch.ImageType.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
 	}
+	hasSwatch := err == nil
 
-	stmt, err := GetDBConnection().Prepare("delete from colours where id=$1")
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	switch {
+	case colour.Swatch == nil && hasSwatch:
+		_, err = tx.ExecContext(queryCtx, `DELETE FROM images WHERE id = $1`, oldSwatch.ID)
+		return oldSwatch.Filename, err
+	case colour.Swatch == nil:
+		return "", nil
+	case !hasSwatch:
+		colour.Swatch.Filename = url.QueryEscape(colour.Swatch.Filename)
+		return "", tx.QueryRowContext(queryCtx, `
+			INSERT INTO images (filename, size, image_type_id, colour_id)
+			VALUES($1,$2,$3,$4)
+			returning id;`,
+			colour.Swatch.Filename, colour.Swatch.Size, colour.Swatch.ImageType.ID,
+			colour.ID).Scan(&colour.Swatch.ID)
 	}
 
-	res, err := stmt.Exec(id)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	colour.Swatch.ID = oldSwatch.ID
+	// Stored filenames are escaped, updates send them back as they are
+	if colour.Swatch.Filename != oldSwatch.Filename {
+		colour.Swatch.Filename = url.QueryEscape(colour.Swatch.Filename)
+	}
+	if colour.Swatch.Filename == oldSwatch.Filename && colour.Swatch.Size == oldSwatch.Size &&
+		colour.Swatch.ImageType.ID == oldSwatch.ImageType.ID {
+		return "", nil
 	}
+	_, err = tx.ExecContext(queryCtx, `
+		UPDATE images
+		SET filename = $1, size = $2, image_type_id = $3
+		WHERE id = $4`,
+		colour.Swatch.Filename, colour.Swatch.Size, colour.Swatch.ImageType.ID, oldSwatch.ID)
+	if err != nil || colour.Swatch.Filename == oldSwatch.Filename {
+		return "", err
+	}
+	return oldSwatch.Filename, nil
+}
+
+var colourBulkResource = BulkResource{
+	Entity: "colour",
+	Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		colour, err := readColour(&Colour{}, data)
+		if err != nil {
+			return BulkChange{}, err
+		}
 
-	err = stmt.Close()
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO colours (name, hex, rgb_red, rgb_green, rgb_blue, lab_l, lab_a, lab_b, family, finish)
+			VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) returning id;`,
+			append([]interface{}{colour.Name}, colourValues(colour)...)...).Scan(&colour.ID)
+		if err != nil {
+			return BulkChange{}, err
+		}
+
+		_, err = saveColourSwatch(ctx, tx, colour)
+		return BulkChange{ID: colour.ID, Data: colour}, err
+	},
+	// Only the fields sent change, e.g. {"id": 1, "name": "White"} keeps the
+	// hex and swatch. A null swatch removes it.
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		var sent struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(data, &sent); err != nil {
+			return BulkChange{}, err
+		}
+		current, err := findColourForUpdate(ctx, tx, sent.ID)
+		if err != nil {
+			return BulkChange{ID: sent.ID}, err
+		}
+		colour, err := readColour(current, data)
+		if err != nil {
+			return BulkChange{ID: sent.ID}, err
+		}
+		colour.ID = sent.ID
+		return saveColour(ctx, tx, colour)
+	},
+	Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		// The swatch goes with the colour through ON DELETE CASCADE
+		filenames, err := colourSwatchFilenames(queryCtx, tx, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+
+		res, err := tx.ExecContext(queryCtx, `DELETE FROM colours WHERE id=$1`, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return BulkChange{}, err
+		}
+		return BulkChange{AfterCommit: deleteS3ObjectAfterCommit(ctx, filenames...)}, nil
+	},
+}
+
+// colourPatchResource gets the whole merged colour, so anything left out
+// of it is cleared
+var colourPatchResource = PatchResource{
+	Entity: "colour",
+	Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+		return findColourForUpdate(ctx, tx, id)
+	},
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		colour, err := readColour(&Colour{}, data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		return saveColour(ctx, tx, colour)
+	},
+}
+
+func findColourForUpdate(ctx stdContext.Context, tx *sql.Tx, id int64) (*Colour, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	return scanColour(tx.QueryRowContext(queryCtx, `
+		SELECT `+colourColumns+`
+		FROM colours
+		`+joinColourSwatches+`
+		WHERE colours.id = $1
+		FOR UPDATE OF colours`,
+		id))
+}
+
+// saveColour updates every column of the colour and its swatch
+func saveColour(ctx stdContext.Context, tx *sql.Tx, colour *Colour) (BulkChange, error) {
+	change := BulkChange{ID: colour.ID, Data: colour}
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	res, err := tx.ExecContext(queryCtx, `
+		UPDATE colours
+		SET name=$1, hex=$2, rgb_red=$3, rgb_green=$4, rgb_blue=$5, lab_l=$6, lab_a=$7, lab_b=$8,
+			family=$9, finish=$10
+		WHERE id=$11`,
+		append(append([]interface{}{colour.Name}, colourValues(colour)...), colour.ID)...)
 	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+		return change, err
+	}
+	if err = requireRowsAffected(res); err != nil {
+		return change, err
 	}
 
-	affect, err := res.RowsAffected()
+	oldFilename, err := saveColourSwatch(ctx, tx, colour)
 	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+		return change, err
+	}
+	if len(oldFilename) > 0 {
+		change.AfterCommit = deleteS3ObjectAfterCommit(ctx, oldFilename)
 	}
+	return change, nil
+}
 
-	if affect < 1 {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No colour found"})
-		return
+func colourSwatchFilenames(ctx stdContext.Context, tx *sql.Tx, id int64) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT filename FROM images WHERE colour_id = $1`, id)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	ctx.StatusCode(iris.StatusOK)
-	idString := strconv.FormatInt(id, 10)
-	ctx.JSON(map[string]interface{}{
-		"id": idString,
-	})
+	filenames := []string{}
+	for rows.Next() {
+		var filename string
+		if err = rows.Scan(&filename); err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames, rows.Err()
 }
@@ -0,0 +1,541 @@
+package muskoka
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	LogLevel slog.Level
+
+	DB DBConfig
+
+	TraceExporter    string
+	TraceSampleRatio float64
+
+	Addr             string
+	RedirectAddr     string
+	TLSCertFile      string
+	TLSKeyFile       string
+	AutocertDomains  []string
+	AutocertEmail    string
+	AutocertCacheDir string
+
+	TrustedProxies []*net.IPNet
+
+	CacheTTL        time.Duration
+	CacheMaxEntries int
+
+	CatalogCacheControl string
+
+	DefaultLocale string
+	Locales       []string
+
+	PlaceholderImage string
+
+	GraphQLMaxDepth                   int
+	GraphQLMaxComplexity              int
+	GraphQLMaxIntrospectionDepth      int
+	GraphQLMaxIntrospectionComplexity int
+
+	BulkMaxOperations int
+
+	WebhookPollInterval time.Duration
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookBackoff      time.Duration
+	WebhookMaxBackoff   time.Duration
+
+	EventLogSize   int
+	EventHeartbeat time.Duration
+	EventRetry     time.Duration
+
+	IdempotencyWindow      time.Duration
+	IdempotencyLockTimeout time.Duration
+	IdempotencyMaxBody     int
+
+	ReadyCheckTimeout  time.Duration
+	ReadyCheckCacheTTL time.Duration
+	QueryTimeout       time.Duration
+	ExportTimeout      time.Duration
+	ShutdownTimeout    time.Duration
+	ShutdownDelay      time.Duration
+
+	MetricsAllowlist []*net.IPNet
+	MetricsToken     string
+
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSExposedHeaders []string
+	CORSMaxAge         int
+
+	STSSeconds            int
+	STSIncludeSubdomains  bool
+	STSPreload            bool
+	FrameOptions          string
+	ContentSecurityPolicy string
+	ReferrerPolicy        string
+
+	RegisterRateLimit  RateLimit
+	VerifyRateLimit    RateLimit
+	LoginRateLimit     RateLimit
+	SignedURLRateLimit RateLimit
+
+	APIKeys []string
+
+	JWTSecret []byte
+}
+
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+const minJWTSecretLength = 32
+
+var config *Config
+var configOnce sync.Once
+
+// InitConfig loads the config, so commands can report a bad variable rather
+// than panicking in GetConfig
+func InitConfig() error {
+	var err error
+	configOnce.Do(func() {
+		config, err = loadConfig()
+	})
+	return err
+}
+
+func GetConfig() *Config {
+	configOnce.Do(func() {
+		var err error
+		config, err = loadConfig()
+		if err != nil {
+			panic(err)
+		}
+	})
+	return config
+}
+
+func loadConfig() (*Config, error) {
+	c := &Config{}
+
+	var err error
+
+	if err := c.LogLevel.UnmarshalText([]byte(getEnvString("MUSKOKA_LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("MUSKOKA_LOG_LEVEL: %v", err)
+	}
+
+	c.DB, err = loadDBConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c.TraceExporter = getEnvString("MUSKOKA_TRACE_EXPORTER", "none")
+	c.TraceSampleRatio, err = getEnvFloat("MUSKOKA_TRACE_SAMPLE_RATIO", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Addr = getEnvString("MUSKOKA_ADDR", ":8080")
+	c.RedirectAddr = getEnvString("MUSKOKA_REDIRECT_ADDR", "")
+	c.TLSCertFile = getEnvString("MUSKOKA_TLS_CERT_FILE", "")
+	c.TLSKeyFile = getEnvString("MUSKOKA_TLS_KEY_FILE", "")
+	c.AutocertDomains = getEnvList("MUSKOKA_AUTOCERT_DOMAINS", nil)
+	c.AutocertEmail = getEnvString("MUSKOKA_AUTOCERT_EMAIL", "")
+	c.AutocertCacheDir = getEnvString("MUSKOKA_AUTOCERT_CACHE_DIR", "autocert-cache")
+	if len(c.TLSCertFile) > 0 && len(c.TLSKeyFile) == 0 {
+		return nil, fmt.Errorf("MUSKOKA_TLS_KEY_FILE is required with MUSKOKA_TLS_CERT_FILE")
+	}
+	// Let's Encrypt only sends http-01 challenges to port 80
+	if len(c.AutocertDomains) > 0 {
+		c.RedirectAddr = getEnvString("MUSKOKA_REDIRECT_ADDR", ":80")
+		if len(c.RedirectAddr) == 0 {
+			return nil, fmt.Errorf("MUSKOKA_REDIRECT_ADDR is required with MUSKOKA_AUTOCERT_DOMAINS")
+		}
+	}
+
+	c.TrustedProxies, err = getEnvCIDRs("MUSKOKA_TRUSTED_PROXIES")
+	if err != nil {
+		return nil, err
+	}
+
+	// A zero ttl turns the catalog cache off
+	c.CacheTTL, err = getEnvDuration("MUSKOKA_CACHE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	c.CacheMaxEntries, err = getEnvInt("MUSKOKA_CACHE_MAX_ENTRIES", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	// Browsers revalidate after a minute, CDNs hold on longer and are purged
+	// by Surrogate-Key
+	c.CatalogCacheControl = getEnvString("MUSKOKA_CATALOG_CACHE_CONTROL",
+		"public, max-age=60, s-maxage=300, stale-while-revalidate=30")
+
+	// The default locale is what the catalog tables themselves hold, the
+	// others are translations
+	c.DefaultLocale = strings.ToLower(getEnvString("MUSKOKA_DEFAULT_LOCALE", "en"))
+	c.Locales = []string{}
+	hasDefaultLocale := false
+	for _, locale := range getEnvList("MUSKOKA_LOCALES", []string{"en", "fr"}) {
+		locale = strings.ToLower(locale)
+		c.Locales = append(c.Locales, locale)
+		hasDefaultLocale = hasDefaultLocale || locale == c.DefaultLocale
+	}
+	if !hasDefaultLocale {
+		return nil, fmt.Errorf("MUSKOKA_LOCALES must include MUSKOKA_DEFAULT_LOCALE %s", c.DefaultLocale)
+	}
+
+	// Door samples without an image are listed with this upload instead
+	c.PlaceholderImage = getEnvString("MUSKOKA_PLACEHOLDER_IMAGE", "placeholder.png")
+
+	c.GraphQLMaxDepth, err = getEnvInt("MUSKOKA_GRAPHQL_MAX_DEPTH", 8)
+	if err != nil {
+		return nil, err
+	}
+	c.GraphQLMaxComplexity, err = getEnvInt("MUSKOKA_GRAPHQL_MAX_COMPLEXITY", 1000)
+	if err != nil {
+		return nil, err
+	}
+	// The usual introspection query that tools send goes 13 deep through
+	// nested type references
+	c.GraphQLMaxIntrospectionDepth, err = getEnvInt("MUSKOKA_GRAPHQL_MAX_INTROSPECTION_DEPTH", 15)
+	if err != nil {
+		return nil, err
+	}
+	c.GraphQLMaxIntrospectionComplexity, err = getEnvInt("MUSKOKA_GRAPHQL_MAX_INTROSPECTION_COMPLEXITY", 500)
+	if err != nil {
+		return nil, err
+	}
+
+	c.BulkMaxOperations, err = getEnvInt("MUSKOKA_BULK_MAX_OPERATIONS", 500)
+	if err != nil {
+		return nil, err
+	}
+
+	c.WebhookPollInterval, err = getEnvDuration("MUSKOKA_WEBHOOK_POLL_INTERVAL", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c.WebhookTimeout, err = getEnvDuration("MUSKOKA_WEBHOOK_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// With the default backoff the last of 10 attempts is about 3 hours after
+	// the first
+	c.WebhookMaxAttempts, err = getEnvInt("MUSKOKA_WEBHOOK_MAX_ATTEMPTS", 10)
+	if err != nil {
+		return nil, err
+	}
+	c.WebhookBackoff, err = getEnvDuration("MUSKOKA_WEBHOOK_BACKOFF", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c.WebhookMaxBackoff, err = getEnvDuration("MUSKOKA_WEBHOOK_MAX_BACKOFF", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	c.EventLogSize, err = getEnvInt("MUSKOKA_EVENT_LOG_SIZE", 1000)
+	if err != nil {
+		return nil, err
+	}
+	// Proxies close idle connections, usually after a minute
+	c.EventHeartbeat, err = getEnvDuration("MUSKOKA_EVENT_HEARTBEAT", 25*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c.EventRetry, err = getEnvDuration("MUSKOKA_EVENT_RETRY", 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c.IdempotencyWindow, err = getEnvDuration("MUSKOKA_IDEMPOTENCY_WINDOW", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	// A key still in progress after this is taken to be from a crashed request
+	c.IdempotencyLockTimeout, err = getEnvDuration("MUSKOKA_IDEMPOTENCY_LOCK_TIMEOUT", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	// Bodies are read into memory to hash them
+	c.IdempotencyMaxBody, err = getEnvInt("MUSKOKA_IDEMPOTENCY_MAX_BODY", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ReadyCheckTimeout, err = getEnvDuration("MUSKOKA_READY_CHECK_TIMEOUT", 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// S3 and SES are checked at most this often, probes come every few seconds
+	c.ReadyCheckCacheTTL, err = getEnvDuration("MUSKOKA_READY_CHECK_CACHE_TTL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c.QueryTimeout, err = getEnvDuration("MUSKOKA_QUERY_TIMEOUT", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// CSV and XLSX exports read whole tables
+	c.ExportTimeout, err = getEnvDuration("MUSKOKA_EXPORT_TIMEOUT", 2*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	c.ShutdownTimeout, err = getEnvDuration("MUSKOKA_SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// Time for load balancers to see /readyz fail before we stop accepting
+	c.ShutdownDelay, err = getEnvDuration("MUSKOKA_SHUTDOWN_DELAY", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.MetricsAllowlist, err = getEnvCIDRs("MUSKOKA_METRICS_ALLOWLIST")
+	if err != nil {
+		return nil, err
+	}
+	c.MetricsToken = getEnvString("MUSKOKA_METRICS_TOKEN", "")
+
+	c.CORSAllowedOrigins = getEnvList("MUSKOKA_CORS_ALLOWED_ORIGINS", []string{
+		"https://www.muskokacabco.com",
+		"https://muskokacabco.com",
+	})
+	c.CORSAllowedMethods = getEnvList("MUSKOKA_CORS_ALLOWED_METHODS", []string{
+		"OPTIONS", "GET", "PUT", "PATCH", "POST", "DELETE",
+	})
+	c.CORSAllowedHeaders = getEnvList("MUSKOKA_CORS_ALLOWED_HEADERS", []string{
+		"X-Requested-With", "Content-Type", "Authorization", "If-None-Match", "If-Modified-Since",
+		"Last-Event-ID", "Idempotency-Key", "X-Request-ID",
+	})
+	c.CORSExposedHeaders = getEnvList("MUSKOKA_CORS_EXPOSED_HEADERS", []string{
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+		"ETag", "Last-Modified", "Idempotent-Replayed", "X-Request-ID",
+	})
+	c.CORSMaxAge, err = getEnvInt("MUSKOKA_CORS_MAX_AGE", 600)
+	if err != nil {
+		return nil, err
+	}
+
+	c.STSSeconds, err = getEnvInt("MUSKOKA_STS_SECONDS", 31536000)
+	if err != nil {
+		return nil, err
+	}
+	c.STSIncludeSubdomains, err = getEnvBool("MUSKOKA_STS_INCLUDE_SUBDOMAINS", true)
+	if err != nil {
+		return nil, err
+	}
+	c.STSPreload, err = getEnvBool("MUSKOKA_STS_PRELOAD", false)
+	if err != nil {
+		return nil, err
+	}
+	c.FrameOptions = getEnvString("MUSKOKA_FRAME_OPTIONS", "DENY")
+	c.ContentSecurityPolicy = getEnvString("MUSKOKA_CONTENT_SECURITY_POLICY", "default-src 'none'; frame-ancestors 'none'")
+	c.ReferrerPolicy = getEnvString("MUSKOKA_REFERRER_POLICY", "strict-origin-when-cross-origin")
+
+	c.RegisterRateLimit, err = getEnvRateLimit("MUSKOKA_RATE_LIMIT_REGISTER", RateLimit{5, time.Hour})
+	if err != nil {
+		return nil, err
+	}
+	c.VerifyRateLimit, err = getEnvRateLimit("MUSKOKA_RATE_LIMIT_VERIFY", RateLimit{10, time.Minute})
+	if err != nil {
+		return nil, err
+	}
+	c.LoginRateLimit, err = getEnvRateLimit("MUSKOKA_RATE_LIMIT_LOGIN", RateLimit{10, time.Minute})
+	if err != nil {
+		return nil, err
+	}
+	c.SignedURLRateLimit, err = getEnvRateLimit("MUSKOKA_RATE_LIMIT_SIGNED_URL", RateLimit{60, time.Minute})
+	if err != nil {
+		return nil, err
+	}
+
+	// Partners sending one of these as X-API-Key get their own rate limit
+	// bucket, any other key is ignored
+	c.APIKeys = getEnvList("MUSKOKA_API_KEYS", nil)
+
+	// Signs login tokens, anyone who knows it can make themselves an admin
+	c.JWTSecret = []byte(getEnvString("MUSKOKA_JWT_SECRET", ""))
+	if len(c.JWTSecret) < minJWTSecretLength {
+		return nil, fmt.Errorf("MUSKOKA_JWT_SECRET is required and must be at least %d characters",
+			minJWTSecretLength)
+	}
+
+	return c, nil
+}
+
+func loadDBConfig() (DBConfig, error) {
+	c := DBConfig{
+		// A directory is the unix socket in it, lib/pq would use localhost
+		Host:     getEnvString("MUSKOKA_DB_HOST", "/var/run/postgresql"),
+		User:     getEnvString("MUSKOKA_DB_USER", "postgres"),
+		Password: getEnvString("MUSKOKA_DB_PASSWORD", ""),
+		Name:     getEnvString("MUSKOKA_DB_NAME", "muskoka"),
+		SSLMode:  getEnvString("MUSKOKA_DB_SSLMODE", "disable"),
+	}
+
+	var err error
+	if c.Port, err = getEnvInt("MUSKOKA_DB_PORT", 5432); err != nil {
+		return c, err
+	}
+	if c.MaxOpenConns, err = getEnvInt("MUSKOKA_DB_MAX_OPEN_CONNS", 20); err != nil {
+		return c, err
+	}
+	if c.MaxIdleConns, err = getEnvInt("MUSKOKA_DB_MAX_IDLE_CONNS", 10); err != nil {
+		return c, err
+	}
+	if c.ConnMaxLifetime, err = getEnvDuration("MUSKOKA_DB_CONN_MAX_LIFETIME", 30*time.Minute); err != nil {
+		return c, err
+	}
+	if c.ConnMaxIdleTime, err = getEnvDuration("MUSKOKA_DB_CONN_MAX_IDLE_TIME", 5*time.Minute); err != nil {
+		return c, err
+	}
+	if c.ConnectAttempts, err = getEnvInt("MUSKOKA_DB_CONNECT_ATTEMPTS", 10); err != nil {
+		return c, err
+	}
+	if c.ConnectBackoff, err = getEnvDuration("MUSKOKA_DB_CONNECT_BACKOFF", 500*time.Millisecond); err != nil {
+		return c, err
+	}
+
+	if c.MaxIdleConns > c.MaxOpenConns {
+		return c, fmt.Errorf("MUSKOKA_DB_MAX_IDLE_CONNS can't be more than MUSKOKA_DB_MAX_OPEN_CONNS")
+	}
+	if c.ConnectAttempts < 1 {
+		return c, fmt.Errorf("MUSKOKA_DB_CONNECT_ATTEMPTS must be at least 1")
+	}
+	return c, nil
+}
+
+func getEnvString(key string, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) (int, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return def, fmt.Errorf("%s: %v", key, err)
+	}
+	return i, nil
+}
+
+func getEnvDuration(key string, def time.Duration) (time.Duration, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return def, fmt.Errorf("%s: %v", key, err)
+	}
+	return d, nil
+}
+
+func getEnvFloat(key string, def float64) (float64, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return def, fmt.Errorf("%s: %v", key, err)
+	}
+	return f, nil
+}
+
+func getEnvBool(key string, def bool) (bool, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return def, fmt.Errorf("%s: %v", key, err)
+	}
+	return b, nil
+}
+
+func getEnvList(key string, def []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	list := []string{}
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if len(element) > 0 {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+func getEnvCIDRs(key string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, element := range getEnvList(key, nil) {
+		// Allow bare addresses as well as ranges
+		if !strings.Contains(element, "/") {
+			if strings.Contains(element, ":") {
+				element += "/128"
+			} else {
+				element += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(element)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// getEnvRateLimit reads a limit in the form "<requests>/<period>", e.g. "10/1m"
+func getEnvRateLimit(key string, def RateLimit) (RateLimit, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return def, fmt.Errorf("%s: expected <requests>/<period>, got %q", key, value)
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit < 1 {
+		return def, fmt.Errorf("%s: invalid request count %q", key, parts[0])
+	}
+
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || period <= 0 {
+		return def, fmt.Errorf("%s: invalid period %q", key, parts[1])
+	}
+
+	return RateLimit{Limit: limit, Period: period}, nil
+}
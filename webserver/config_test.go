@@ -0,0 +1,46 @@
+package muskoka
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// GetConfig won't load without a secret
+	if _, ok := os.LookupEnv("MUSKOKA_JWT_SECRET"); !ok {
+		os.Setenv("MUSKOKA_JWT_SECRET", strings.Repeat("t", minJWTSecretLength))
+	}
+	os.Exit(m.Run())
+}
+
+func TestLoadConfigJWTSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		set     bool
+		wantErr bool
+	}{
+		{"unset", "", false, true},
+		{"empty", "", true, true},
+		{"too short", "secret", true, true},
+		{"long enough", strings.Repeat("s", minJWTSecretLength), true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("MUSKOKA_JWT_SECRET", test.secret)
+			if !test.set {
+				os.Unsetenv("MUSKOKA_JWT_SECRET")
+			}
+
+			c, err := loadConfig()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil && string(c.JWTSecret) != test.secret {
+				t.Errorf("JWTSecret = %q, want %q", c.JWTSecret, test.secret)
+			}
+		})
+	}
+}
@@ -1,53 +1,246 @@
 package muskoka
 
 import (
+	stdContext "context"
 	"database/sql"
 	"fmt"
+	"github.com/XSAM/otelsql"
 	"github.com/kataras/iris"
 	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres"
-	password = "1930Haig"
-	dbname   = "muskoka"
-)
+type DBConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+}
+
+// ConnectionString leaves out an empty host and password, so the driver
+// falls back to PGHOST and PGPASSWORD or .pgpass
+func (c DBConfig) ConnectionString() string {
+	params := []string{}
+	if len(c.Host) > 0 {
+		params = append(params, "host="+connectionValue(c.Host))
+	}
+	params = append(params, fmt.Sprintf("port=%d", c.Port), "user="+connectionValue(c.User))
+	if len(c.Password) > 0 {
+		params = append(params, "password="+connectionValue(c.Password))
+	}
+	params = append(params, "dbname="+connectionValue(c.Name), "sslmode="+connectionValue(c.SSLMode))
+	return strings.Join(params, " ")
+}
+
+// connectionValue quotes a value with spaces or quotes in it
+func connectionValue(value string) string {
+	if len(value) > 0 && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
 
 var dbConn *sql.DB
-var once sync.Once
+var dbStatsCollector prometheus.Collector
+var dbMu sync.RWMutex
 
-func GetDBConnection() *sql.DB {
-	once.Do(func() {
+// schemaTables is checked by the readiness probe
+var schemaTables = []string{
+	"colours", "wood", "door_style_types", "door_styles", "door_style_door_style_types",
+	"door_style_compatibility",
+	"image_types", "door_samples", "gallery_samples", "images", "dealers", "users",
+	"catalog_versions", "webhook_subscriptions", "webhook_deliveries",
+	"catalog_events", "idempotency_keys", "colour_translations", "wood_translations",
+	"door_style_type_translations", "door_style_translations", "dealer_translations",
+}
+var schemaReady atomic.Bool
 
-		connectionString := fmt.Sprintf("user=%s "+
-			"password=%s dbname=%s sslmode=disable",
-			user, password, dbname)
+// ConnectDB opens the pool from config, retrying while the database comes up
+func ConnectDB(ctx stdContext.Context) error {
+	db, err := OpenDB(ctx, GetConfig().DB)
+	if err != nil {
+		return err
+	}
+	SetDBConnection(db)
+	return nil
+}
+
+// dbQueryer is a *sql.DB or *sql.Tx, for reads that can run in a
+// transaction or outside one
+type dbQueryer interface {
+	QueryContext(ctx stdContext.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx stdContext.Context, query string, args ...interface{}) *sql.Row
+}
 
-        var err error
-        dbConn, err = sql.Open("postgres", connectionString)
+// GetDBConnection connects on first use and panics if the database can't be
+// reached, call ConnectDB at startup to handle that instead.
+func GetDBConnection() *sql.DB {
+	dbMu.RLock()
+	db := dbConn
+	dbMu.RUnlock()
+	if db != nil {
+		return db
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if dbConn == nil {
+		db, err := OpenDB(stdContext.Background(), GetConfig().DB)
 		if err != nil {
 			panic(err)
 		}
-	
-		err = dbConn.Ping()
-		if err != nil {
-			panic(err)
+		setDBConnectionLocked(db)
+	}
+	return dbConn
+}
+
+// SetDBConnection replaces the pool, e.g. with one pointed at a test database.
+// The old pool is not closed.
+func SetDBConnection(db *sql.DB) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	setDBConnectionLocked(db)
+}
+
+func setDBConnectionLocked(db *sql.DB) {
+	if dbStatsCollector != nil {
+		prometheus.Unregister(dbStatsCollector)
+		dbStatsCollector = nil
+	}
+
+	dbConn = db
+	if db != nil {
+		dbStatsCollector = collectors.NewDBStatsCollector(db, GetConfig().DB.Name)
+		prometheus.MustRegister(dbStatsCollector)
+	}
+}
+
+// OpenDB builds a pool and pings it, backing off exponentially between
+// attempts.
+func OpenDB(ctx stdContext.Context, config DBConfig) (*sql.DB, error) {
+	db, err := otelsql.Open("postgres", config.ConnectionString(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	backoff := config.ConnectBackoff
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := stdContext.WithTimeout(ctx, 5*time.Second)
+		err = db.PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= config.ConnectAttempts {
+			db.Close()
+			return nil, fmt.Errorf("unable to connect to database after %d attempts: %v", attempt, err)
+		}
+
+		LoggerFrom(ctx).Warn("database not reachable, retrying",
+			"attempt", attempt, "backoff", backoff.String(), "error", err)
+
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > 30*time.Second {
+			backoff = 30 * time.Second
 		}
+	}
+}
 
-        dbConn.SetMaxOpenConns(20) // Sane default
-        dbConn.SetMaxIdleConns(0)
-        dbConn.SetConnMaxLifetime(time.Nanosecond)
-    })
-    return dbConn
+func DBStats() sql.DBStats {
+	return GetDBConnection().Stats()
 }
 
+// withQueryTimeout bounds a single statement. The parent is usually the
+// request context, so a client that goes away cancels the query too.
+func withQueryTimeout(ctx stdContext.Context) (stdContext.Context, stdContext.CancelFunc) {
+	return stdContext.WithTimeout(ctx, GetConfig().QueryTimeout)
+}
+
+// InitSchema creates the tables in dependency order
+func InitSchema() {
+	InitColour()
+	InitWood()
+	InitDoorStyleType()
+	InitDoorStyle()
+	InitDoorStyleDoorStyleType()
+	InitDoorStyleCompatibility()
+	InitImageType()
+	InitDoorSample()
+	InitGallerySample()
+	InitImage()
+	InitDealer()
+	InitUser()
+	InitTranslations()
+	InitCatalogVersions()
+	InitWebhook()
+	InitCatalogEvents()
+	InitIdempotency()
+
+	schemaReady.Store(true)
+}
+
+// VerifySchema marks the schema ready without creating anything, for serving
+// when migrate is run separately. It fails if a table is missing.
+func VerifySchema(ctx stdContext.Context) error {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if err := checkSchemaTables(queryCtx); err != nil {
+		return err
+	}
+	schemaReady.Store(true)
+	return nil
+}
+
+// MigrateSchema is InitSchema returning the error it stopped on
+func MigrateSchema() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	InitSchema()
+	return nil
+}
 
+// CloseDb keeps the closed pool in place, so anything still running fails
+// its queries rather than GetDBConnection reconnecting
 func CloseDb() {
+	dbMu.Lock()
+	defer dbMu.Unlock()
 	if dbConn != nil {
 		dbConn.Close()
 	}
@@ -0,0 +1,126 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDBConfigConnectionString(t *testing.T) {
+	tests := []struct {
+		name   string
+		config DBConfig
+		want   string
+	}{
+		{
+			"unix socket without a password",
+			DBConfig{Host: "/var/run/postgresql", Port: 5432, User: "postgres", Name: "muskoka", SSLMode: "disable"},
+			"host=/var/run/postgresql port=5432 user=postgres dbname=muskoka sslmode=disable",
+		},
+		{
+			"no host",
+			DBConfig{Port: 5432, User: "postgres", Name: "muskoka", SSLMode: "disable"},
+			"port=5432 user=postgres dbname=muskoka sslmode=disable",
+		},
+		{
+			"quoted password",
+			DBConfig{Host: "db", Port: 6432, User: "muskoka", Password: `it's a \secret`, Name: "muskoka", SSLMode: "require"},
+			`host=db port=6432 user=muskoka password='it\'s a \\secret' dbname=muskoka sslmode=require`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.config.ConnectionString(); got != test.want {
+				t.Errorf("ConnectionString() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// openBenchmarkDB connects to MUSKOKA_TEST_DB_NAME, with the rest of the
+// connection from the usual MUSKOKA_DB_ variables, and seeds it. The
+// database is migrated and written to, don't point it at a real one.
+func openBenchmarkDB(b *testing.B, doorSamples int) {
+	name := os.Getenv("MUSKOKA_TEST_DB_NAME")
+	if len(name) == 0 {
+		b.Skip("MUSKOKA_TEST_DB_NAME is not set")
+	}
+
+	config := GetConfig().DB
+	config.Name = name
+	db, err := OpenDB(stdContext.Background(), config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	SetDBConnection(db)
+	b.Cleanup(CloseDb)
+
+	if err := MigrateSchema(); err != nil {
+		b.Fatal(err)
+	}
+
+	fixtures := &Fixtures{
+		Colours:        []string{"Benchmark White", "Benchmark Grey", "Benchmark Black"},
+		Wood:           []string{"Benchmark Maple", "Benchmark Oak"},
+		DoorStyleTypes: []string{"Benchmark Type"},
+		ImageTypes:     []ImageType{{Name: "benchmark"}},
+	}
+	for i := 0; i < 5; i++ {
+		fixtures.DoorStyles = append(fixtures.DoorStyles, DoorStyleFixture{
+			Name:           fmt.Sprintf("Benchmark Style %d", i),
+			DoorStyleTypes: []string{"Benchmark Type"},
+		})
+	}
+	for i := 0; i < doorSamples; i++ {
+		fixtures.DoorSamples = append(fixtures.DoorSamples, DoorSampleFixture{
+			DoorStyle: fixtures.DoorStyles[i%len(fixtures.DoorStyles)].Name,
+			Wood:      fixtures.Wood[i%len(fixtures.Wood)],
+			Colour:    fixtures.Colours[i%len(fixtures.Colours)],
+			Image:     ImageFixture{Filename: fmt.Sprintf("benchmark-%d.jpg", i), ImageType: "benchmark"},
+		})
+	}
+	// Seeding again only adds what's missing
+	if _, err := fixtures.Seed(stdContext.Background()); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkFindDoorSamples reads past the cache, it's the query being
+// measured
+func BenchmarkFindDoorSamples(b *testing.B) {
+	openBenchmarkDB(b, 1000)
+
+	benchmarks := []struct {
+		name   string
+		search DoorSampleSearch
+	}{
+		{"all", DoorSampleSearch{}},
+		{"colour", DoorSampleSearch{ColourIDs: []int{1}}},
+		{"colours and wood", DoorSampleSearch{ColourIDs: []int{1, 2}, WoodIDs: []int{1}}},
+		{"search text", DoorSampleSearch{SearchText: "benchmark style 3"}},
+		{"wood filter", DoorSampleSearch{Wood: WoodFilter{PriceTiers: []string{"standard", "premium"}}}},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				search := benchmark.search
+				if _, err := loadDoorSamples(stdContext.Background(), &search); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindDoorStyles(b *testing.B) {
+	openBenchmarkDB(b, 0)
+
+	for i := 0; i < b.N; i++ {
+		if _, err := loadDoorStyles(stdContext.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package muskoka
 
 import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/kataras/iris"
 	"github.com/kataras/iris/context"
@@ -14,6 +18,7 @@ type Dealer struct {
 	Name        string `json:"name"`
 	Link        string `json:"link"`
 	Location    string `json:"location"`
+	Description string `json:"description,omitempty"`
 	PhoneNumber int64  `json:"phoneNumber"`
 	Email       string `json:"email"`
 	OrderNum    int64  `json:"orderNum"`
@@ -22,6 +27,7 @@ type Dealer struct {
 
 func InitDealer() {
 	createDealerTable()
+	migrateDealerTable()
 	createDealerIndices()
 }
 
@@ -33,13 +39,22 @@ func createDealerTable() {
 			location text,
 			phone_num BIGINT,
 			email string,
-			order_num integer NOT NULL
+			order_num integer NOT NULL,
+			description text
 		);`)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// migrateDealerTable adds the description to older databases
+func migrateDealerTable() {
+	_, err := GetDBConnection().Exec(`ALTER TABLE dealers ADD COLUMN IF NOT EXISTS description text;`)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func createDealerIndices() {
 	_, err := GetDBConnection().Exec(`CREATE UNIQUE INDEX IF NOT EXISTS dealers__name__key ON dealers (lower(name));`)
 	if err != nil {
@@ -53,10 +68,13 @@ func createDealerIndices() {
 }
 
 func CreateDealerAPI(party router.Party) {
-	party.Get("/findOne/:id", findOneDealerHandler)
-	party.Get("", findDealersHandler)
-	party.Post("", insertDealerHandler)
+	cachePolicy := CatalogCachePolicy("dealer")
+	party.Get("/findOne/:id", CacheMiddleware(cachePolicy), findOneDealerHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findDealersHandler)
+	party.Post("", IdempotencyMiddleware, insertDealerHandler)
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(dealerBulkResource))
 	party.Put("", updateOneDealerHandler)
+	party.Patch("/:id", AdminMiddleware, PatchHandler(dealerPatchResource))
 	party.Delete("/:id", removeOneDealerHandler)
 }
 
@@ -68,18 +86,14 @@ func findOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	dealer := Dealer{ID: id}
-	err = GetDBConnection().QueryRow(`
-		SELECT dealers.name, dealers.link, dealers.location, dealers.phone_num, 
-				dealers.email, dealers.order_num,
-			images.id, images.filename, images.size
-		FROM dealers
-		INNER JOIN images ON dealers.id = images.dealer_id
-		WHERE dealers.id = $1
-		`, dealer.ID).Scan(
-		&dealer.Name, &dealer.Link, &dealer.Location, &dealer.PhoneNumber,
-		&dealer.Email, &dealer.OrderNum,
-		&dealer.Image.ID, &dealer.Image.Filename, &dealer.Image.Size)
+	if serveShaped(ctx, dealerShape, shapeQuery{
+		Joins: []string{joinDealerImages},
+		Where: "dealers.id = $1", Args: []interface{}{id}, One: true,
+	}, "dealer/"+strconv.FormatInt(id, 10)) {
+		return
+	}
+
+	dealer, err := FindDealerFromID(ctx.Request().Context(), id)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -87,12 +101,47 @@ func findOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(dealer)
+	ServeCacheable(ctx, localize(ctx, dealer), "dealer/"+strconv.FormatInt(id, 10))
+}
+
+func FindDealerFromID(ctx stdContext.Context, id int64) (*Dealer, error) {
+	value, err := cachedRead("dealer", strconv.FormatInt(id, 10), func() (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		dealer := Dealer{ID: id}
+		err := GetDBConnection().QueryRowContext(queryCtx, `
+			SELECT dealers.name, dealers.link, dealers.location, dealers.phone_num,
+					dealers.email, dealers.order_num, COALESCE(dealers.description, ''),
+				images.id, images.filename, images.size
+			FROM dealers
+			INNER JOIN images ON dealers.id = images.dealer_id
+			WHERE dealers.id = $1
+			`, dealer.ID).Scan(
+			&dealer.Name, &dealer.Link, &dealer.Location, &dealer.PhoneNumber,
+			&dealer.Email, &dealer.OrderNum, &dealer.Description,
+			&dealer.Image.ID, &dealer.Image.Filename, &dealer.Image.Size)
+		if err != nil {
+			return nil, err
+		}
+		return &dealer, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Dealer), nil
 }
 
 func findDealersHandler(ctx context.Context) {
-	dealers, err := FindDealers()
+	if serveExport(ctx, exportQuery{Name: "dealers", From: "dealers", Joins: []string{joinDealerImages},
+		Order: "dealers.order_num ASC", Columns: dealerExportColumns}) {
+		return
+	}
+	if serveShaped(ctx, dealerShape, shapeQuery{Joins: []string{joinDealerImages}, Order: "dealers.order_num ASC"}) {
+		return
+	}
+
+	dealers, err := FindDealers(ctx.Request().Context())
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -100,37 +149,44 @@ func findDealersHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(dealers)
+	ServeCacheable(ctx, localize(ctx, dealers))
 }
 
-func FindDealers() (*[]Dealer, error) {
-	rows, err := GetDBConnection().Query(`
-		SELECT dealers.id, dealers.name, dealers.link, dealers.location, dealers.phone_num,
-				dealers.email, dealers.order_num,
-			images.id, images.filename, images.size
-		FROM dealers
-		INNER JOIN images ON dealers.id = images.dealer_id
-		ORDER BY dealers.order_num ASC`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	dealers := []Dealer{}
-	for rows.Next() {
-		dealer := Dealer{}
-		err = rows.Scan(
-			&dealer.ID, &dealer.Name, &dealer.Link, &dealer.Location, &dealer.PhoneNumber,
-			&dealer.Email, &dealer.OrderNum,
-			&dealer.Image.ID, &dealer.Image.Filename, &dealer.Image.Size)
+func FindDealers(ctx stdContext.Context) (*[]Dealer, error) {
+	value, err := cachedRead("dealer", "list", func() (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		rows, err := GetDBConnection().QueryContext(queryCtx, `
+			SELECT dealers.id, dealers.name, dealers.link, dealers.location, dealers.phone_num,
+					dealers.email, dealers.order_num, COALESCE(dealers.description, ''),
+				images.id, images.filename, images.size
+			FROM dealers
+			INNER JOIN images ON dealers.id = images.dealer_id
+			ORDER BY dealers.order_num ASC`)
 		if err != nil {
 			return nil, err
 		}
-		dealers = append(dealers, dealer)
-	}
+		defer rows.Close()
+
+		dealers := []Dealer{}
+		for rows.Next() {
+			dealer := Dealer{}
+			err = rows.Scan(
+				&dealer.ID, &dealer.Name, &dealer.Link, &dealer.Location, &dealer.PhoneNumber,
+				&dealer.Email, &dealer.OrderNum, &dealer.Description,
+				&dealer.Image.ID, &dealer.Image.Filename, &dealer.Image.Size)
+			if err != nil {
+				return nil, err
+			}
+			dealers = append(dealers, dealer)
+		}
 
-	return &dealers, nil
+		return &dealers, rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*[]Dealer), nil
 }
 
 func insertDealerHandler(ctx context.Context) {
@@ -143,11 +199,23 @@ func insertDealerHandler(ctx context.Context) {
 
 	dealer.Image.Filename = url.QueryEscape(dealer.Image.Filename)
 
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
 	// Create Dealer
-	err := GetDBConnection().QueryRow(`
-		INSERT INTO dealers (name, link, location, phone_num, email, order_num)
-		VALUES($1,$2,$3,$4,$5,$6) returning id;`,
-		dealer.Name, dealer.Link, dealer.Location, dealer.PhoneNumber, dealer.Email, dealer.OrderNum).Scan(&dealer.ID)
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `
+		INSERT INTO dealers (name, link, location, phone_num, email, order_num, description)
+		VALUES($1,$2,$3,$4,$5,$6,$7) returning id;`,
+		dealer.Name, dealer.Link, dealer.Location, dealer.PhoneNumber, dealer.Email, dealer.OrderNum,
+		dealerDescription(dealer)).Scan(&dealer.ID)
 
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
@@ -157,7 +225,9 @@ func insertDealerHandler(ctx context.Context) {
 	}
 
 	// Create new image
-	err = GetDBConnection().QueryRow(`
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `
 		INSERT INTO images (filename, size, image_type_id, dealer_id)
 		VALUES($1,$2,$3,$4) 
 		returning id;`,
@@ -169,6 +239,16 @@ func insertDealerHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "dealer.created", dealer)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "dealer")
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(dealer)
 }
@@ -181,17 +261,20 @@ func removeOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	tx, err := GetDBConnection().Begin()
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
+	defer tx.Rollback()
 
 	// Get image filename
 	filename := ""
-	dbErr := tx.QueryRow(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	dbErr := tx.QueryRowContext(queryCtx, `
 		SELECT filename 
 		FROM images
 		WHERE dealer_id=$1`,
@@ -203,15 +286,10 @@ func removeOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	// Delete from S3
-	err = deleteS3Object(filename)
-	if err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(err)
-	}
-
 	// Delete Image from database
-	stmt, err := tx.Prepare(`delete from images where filename=$1`)
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := tx.PrepareContext(queryCtx, `delete from images where filename=$1`)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -219,7 +297,7 @@ func removeOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	res, err := stmt.Exec(filename)
+	res, err := stmt.ExecContext(queryCtx, filename)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -250,7 +328,9 @@ func removeOneDealerHandler(ctx context.Context) {
 	}
 
 	// Delete doorsample from database
-	stmt, err = tx.Prepare(`delete from dealers where id=$1`)
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err = tx.PrepareContext(queryCtx, `delete from dealers where id=$1`)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -258,7 +338,7 @@ func removeOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	res, err = stmt.Exec(id)
+	res, err = stmt.ExecContext(queryCtx, id)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -274,14 +354,6 @@ func removeOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
-	}
-
 	affect, err = res.RowsAffected()
 	if err != nil {
 		statusCode, result := HandleDBError(err)
@@ -296,6 +368,19 @@ func removeOneDealerHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "dealer.deleted", map[string]interface{}{"id": id})
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	// Delete from S3
+	deleteS3ObjectAfterCommit(ctx.Request().Context(), filename)()
+
+	InvalidateCache(ctx.Request().Context(), "dealer")
+
 	ctx.StatusCode(iris.StatusOK)
 	idString := strconv.FormatInt(id, 10)
 	ctx.JSON(map[string]interface{}{
@@ -312,16 +397,19 @@ func updateOneDealerHandler(ctx context.Context) {
 	}
 
 	// Get the old order number and image info to see if they've been changed
-	txn, dbErr := GetDBConnection().Begin()
+	txn, dbErr := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
 	if dbErr != nil {
 		statusCode, result := HandleDBError(dbErr)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(result)
 		return
 	}
+	defer txn.Rollback()
 
 	var oldOrderNum int64
-	dbErr = txn.QueryRow(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	dbErr = txn.QueryRowContext(queryCtx, `
 		SELECT order_num
 		FROM dealers
 		WHERE id = $1`,
@@ -333,7 +421,9 @@ func updateOneDealerHandler(ctx context.Context) {
 	}
 
 	oldImage := &Image{}
-	dbErr = txn.QueryRow(`
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	dbErr = txn.QueryRowContext(queryCtx, `
 		SELECT filename, size 
 		FROM images
 		WHERE dealer_id = $1`,
@@ -345,20 +435,15 @@ func updateOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	dbErr = txn.Commit()
-	if dbErr != nil {
-		statusCode, errObj := HandleDBError(dbErr)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-	}
-
 	// Did we update the orderNum? Swap those first because of the unique constraint
 	if dealer.OrderNum != oldOrderNum {
 
 		// Get the id of the old order number owner
 		// stupid I know
 		var otherDealerID int64
-		dbErr = GetDBConnection().QueryRow(`
+		queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+		defer cancel()
+		dbErr = txn.QueryRowContext(queryCtx, `
 			SELECT id
 			FROM dealers
 			WHERE order_num = $1`,
@@ -371,7 +456,9 @@ func updateOneDealerHandler(ctx context.Context) {
 		}
 
 		// Swap orderNums with however owned that order num
-		stmt, dbErr := GetDBConnection().Prepare(`
+		queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+		defer cancel()
+		stmt, dbErr := txn.PrepareContext(queryCtx, `
 			UPDATE dealers dst
 			SET order_num = src.order_num
 			FROM dealers src
@@ -386,7 +473,7 @@ func updateOneDealerHandler(ctx context.Context) {
 			return
 		}
 
-		res, dbErr := stmt.Exec(otherDealerID, dealer.ID)
+		res, dbErr := stmt.ExecContext(queryCtx, otherDealerID, dealer.ID)
 		if dbErr != nil {
 			statusCode, result := HandleDBError(dbErr)
 			ctx.StatusCode(statusCode)
@@ -417,10 +504,13 @@ func updateOneDealerHandler(ctx context.Context) {
 	}
 
 	// Did we update the image?
+	var oldFilename string
 	if dealer.Image.Filename != oldImage.Filename || dealer.Image.Size != oldImage.Size {
 
 		// Update the database
-		stmt, dbErr := GetDBConnection().Prepare(`
+		queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+		defer cancel()
+		stmt, dbErr := txn.PrepareContext(queryCtx, `
 			UPDATE images 
 			SET filename = $1, size = $2
 			WHERE id = $3
@@ -432,7 +522,7 @@ func updateOneDealerHandler(ctx context.Context) {
 			return
 		}
 
-		res, err := stmt.Exec(dealer.Image.Filename, dealer.Image.Size, dealer.Image.ID)
+		res, dbErr := stmt.ExecContext(queryCtx, dealer.Image.Filename, dealer.Image.Size, dealer.Image.ID)
 		if dbErr != nil {
 			statusCode, result := HandleDBError(dbErr)
 			ctx.StatusCode(statusCode)
@@ -461,21 +551,18 @@ func updateOneDealerHandler(ctx context.Context) {
 			return
 		}
 
-		// Update S3
-		err = deleteS3Object(oldImage.Filename)
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.JSON(err)
-		}
+		oldFilename = oldImage.Filename
 	}
 
 	// Update Dealer in DB
 	// minus the order_num
 	// already did that...
-	stmt, dbErr := GetDBConnection().Prepare(`
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, dbErr := txn.PrepareContext(queryCtx, `
 		UPDATE dealers 
-		SET name=$1, link=$2, location=$3, phone_num=$4, email=$5
-		WHERE id=$6
+		SET name=$1, link=$2, location=$3, phone_num=$4, email=$5, description=$6
+		WHERE id=$7
 	`)
 	if dbErr != nil {
 		statusCode, result := HandleDBError(dbErr)
@@ -484,8 +571,8 @@ func updateOneDealerHandler(ctx context.Context) {
 		return
 	}
 
-	res, dbErr := stmt.Exec(dealer.Name, dealer.Link, dealer.Location,
-		dealer.PhoneNumber, dealer.Email, dealer.ID)
+	res, dbErr := stmt.ExecContext(queryCtx, dealer.Name, dealer.Link, dealer.Location,
+		dealer.PhoneNumber, dealer.Email, dealerDescription(dealer), dealer.ID)
 	if dbErr != nil {
 		statusCode, result := HandleDBError(dbErr)
 		ctx.StatusCode(statusCode)
@@ -514,6 +601,186 @@ func updateOneDealerHandler(ctx context.Context) {
 		return
 	}
 
+	dbErr = commitWithCatalogEvent(ctx.Request().Context(), txn, "dealer.updated", dealer)
+	if dbErr != nil {
+		statusCode, errObj := HandleDBError(dbErr)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	// Update S3
+	if len(oldFilename) > 0 {
+		deleteS3ObjectAfterCommit(ctx.Request().Context(), oldFilename)()
+	}
+
+	InvalidateCache(ctx.Request().Context(), "dealer")
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(map[string]interface{}{})
 }
+
+var dealerPatchResource = PatchResource{
+	Entity: "dealer",
+	Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		dealer := &Dealer{ID: id}
+		err := tx.QueryRowContext(queryCtx, `
+			SELECT dealers.name, dealers.link, dealers.location, dealers.phone_num,
+					dealers.email, dealers.order_num, COALESCE(dealers.description, ''),
+				images.id, images.filename, images.size, images.image_type_id
+			FROM dealers
+			INNER JOIN images ON dealers.id = images.dealer_id
+			WHERE dealers.id = $1
+			FOR UPDATE OF dealers`,
+			id).Scan(
+			&dealer.Name, &dealer.Link, &dealer.Location, &dealer.PhoneNumber,
+			&dealer.Email, &dealer.OrderNum, &dealer.Description,
+			&dealer.Image.ID, &dealer.Image.Filename, &dealer.Image.Size, &dealer.Image.ImageType.ID)
+		return dealer, err
+	},
+	Update: updateDealer,
+}
+
+// updateDealer saves a whole dealer. A new order number is swapped with
+// the dealer that had it, so the order stays a sequence.
+func updateDealer(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+	dealer := &Dealer{}
+	if err := json.Unmarshal(data, dealer); err != nil {
+		return BulkChange{}, err
+	}
+	if len(strings.TrimSpace(dealer.Name)) == 0 {
+		return BulkChange{}, ValidationErrors{"name": "Name is required."}
+	}
+	change := BulkChange{ID: dealer.ID, Data: dealer}
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var oldOrderNum int64
+	oldImage := Image{}
+	err := tx.QueryRowContext(queryCtx, `
+		SELECT dealers.order_num, images.id, images.filename, images.size
+		FROM dealers
+		INNER JOIN images ON dealers.id = images.dealer_id
+		WHERE dealers.id = $1
+		FOR UPDATE OF dealers`,
+		dealer.ID).Scan(&oldOrderNum, &oldImage.ID, &oldImage.Filename, &oldImage.Size)
+	if err != nil {
+		return change, err
+	}
+
+	if dealer.OrderNum != oldOrderNum {
+		res, err := tx.ExecContext(queryCtx, `
+			UPDATE dealers
+			SET order_num = $1
+			WHERE order_num = $2 AND id <> $3`,
+			oldOrderNum, dealer.OrderNum, dealer.ID)
+		if err != nil {
+			return change, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return change, ValidationErrors{"orderNum": "Order Num must belong to another dealer."}
+		}
+	}
+
+	if dealer.Image.Filename != oldImage.Filename || dealer.Image.Size != oldImage.Size {
+		_, err = tx.ExecContext(queryCtx, `
+			UPDATE images
+			SET filename = $1, size = $2
+			WHERE id = $3`,
+			dealer.Image.Filename, dealer.Image.Size, oldImage.ID)
+		if err != nil {
+			return change, err
+		}
+		if dealer.Image.Filename != oldImage.Filename {
+			change.AfterCommit = deleteS3ObjectAfterCommit(ctx, oldImage.Filename)
+		}
+	}
+	dealer.Image.ID = oldImage.ID
+
+	_, err = tx.ExecContext(queryCtx, `
+		UPDATE dealers
+		SET name=$1, link=$2, location=$3, phone_num=$4, email=$5, order_num=$6, description=$7
+		WHERE id=$8`,
+		dealer.Name, dealer.Link, dealer.Location, dealer.PhoneNumber, dealer.Email,
+		dealer.OrderNum, dealerDescription(dealer), dealer.ID)
+	return change, err
+}
+
+// dealerDescription is the description column, NULL when empty
+func dealerDescription(dealer *Dealer) interface{} {
+	dealer.Description = strings.TrimSpace(dealer.Description)
+	if len(dealer.Description) == 0 {
+		return nil
+	}
+	return dealer.Description
+}
+
+var dealerBulkResource = BulkResource{
+	Entity: "dealer",
+	Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		dealer := &Dealer{}
+		if err := json.Unmarshal(data, dealer); err != nil {
+			return BulkChange{}, err
+		}
+		validationErrors := ValidationErrors{}
+		if len(strings.TrimSpace(dealer.Name)) == 0 {
+			validationErrors["name"] = "Name is required."
+		}
+		if len(dealer.Image.Filename) == 0 {
+			validationErrors["image"] = "Image is required."
+		} else if dealer.Image.ImageType.ID < 1 {
+			validationErrors["imageType"] = "Image Type is required."
+		}
+		if len(validationErrors) > 0 {
+			return BulkChange{}, validationErrors
+		}
+		dealer.Image.Filename = url.QueryEscape(dealer.Image.Filename)
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		err := tx.QueryRowContext(queryCtx, `
+			INSERT INTO dealers (name, link, location, phone_num, email, order_num, description)
+			VALUES($1,$2,$3,$4,$5,$6,$7) returning id;`,
+			dealer.Name, dealer.Link, dealer.Location, dealer.PhoneNumber, dealer.Email,
+			dealer.OrderNum, dealerDescription(dealer)).Scan(&dealer.ID)
+		if err != nil {
+			return BulkChange{}, err
+		}
+
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO images (filename, size, image_type_id, dealer_id)
+			VALUES($1,$2,$3,$4)
+			returning id;`,
+			dealer.Image.Filename, dealer.Image.Size, dealer.Image.ImageType.ID,
+			dealer.ID).Scan(&dealer.Image.ID)
+		return BulkChange{ID: dealer.ID, Data: dealer}, err
+	},
+	Update: updateDealer,
+	Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		// The image goes with the dealer through ON DELETE CASCADE
+		images, err := findOwnedImagesForUpdate(queryCtx, tx, dealerImageOwner, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		filenames := []string{}
+		for _, image := range images {
+			filenames = append(filenames, image.Filename)
+		}
+
+		res, err := tx.ExecContext(queryCtx, `DELETE FROM dealers WHERE id=$1`, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return BulkChange{}, err
+		}
+		return BulkChange{AfterCommit: deleteS3ObjectAfterCommit(ctx, filenames...)}, nil
+	},
+}
@@ -0,0 +1,113 @@
+package muskoka
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
+
+// DoorSampleColourMatch is a door sample with how far its colour is from the
+// one searched for
+type DoorSampleColourMatch struct {
+	DoorSample
+	DeltaE float64 `json:"deltaE"`
+}
+
+const (
+	defaultColourMatchLimit = 20
+	maxColourMatchLimit     = 100
+)
+
+// findDoorSampleColourMatchesHandler returns the door samples whose colour
+// is closest to ?hex=, nearest first. ?limit= caps how many come back and
+// ?maxDeltaE= drops anything further away. Colours without a hex value are
+// never matched.
+func findDoorSampleColourMatchesHandler(ctx context.Context) {
+	validationErrors := make(map[string]string)
+
+	_, rgb, err := parseHexColour(ctx.URLParam("hex"))
+	if err != nil {
+		validationErrors["hex"] = "Hex must be a colour such as #a1b2c3."
+	}
+
+	limit := defaultColourMatchLimit
+	if value := ctx.URLParam("limit"); len(value) > 0 {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxColourMatchLimit {
+			validationErrors["limit"] = "Limit must be between 1 and " + strconv.Itoa(maxColourMatchLimit) + "."
+		}
+	}
+
+	maxDeltaE := math.Inf(1)
+	if value := ctx.URLParam("maxDeltaE"); len(value) > 0 {
+		maxDeltaE, err = strconv.ParseFloat(value, 64)
+		if err != nil || maxDeltaE < 0 {
+			validationErrors["maxDeltaE"] = "Max Delta E must be a positive number."
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": validationErrors})
+		return
+	}
+
+	colours, err := FindColours(ctx.Request().Context())
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	target := rgb.LAB()
+	coloursByID := make(map[int64]Colour)
+	deltaEs := make(map[int64]float64)
+	search := DoorSampleSearch{}
+	for _, colour := range *colours {
+		if colour.LAB == nil {
+			continue
+		}
+		deltaE := target.DeltaE(*colour.LAB)
+		if deltaE > maxDeltaE {
+			continue
+		}
+		coloursByID[colour.ID] = colour
+		deltaEs[colour.ID] = math.Round(deltaE*100) / 100
+		search.ColourIDs = append(search.ColourIDs, int(colour.ID))
+	}
+
+	matches := make([]DoorSampleColourMatch, 0)
+	if len(search.ColourIDs) > 0 {
+		doorSamples, err := FindDoorSamples(ctx.Request().Context(), &search)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+
+		for _, doorSample := range *localize(ctx, doorSamples).(*[]DoorSample) {
+			name := doorSample.Colour.Name
+			doorSample.Colour = coloursByID[doorSample.Colour.ID]
+			doorSample.Colour.Name = name
+			matches = append(matches, DoorSampleColourMatch{
+				DoorSample: doorSample,
+				DeltaE:     deltaEs[doorSample.Colour.ID],
+			})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].DeltaE < matches[j].DeltaE
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	// The distances change with the colours as well as the door samples
+	ServeCacheable(ctx, matches, "colour")
+}
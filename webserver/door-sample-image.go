@@ -0,0 +1,474 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"net/url"
+	"strconv"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/kataras/iris/core/router"
+	"github.com/lib/pq"
+)
+
+// Door samples have their images in order, e.g. the front, the profile and
+// a close-up of the grain. DoorSample.Image is the primary one, or a
+// placeholder when there are none.
+
+func createDoorSampleImageAPI(party router.Party) {
+	party.Post("/:id/images", insertDoorSampleImageHandler)
+	party.Put("/:id/images/order", reorderDoorSampleImagesHandler)
+	party.Put("/:id/images/:imageId/primary", setPrimaryDoorSampleImageHandler)
+	party.Delete("/:id/images/:imageId", removeDoorSampleImageHandler)
+}
+
+// findDoorSampleImages returns the images of each door sample in order
+func findDoorSampleImages(ctx stdContext.Context, db dbQueryer, ids []int64) (map[int64][]Image, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(queryCtx, `
+		SELECT images.door_sample_id, images.id, images.filename, images.size,
+			images.position, images.is_primary,
+			image_types.id, image_types.name, image_types.is_specific_dimension,
+			image_types.width, image_types.height
+		FROM images
+		INNER JOIN image_types ON images.image_type_id = image_types.id
+		WHERE images.door_sample_id = ANY($1)
+		ORDER BY images.position ASC, images.id ASC`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := map[int64][]Image{}
+	for rows.Next() {
+		var doorSampleID int64
+		image := Image{}
+		err = rows.Scan(&doorSampleID, &image.ID, &image.Filename, &image.Size,
+			&image.Position, &image.Primary,
+			&image.ImageType.ID, &image.ImageType.Name, &image.ImageType.IsSpecificDimension,
+			&image.ImageType.Width, &image.ImageType.Height)
+		if err != nil {
+			return nil, err
+		}
+		images[doorSampleID] = append(images[doorSampleID], image)
+	}
+	return images, rows.Err()
+}
+
+// setDoorSampleImages sets the images and picks the primary from them
+func setDoorSampleImages(doorSample *DoorSample, images []Image) {
+	if images == nil {
+		images = []Image{}
+	}
+	doorSample.Images = images
+	doorSample.Image = placeholderImage()
+	for _, image := range images {
+		if image.Primary {
+			doorSample.Image = image
+			break
+		}
+	}
+}
+
+// prepareDoorSampleImages checks the images of a new door sample, numbers
+// them and makes the first the primary unless one asks to be. A lone image
+// is taken from Image for clients that only send one, unless it's the
+// placeholder sent back from a read.
+func prepareDoorSampleImages(doorSample *DoorSample) error {
+	if len(doorSample.Images) == 0 && len(doorSample.Image.Filename) > 0 && !doorSample.Image.Placeholder {
+		doorSample.Images = []Image{doorSample.Image}
+	}
+
+	validationErrors := ValidationErrors{}
+	primary := -1
+	for i := range doorSample.Images {
+		image := &doorSample.Images[i]
+		if len(image.Filename) == 0 {
+			validationErrors["images"] = "Every image needs a filename."
+		} else if image.ImageType.ID < 1 {
+			validationErrors["imageType"] = "Image Type is required."
+		}
+		if image.Primary {
+			if primary >= 0 {
+				validationErrors["images"] = "Only one image can be the primary."
+			}
+			primary = i
+		}
+		// Stored filenames are escaped, updates send them back as they are
+		image.Filename = url.QueryEscape(image.Filename)
+		image.Position = i + 1
+	}
+	if len(validationErrors) > 0 {
+		return validationErrors
+	}
+
+	if primary < 0 && len(doorSample.Images) > 0 {
+		doorSample.Images[0].Primary = true
+	}
+	return nil
+}
+
+// insertDoorSampleImages adds the prepared images of a new door sample
+func insertDoorSampleImages(ctx stdContext.Context, tx *sql.Tx, doorSample *DoorSample) error {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	for i := range doorSample.Images {
+		image := &doorSample.Images[i]
+		err := tx.QueryRowContext(queryCtx, `
+			INSERT INTO images (filename, size, image_type_id, door_sample_id, position, is_primary)
+			VALUES($1,$2,$3,$4,$5,$6)
+			returning id;`,
+			image.Filename, image.Size, image.ImageType.ID, doorSample.ID,
+			image.Position, image.Primary).Scan(&image.ID)
+		if err != nil {
+			return err
+		}
+	}
+	setDoorSampleImages(doorSample, doorSample.Images)
+	return nil
+}
+
+func readDoorSampleImageParams(ctx context.Context) (int64, int64, bool) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read door sample id"})
+		return 0, 0, false
+	}
+	imageID, err := ctx.Params().GetInt64("imageId")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read image id"})
+		return 0, 0, false
+	}
+	return id, imageID, true
+}
+
+// commitDoorSampleImages announces the door sample with its new images in
+// tx and commits it
+func commitDoorSampleImages(ctx stdContext.Context, tx *sql.Tx, id int64) error {
+	doorSample, err := loadDoorSample(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	return commitWithCatalogEvent(ctx, tx, "door_sample.updated", doorSample)
+}
+
+// insertDoorSampleImageHandler adds an image after the others. The first
+// image, or one sent as primary, becomes the primary.
+func insertDoorSampleImageHandler(ctx context.Context) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read door sample id"})
+		return
+	}
+
+	image := &Image{}
+	if err := ctx.ReadJSON(image); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read image"})
+		return
+	}
+	validationErrors := ValidationErrors{}
+	if len(image.Filename) == 0 {
+		validationErrors["filename"] = "Filename is required."
+	}
+	if image.ImageType.ID < 1 {
+		validationErrors["imageType"] = "Image Type is required."
+	}
+	if len(validationErrors) > 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": validationErrors})
+		return
+	}
+	image.Filename = url.QueryEscape(image.Filename)
+
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	// Locking the door sample keeps concurrent adds from sharing a position
+	var hasPrimary bool
+	err = tx.QueryRowContext(queryCtx, `
+		SELECT COALESCE(MAX(images.position), 0) + 1, COALESCE(BOOL_OR(images.is_primary), FALSE)
+		FROM (SELECT id FROM door_samples WHERE id = $1 FOR UPDATE) door_sample
+		LEFT JOIN images ON images.door_sample_id = door_sample.id
+		GROUP BY door_sample.id`,
+		id).Scan(&image.Position, &hasPrimary)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	image.Primary = image.Primary || !hasPrimary
+	if image.Primary && hasPrimary {
+		_, err = tx.ExecContext(queryCtx, `
+			UPDATE images SET is_primary = FALSE
+			WHERE door_sample_id = $1 AND is_primary`, id)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+	}
+
+	err = tx.QueryRowContext(queryCtx, `
+		INSERT INTO images (filename, size, image_type_id, door_sample_id, position, is_primary)
+		VALUES($1,$2,$3,$4,$5,$6)
+		returning id;`,
+		image.Filename, image.Size, image.ImageType.ID, id, image.Position, image.Primary).Scan(&image.ID)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	if err = commitDoorSampleImages(ctx.Request().Context(), tx, id); err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-sample")
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(image)
+}
+
+type doorSampleImageOrder struct {
+	ImageIDs []int64 `json:"imageIds"`
+}
+
+// reorderDoorSampleImagesHandler takes every image id of the door sample in
+// the new order
+func reorderDoorSampleImagesHandler(ctx context.Context) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read door sample id"})
+		return
+	}
+
+	order := &doorSampleImageOrder{}
+	if err := ctx.ReadJSON(order); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read image order"})
+		return
+	}
+
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	// Locking the door sample keeps images from being added meanwhile
+	err = tx.QueryRowContext(queryCtx, `SELECT id FROM door_samples WHERE id = $1 FOR UPDATE`, id).Scan(&id)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	// The ids must be the door sample's images, each once
+	var matches bool
+	err = tx.QueryRowContext(queryCtx, `
+		SELECT COALESCE(array_agg(images.id ORDER BY images.id), '{}') = (
+			SELECT COALESCE(array_agg(ordered.id ORDER BY ordered.id), '{}')
+			FROM unnest($2::bigint[]) ordered(id)
+		)
+		FROM images
+		WHERE images.door_sample_id = $1`,
+		id, pq.Array(order.ImageIDs)).Scan(&matches)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	if !matches {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": ValidationErrors{
+			"imageIds": "Image ids must be every image of the door sample once.",
+		}})
+		return
+	}
+
+	_, err = tx.ExecContext(queryCtx, `
+		UPDATE images
+		SET position = ordered.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY ordered(id, position)
+		WHERE images.id = ordered.id AND images.door_sample_id = $1`,
+		id, pq.Array(order.ImageIDs))
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	if err = commitDoorSampleImages(ctx.Request().Context(), tx, id); err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-sample")
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{})
+}
+
+func setPrimaryDoorSampleImageHandler(ctx context.Context) {
+	id, imageID, ok := readDoorSampleImageParams(ctx)
+	if !ok {
+		return
+	}
+
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	// The old primary goes first, the index allows only one at a time
+	_, err = tx.ExecContext(queryCtx, `
+		UPDATE images SET is_primary = FALSE
+		WHERE door_sample_id = $1 AND is_primary AND id <> $2`,
+		id, imageID)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	res, err := tx.ExecContext(queryCtx, `
+		UPDATE images SET is_primary = TRUE
+		WHERE id = $1 AND door_sample_id = $2`,
+		imageID, id)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	affect, err := res.RowsAffected()
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	if affect < 1 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]interface{}{"error": "No image found for door sample"})
+		return
+	}
+
+	if err = commitDoorSampleImages(ctx.Request().Context(), tx, id); err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-sample")
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{})
+}
+
+// removeDoorSampleImageHandler deletes an image and its upload. The next
+// image becomes the primary if it was.
+func removeDoorSampleImageHandler(ctx context.Context) {
+	id, imageID, ok := readDoorSampleImageParams(ctx)
+	if !ok {
+		return
+	}
+
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	var filename string
+	var wasPrimary bool
+	err = tx.QueryRowContext(queryCtx, `
+		DELETE FROM images
+		WHERE id = $1 AND door_sample_id = $2
+		RETURNING filename, is_primary`,
+		imageID, id).Scan(&filename, &wasPrimary)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	if wasPrimary {
+		_, err = tx.ExecContext(queryCtx, `
+			UPDATE images SET is_primary = TRUE
+			WHERE id = (
+				SELECT id FROM images
+				WHERE door_sample_id = $1
+				ORDER BY position ASC, id ASC
+				LIMIT 1
+			)`, id)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+	}
+
+	if err = commitDoorSampleImages(ctx.Request().Context(), tx, id); err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	deleteS3ObjectAfterCommit(ctx.Request().Context(), filename)()
+
+	InvalidateCache(ctx.Request().Context(), "door-sample")
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{
+		"id": strconv.FormatInt(imageID, 10),
+	})
+}
@@ -1,6 +1,7 @@
 package muskoka
 
 import (
+	stdContext "context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -18,7 +19,9 @@ type DoorSample struct {
 	DoorStyle DoorStyle `json:"doorStyle"`
 	Wood      Wood      `json:"wood"`
 	Colour    Colour    `json:"colour"`
-	Image     Image     `json:"image"`
+	// Image is the primary of Images, or a placeholder without any
+	Image  Image   `json:"image"`
+	Images []Image `json:"images"`
 }
 
 func InitDoorSample() {
@@ -39,12 +42,16 @@ func createDoorSampleTable() {
 }
 
 func CreateDoorSampleAPI(party router.Party) {
-
-	party.Get("/findOne/:id", findOneDoorSampleHandler)
-	party.Get("", findDoorSamplesHandler)
-	party.Post("", insertDoorSampleHandler)
+	cachePolicy := CatalogCachePolicy("door-sample")
+	party.Get("/findOne/:id", CacheMiddleware(cachePolicy), findOneDoorSampleHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findDoorSamplesHandler)
+	party.Get("/colour-match", CacheMiddleware(cachePolicy), findDoorSampleColourMatchesHandler)
+	party.Post("", IdempotencyMiddleware, insertDoorSampleHandler)
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(doorSampleBulkResource))
 	party.Put("", updateOneDoorSampleHandler)
+	party.Patch("/:id", AdminMiddleware, PatchHandler(doorSamplePatchResource))
 	party.Delete("/:id", removeOneDoorSampleHandler)
+	createDoorSampleImageAPI(party)
 }
 
 func findOneDoorSampleHandler(ctx context.Context) {
@@ -55,29 +62,61 @@ func findOneDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
+	if serveShaped(ctx, doorSampleShape, shapeQuery{
+		Joins: []string{joinDoorSampleImages},
+		Where: "door_samples.id = $1", Args: []interface{}{id}, One: true,
+	}, "door-sample/"+strconv.FormatInt(id, 10)) {
+		return
+	}
+
+	doorSample, err := FindDoorSampleFromID(ctx.Request().Context(), id)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	ServeCacheable(ctx, localize(ctx, doorSample), "door-sample/"+strconv.FormatInt(id, 10))
+}
+
+func FindDoorSampleFromID(ctx stdContext.Context, id int64) (*DoorSample, error) {
+	value, err := cachedRead("door-sample", strconv.FormatInt(id, 10), func() (interface{}, error) {
+		return loadDoorSample(ctx, GetDBConnection(), id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*DoorSample), nil
+}
+
+// loadDoorSample reads a door sample through db, so a write can announce
+// it from its transaction
+func loadDoorSample(ctx stdContext.Context, db dbQueryer, id int64) (*DoorSample, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	doorSample := DoorSample{ID: id}
-	err = GetDBConnection().QueryRow(`
-		SELECT door_styles.id, door_styles.name, wood.id, wood.name, colours.id, colours.name, 
-			images.id, images.filename, images.size
+	err := db.QueryRowContext(queryCtx, `
+		SELECT door_styles.id, door_styles.name, wood.id, wood.name, colours.id, colours.name
 		FROM door_samples
 		INNER JOIN door_styles ON door_samples.door_style_id = door_styles.id
 		INNER JOIN wood ON door_samples.wood_id = wood.id
 		INNER JOIN colours ON door_samples.colour_id = colours.id
-		INNER JOIN images ON door_samples.id = images.door_sample_id
 		WHERE door_samples.id = $1
 		`, doorSample.ID).Scan(
 		&doorSample.DoorStyle.ID, &doorSample.DoorStyle.Name, &doorSample.Wood.ID, &doorSample.Wood.Name,
-		&doorSample.Colour.ID, &doorSample.Colour.Name, &doorSample.Image.ID, &doorSample.Image.Filename,
-		&doorSample.Image.Size)
+		&doorSample.Colour.ID, &doorSample.Colour.Name)
 	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+		return nil, err
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(doorSample)
+	images, err := findDoorSampleImages(ctx, db, []int64{id})
+	if err != nil {
+		return nil, err
+	}
+	setDoorSampleImages(&doorSample, images[id])
+	return &doorSample, nil
 }
 
 type DoorSampleSearch struct {
@@ -85,6 +124,9 @@ type DoorSampleSearch struct {
 	WoodIDs      []int  `json:"woodIds"`
 	DoorStyleIDs []int  `json:"doorStyleIds"`
 	SearchText   string `json:"searchText"`
+	// Wood keeps only the door samples whose wood matches, whatever else
+	// is searched for
+	Wood WoodFilter `json:"wood"`
 }
 
 func findDoorSamplesHandler(ctx context.Context) {
@@ -99,8 +141,25 @@ func findDoorSamplesHandler(ctx context.Context) {
 	json.Unmarshal([]byte(woodIDsString), &doorSampleSearch.WoodIDs)
 	json.Unmarshal([]byte(doorStyleIDsString), &doorSampleSearch.DoorStyleIDs)
 	doorSampleSearch.SearchText = searchText
+	doorSampleSearch.Wood = readWoodFilter(ctx)
+
+	where, args := doorSampleFilter(&doorSampleSearch)
+	query := shapeQuery{Joins: []string{joinDoorSampleImages}, Where: where, Args: args,
+		Order: "images.filename ASC"}
+	if len(doorSampleSearch.SearchText) > 0 {
+		query.Joins = append(query.Joins,
+			joinDoorSampleDoorStyles, joinDoorSampleWood, joinDoorSampleColours)
+	}
+	if serveExport(ctx, exportQuery{Name: "door-samples", From: "door_samples",
+		Joins: []string{joinDoorSampleImages, joinDoorSampleDoorStyles, joinDoorSampleWood, joinDoorSampleColours},
+		Where: where, Args: args, Order: "images.filename ASC", Columns: doorSampleExportColumns}) {
+		return
+	}
+	if serveShaped(ctx, doorSampleShape, query) {
+		return
+	}
 
-	doorSamples, err := FindDoorSamples(&doorSampleSearch)
+	doorSamples, err := FindDoorSamples(ctx.Request().Context(), &doorSampleSearch)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -108,16 +167,30 @@ func findDoorSamplesHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(doorSamples)
+	ServeCacheable(ctx, localize(ctx, doorSamples))
 }
 
-func FindDoorSamples(search *DoorSampleSearch) (*[]DoorSample, error) {
+// FindDoorSamples caches one result per distinct search
+func FindDoorSamples(ctx stdContext.Context, search *DoorSampleSearch) (*[]DoorSample, error) {
+	key, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
 
-	numOfArgs := len(search.ColourIDs) + len(search.WoodIDs) + len(search.DoorStyleIDs)
+	value, err := cachedRead("door-sample", string(key), func() (interface{}, error) {
+		return loadDoorSamples(ctx, search)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*[]DoorSample), nil
+}
+
+// doorSampleFilter returns the where clause for search, without WHERE, and
+// its arguments. The search text needs door_styles, wood and colours joined.
+func doorSampleFilter(search *DoorSampleSearch) (string, []interface{}) {
 	if len(search.SearchText) > 0 {
 		search.SearchText = strings.ToLower(search.SearchText)
-		numOfArgs++
 	}
 
 	argumentCounter := 1
@@ -145,41 +218,56 @@ func FindDoorSamples(search *DoorSampleSearch) (*[]DoorSample, error) {
 	}
 
 	if len(search.SearchText) > 0 {
-		searchQuery := fmt.Sprintf("LOWER(colours.name) LIKE '%%' || $%[1]d || '%%'", argumentCounter)
-		whereQueries = append(whereQueries, searchQuery)
-		searchQuery = fmt.Sprintf("LOWER(wood.name) LIKE '%%' || $%[1]d || '%%'", argumentCounter)
-		whereQueries = append(whereQueries, searchQuery)
-		searchQuery = fmt.Sprintf("LOWER(door_styles.name) LIKE '%%' || $%[1]d || '%%'", argumentCounter)
-		whereQueries = append(whereQueries, searchQuery)
+		// Names match in any locale, not just the one asked for
+		pattern := fmt.Sprintf("'%%' || $%d || '%%'", argumentCounter)
+		for _, entity := range []string{"colour", "wood", "door-style"} {
+			whereQueries = append(whereQueries, translatedSearchSQL(entity, "name", pattern))
+		}
 
 		whereArguments = append(whereArguments, search.SearchText)
 		argumentCounter++
 	}
 
-	whereQuery := ""
-	if len(whereQueries) > 0 {
-		whereQuery = "WHERE " + strings.Join(whereQueries, " OR ") + " "
+	where := strings.Join(whereQueries, " OR ")
+	if !search.Wood.IsEmpty() {
+		woodWhere, args := search.Wood.SQL(whereArguments)
+		woodQuery := "door_samples.wood_id IN (SELECT wood.id FROM wood WHERE " + woodWhere + ")"
+		if len(where) > 0 {
+			where = "(" + where + ") AND " + woodQuery
+		} else {
+			where = woodQuery
+		}
+		whereArguments = args
+	}
+	return where, whereArguments
+}
+
+func loadDoorSamples(ctx stdContext.Context, search *DoorSampleSearch) (*[]DoorSample, error) {
+	whereQuery, whereArguments := doorSampleFilter(search)
+	if len(whereQuery) > 0 {
+		whereQuery = "WHERE " + whereQuery + " "
 	}
 
 	doorSampleQuery := fmt.Sprintf(`
 		SELECT door_samples.id,
 			door_styles.id, door_styles.name, 
 			wood.id, wood.name, 
-			colours.id, colours.name, 
-			images.id, images.filename, images.size
+			colours.id, colours.name
 		FROM door_samples
 		INNER JOIN door_styles ON door_samples.door_style_id = door_styles.id
 		INNER JOIN wood ON door_samples.wood_id = wood.id
 		INNER JOIN colours ON door_samples.colour_id = colours.id
-		INNER JOIN images ON door_samples.id = images.door_sample_id
+		%[2]s
 		%[1]s
-		ORDER BY images.filename ASC`, whereQuery)
+		ORDER BY images.filename ASC`, whereQuery, joinDoorSampleImages)
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	var rows *sql.Rows
 	var err error
 	if len(whereArguments) > 0 {
-		rows, err = GetDBConnection().Query(doorSampleQuery, whereArguments...)
+		rows, err = GetDBConnection().QueryContext(queryCtx, doorSampleQuery, whereArguments...)
 	} else {
-		rows, err = GetDBConnection().Query(doorSampleQuery)
+		rows, err = GetDBConnection().QueryContext(queryCtx, doorSampleQuery)
 	}
 	if err != nil {
 		return nil, err
@@ -192,13 +280,27 @@ func FindDoorSamples(search *DoorSampleSearch) (*[]DoorSample, error) {
 		err = rows.Scan(&doorSample.ID,
 			&doorSample.DoorStyle.ID, &doorSample.DoorStyle.Name,
 			&doorSample.Wood.ID, &doorSample.Wood.Name,
-			&doorSample.Colour.ID, &doorSample.Colour.Name,
-			&doorSample.Image.ID, &doorSample.Image.Filename, &doorSample.Image.Size)
+			&doorSample.Colour.ID, &doorSample.Colour.Name)
 		if err != nil {
 			return nil, err
 		}
 		doorSamples = append(doorSamples, doorSample)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(doorSamples))
+	for i, doorSample := range doorSamples {
+		ids[i] = doorSample.ID
+	}
+	images, err := findDoorSampleImages(ctx, GetDBConnection(), ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range doorSamples {
+		setDoorSampleImages(&doorSamples[i], images[doorSamples[i].ID])
+	}
 
 	return &doorSamples, nil
 }
@@ -211,17 +313,45 @@ func insertDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
-	doorSample.Image.Filename = url.QueryEscape(doorSample.Image.Filename)
+	if err := prepareDoorSampleImages(doorSample); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": err})
+		return
+	}
+
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	// Only combinations the door style is made in
+	err = checkDoorSampleCompatibility(ctx.Request().Context(), tx.QueryRowContext, doorSample)
+	if validationErrors, ok := err.(ValidationErrors); ok {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": validationErrors})
+		return
+	}
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
 
 	// Create Door Sample
-	var err error
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
 	if doorSample.Colour.ID < 1 {
-		err = GetDBConnection().QueryRow(`
+		err = tx.QueryRowContext(queryCtx, `
 			INSERT INTO door_samples (door_style_id, wood_id, colour_id)
 			VALUES($1,$2,$3) returning id;`,
 			doorSample.DoorStyle.ID, doorSample.Wood.ID, nil).Scan(&doorSample.ID)
 	} else {
-		err = GetDBConnection().QueryRow(`
+		err = tx.QueryRowContext(queryCtx, `
 			INSERT INTO door_samples (door_style_id, wood_id, colour_id)
 			VALUES($1,$2,$3) returning id;`,
 			doorSample.DoorStyle.ID, doorSample.Wood.ID, doorSample.Colour.ID).Scan(&doorSample.ID)
@@ -234,12 +364,8 @@ func insertDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
-	// Create new image first
-	err = GetDBConnection().QueryRow(`
-		INSERT INTO images (filename, size, image_type_id, door_sample_id)
-		VALUES($1,$2,$3,$4) 
-		returning id;`,
-		doorSample.Image.Filename, doorSample.Image.Size, doorSample.Image.ImageType.ID, doorSample.ID).Scan(&doorSample.Image.ID)
+	// Then its images, a sample can start without any
+	err = insertDoorSampleImages(ctx.Request().Context(), tx, doorSample)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -247,6 +373,16 @@ func insertDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "door_sample.created", doorSample)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-sample")
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(doorSample)
 }
@@ -259,37 +395,48 @@ func removeOneDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
-	tx, err := GetDBConnection().Begin()
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
+	defer tx.Rollback()
 
-	// Get image filename
-	filename := ""
-	dbErr := tx.QueryRow(`
+	// Get image filenames, the images go with the door sample through
+	// ON DELETE CASCADE
+	filenames := []string{}
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	rows, err := tx.QueryContext(queryCtx, `
 		SELECT filename 
 		FROM images
 		WHERE door_sample_id=$1`,
-		id).Scan(&filename)
-	if dbErr != nil {
+		id)
+	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
-
-	// Delete from S3
-	err = deleteS3Object(filename)
-	if err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(err)
+	for rows.Next() {
+		var filename string
+		if err = rows.Scan(&filename); err != nil {
+			rows.Close()
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+		filenames = append(filenames, filename)
 	}
+	rows.Close()
 
-	// Delete Image from database
-	stmt, err := tx.Prepare(`delete from images where filename=$1`)
+	// Delete doorsample from database
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := tx.PrepareContext(queryCtx, `delete from door_samples where id=$1`)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -297,7 +444,7 @@ func removeOneDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
-	res, err := stmt.Exec(filename)
+	res, err := stmt.ExecContext(queryCtx, id)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -307,9 +454,9 @@ func removeOneDoorSampleHandler(ctx context.Context) {
 
 	err = stmt.Close()
 	if err != nil {
-		statusCode, errObj := HandleDBError(err)
+		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
+		ctx.JSON(result)
 		return
 	}
 
@@ -323,36 +470,11 @@ func removeOneDoorSampleHandler(ctx context.Context) {
 
 	if affect < 1 {
 		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No images found for doorsample found"})
-		return
-	}
-
-	// Delete doorsample from database
-	stmt, err = tx.Prepare(`delete from door_samples where id=$1`)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
-
-	res, err = stmt.Exec(id)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
-
-	err = stmt.Close()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
+		ctx.JSON(map[string]interface{}{"error": "No door samples found"})
 		return
 	}
 
-	err = tx.Commit()
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "door_sample.deleted", map[string]interface{}{"id": id})
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -360,19 +482,10 @@ func removeOneDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
-	affect, err = res.RowsAffected()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
+	// Delete from S3 once the rows are gone
+	deleteS3ObjectAfterCommit(ctx.Request().Context(), filenames...)()
 
-	if affect < 1 {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No door samples found"})
-		return
-	}
+	InvalidateCache(ctx.Request().Context(), "door-sample")
 
 	ctx.StatusCode(iris.StatusOK)
 	idString := strconv.FormatInt(id, 10)
@@ -389,25 +502,71 @@ func updateOneDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
-	// Are we updating the image?
-	oldImage := &Image{}
-	err := GetDBConnection().QueryRow(`
-		SELECT filename, size 
-		FROM images
-		WHERE door_sample_id = $1`,
-		doorSample.ID).Scan(&oldImage.Filename, &oldImage.Size)
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
+	defer tx.Rollback()
 
+	// Only combinations the door style is made in
+	err = checkDoorSampleCompatibility(ctx.Request().Context(), tx.QueryRowContext, doorSample)
+	if validationErrors, ok := err.(ValidationErrors); ok {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": validationErrors})
+		return
+	}
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
 
-	if doorSample.Image.Filename != oldImage.Filename || doorSample.Image.Size != oldImage.Size {
+	// Are we updating the primary image?
+	var oldFilename string
+	oldImage := &Image{}
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `
+		SELECT id, filename, size
+		FROM images
+		WHERE door_sample_id = $1 AND is_primary`,
+		doorSample.ID).Scan(&oldImage.ID, &oldImage.Filename, &oldImage.Size)
+	if err != nil && err != sql.ErrNoRows {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	if err == sql.ErrNoRows {
+		// A sample without images gets the one sent as its primary
+		if len(doorSample.Image.Filename) > 0 && !doorSample.Image.Placeholder {
+			queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+			defer cancel()
+			err = tx.QueryRowContext(queryCtx, `
+				INSERT INTO images (filename, size, image_type_id, door_sample_id, position, is_primary)
+				VALUES($1,$2,$3,$4,1,TRUE)
+				returning id;`,
+				url.QueryEscape(doorSample.Image.Filename), doorSample.Image.Size,
+				doorSample.Image.ImageType.ID, doorSample.ID).Scan(&doorSample.Image.ID)
+			if err != nil {
+				statusCode, result := HandleDBError(err)
+				ctx.StatusCode(statusCode)
+				ctx.JSON(result)
+				return
+			}
+		}
+	} else if !doorSample.Image.Placeholder &&
+		(doorSample.Image.Filename != oldImage.Filename || doorSample.Image.Size != oldImage.Size) {
 
 		// Update the database
-		stmt, err := GetDBConnection().Prepare(`
+		queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+		defer cancel()
+		stmt, err := tx.PrepareContext(queryCtx, `
 			UPDATE images 
 			SET filename = $1, size = $2
 			WHERE id = $3
@@ -419,7 +578,7 @@ func updateOneDoorSampleHandler(ctx context.Context) {
 			return
 		}
 
-		res, err := stmt.Exec(doorSample.Image.Filename, doorSample.Image.Size, doorSample.Image.ID)
+		res, err := stmt.ExecContext(queryCtx, doorSample.Image.Filename, doorSample.Image.Size, oldImage.ID)
 		if err != nil {
 			statusCode, result := HandleDBError(err)
 			ctx.StatusCode(statusCode)
@@ -448,16 +607,13 @@ func updateOneDoorSampleHandler(ctx context.Context) {
 			return
 		}
 
-		// Update S3
-		err = deleteS3Object(oldImage.Filename)
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.JSON(err)
-		}
+		oldFilename = oldImage.Filename
 	}
 
 	// Update Door Sample
-	stmt, dbErr := GetDBConnection().Prepare(`
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, dbErr := tx.PrepareContext(queryCtx, `
 		UPDATE door_samples 
 		SET door_style_id=$1, wood_id=$2, colour_id=$3
 		WHERE id=$4
@@ -469,7 +625,7 @@ func updateOneDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
-	res, dbErr := stmt.Exec(doorSample.DoorStyle.ID, doorSample.Wood.ID,
+	res, dbErr := stmt.ExecContext(queryCtx, doorSample.DoorStyle.ID, doorSample.Wood.ID,
 		doorSample.Colour.ID, doorSample.ID)
 	if dbErr != nil {
 		statusCode, result := HandleDBError(dbErr)
@@ -499,6 +655,203 @@ func updateOneDoorSampleHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "door_sample.updated", doorSample)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	// Update S3 once the new image is saved
+	if len(oldFilename) > 0 {
+		deleteS3ObjectAfterCommit(ctx.Request().Context(), oldFilename)()
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-sample")
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(map[string]interface{}{})
 }
+
+var doorSampleBulkResource = BulkResource{
+	Entity: "door-sample",
+	Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		doorSample, err := readBulkDoorSample(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		if err = prepareDoorSampleImages(doorSample); err != nil {
+			return BulkChange{}, err
+		}
+		if err = checkDoorSampleCompatibility(ctx, tx.QueryRowContext, doorSample); err != nil {
+			return BulkChange{}, err
+		}
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO door_samples (door_style_id, wood_id, colour_id)
+			VALUES($1,$2,$3) returning id;`,
+			doorSample.DoorStyle.ID, doorSample.Wood.ID, doorSample.Colour.ID).Scan(&doorSample.ID)
+		if err != nil {
+			return BulkChange{}, err
+		}
+
+		err = insertDoorSampleImages(ctx, tx, doorSample)
+		return BulkChange{ID: doorSample.ID, Data: doorSample}, err
+	},
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		doorSample, err := readBulkDoorSample(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		change := BulkChange{ID: doorSample.ID, Data: doorSample}
+		if err = checkDoorSampleCompatibility(ctx, tx.QueryRowContext, doorSample); err != nil {
+			return change, err
+		}
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		res, err := tx.ExecContext(queryCtx, `
+			UPDATE door_samples
+			SET door_style_id=$1, wood_id=$2, colour_id=$3
+			WHERE id=$4`,
+			doorSample.DoorStyle.ID, doorSample.Wood.ID, doorSample.Colour.ID, doorSample.ID)
+		if err != nil {
+			return change, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return change, err
+		}
+
+		// Only the primary image is updated, the others have their own
+		// endpoints
+		oldImage := Image{}
+		err = tx.QueryRowContext(queryCtx, `
+			SELECT id, filename, size
+			FROM images
+			WHERE door_sample_id = $1 AND is_primary`,
+			doorSample.ID).Scan(&oldImage.ID, &oldImage.Filename, &oldImage.Size)
+		if err == sql.ErrNoRows {
+			if len(doorSample.Image.Filename) == 0 || doorSample.Image.Placeholder {
+				return change, nil
+			}
+			err = tx.QueryRowContext(queryCtx, `
+				INSERT INTO images (filename, size, image_type_id, door_sample_id, position, is_primary)
+				VALUES($1,$2,$3,$4,1,TRUE)
+				returning id;`,
+				url.QueryEscape(doorSample.Image.Filename), doorSample.Image.Size,
+				doorSample.Image.ImageType.ID, doorSample.ID).Scan(&doorSample.Image.ID)
+			return change, err
+		}
+		if err != nil {
+			return change, err
+		}
+		doorSample.Image.ID = oldImage.ID
+
+		if len(doorSample.Image.Filename) == 0 || doorSample.Image.Placeholder {
+			return change, nil
+		}
+		if doorSample.Image.Filename != oldImage.Filename || doorSample.Image.Size != oldImage.Size {
+			_, err = tx.ExecContext(queryCtx, `
+				UPDATE images
+				SET filename = $1, size = $2, image_type_id = $3
+				WHERE id = $4`,
+				doorSample.Image.Filename, doorSample.Image.Size, doorSample.Image.ImageType.ID, oldImage.ID)
+			if err != nil {
+				return change, err
+			}
+
+			if doorSample.Image.Filename != oldImage.Filename {
+				change.AfterCommit = deleteS3ObjectAfterCommit(ctx, oldImage.Filename)
+			}
+		}
+
+		return change, nil
+	},
+	Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		// Images go with the door sample through ON DELETE CASCADE
+		filenames := []string{}
+		rows, err := tx.QueryContext(queryCtx, `
+			SELECT filename
+			FROM images
+			WHERE door_sample_id=$1`, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		for rows.Next() {
+			var filename string
+			if err = rows.Scan(&filename); err != nil {
+				rows.Close()
+				return BulkChange{}, err
+			}
+			filenames = append(filenames, filename)
+		}
+		rows.Close()
+
+		res, err := tx.ExecContext(queryCtx, `DELETE FROM door_samples WHERE id=$1`, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return BulkChange{}, err
+		}
+
+		return BulkChange{AfterCommit: deleteS3ObjectAfterCommit(ctx, filenames...)}, nil
+	},
+}
+
+func readBulkDoorSample(data json.RawMessage) (*DoorSample, error) {
+	doorSample := &DoorSample{}
+	if err := json.Unmarshal(data, doorSample); err != nil {
+		return nil, err
+	}
+
+	validationErrors := ValidationErrors{}
+	if doorSample.DoorStyle.ID < 1 {
+		validationErrors["doorStyle"] = "Door Style is required."
+	}
+	if doorSample.Wood.ID < 1 {
+		validationErrors["wood"] = "Wood is required."
+	}
+	if doorSample.Colour.ID < 1 {
+		validationErrors["colour"] = "Colour is required."
+	}
+	// Door samples can be without images
+	if len(doorSample.Image.Filename) > 0 && !doorSample.Image.Placeholder &&
+		doorSample.Image.ImageType.ID < 1 {
+		validationErrors["imageType"] = "Image Type is required."
+	}
+	if len(validationErrors) > 0 {
+		return nil, validationErrors
+	}
+
+	return doorSample, nil
+}
+
+var doorSamplePatchResource = PatchResource{
+	Entity: "door-sample",
+	Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		doorSample := &DoorSample{ID: id}
+		err := tx.QueryRowContext(queryCtx, `
+			SELECT door_samples.door_style_id, door_samples.wood_id, door_samples.colour_id,
+				COALESCE(images.id, 0), COALESCE(images.filename, ''), COALESCE(images.size, 0),
+				COALESCE(images.image_type_id, 0)
+			FROM door_samples
+			`+joinDoorSampleImages+`
+			WHERE door_samples.id = $1
+			FOR UPDATE OF door_samples`,
+			id).Scan(&doorSample.DoorStyle.ID, &doorSample.Wood.ID, &doorSample.Colour.ID,
+			&doorSample.Image.ID, &doorSample.Image.Filename, &doorSample.Image.Size,
+			&doorSample.Image.ImageType.ID)
+		return doorSample, err
+	},
+	Update: doorSampleBulkResource.Update,
+}
@@ -0,0 +1,251 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"strconv"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/lib/pq"
+)
+
+// DoorStyleWoodOption is a wood a door style is made in and the colours it
+// comes in with that wood. A door style's options are its compatibility
+// matrix; one without any is made in every wood and colour.
+type DoorStyleWoodOption struct {
+	Wood    Wood     `json:"wood"`
+	Colours []Colour `json:"colours"`
+}
+
+func InitDoorStyleCompatibility() {
+	createDoorStyleCompatibilityTable()
+}
+
+func createDoorStyleCompatibilityTable() {
+	_, err := GetDBConnection().Exec(`
+		CREATE TABLE IF NOT EXISTS door_style_compatibility (
+			door_style_id integer references door_styles ON DELETE CASCADE NOT NULL,
+			wood_id integer references wood ON DELETE CASCADE NOT NULL,
+			colour_id integer references colours ON DELETE CASCADE NOT NULL,
+			PRIMARY KEY (door_style_id, wood_id, colour_id)
+		);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// findDoorStyleCompatibility returns the options of each door style, woods
+// and colours sorted by name
+func findDoorStyleCompatibility(ctx stdContext.Context, ids []int64) (map[int64][]DoorStyleWoodOption, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT door_style_compatibility.door_style_id, wood.id, wood.name, colours.id, colours.name
+		FROM door_style_compatibility
+		INNER JOIN wood ON door_style_compatibility.wood_id = wood.id
+		INNER JOIN colours ON door_style_compatibility.colour_id = colours.id
+		WHERE door_style_compatibility.door_style_id = ANY($1)
+		ORDER BY door_style_compatibility.door_style_id, lower(wood.name), wood.id, lower(colours.name)`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := map[int64][]DoorStyleWoodOption{}
+	for rows.Next() {
+		var doorStyleID int64
+		wood := Wood{}
+		colour := Colour{}
+		if err = rows.Scan(&doorStyleID, &wood.ID, &wood.Name, &colour.ID, &colour.Name); err != nil {
+			return nil, err
+		}
+
+		styleOptions := options[doorStyleID]
+		if len(styleOptions) == 0 || styleOptions[len(styleOptions)-1].Wood.ID != wood.ID {
+			styleOptions = append(styleOptions, DoorStyleWoodOption{Wood: wood})
+		}
+		last := &styleOptions[len(styleOptions)-1]
+		last.Colours = append(last.Colours, colour)
+		options[doorStyleID] = styleOptions
+	}
+	return options, rows.Err()
+}
+
+// checkDoorStyleCompatibilityOptions adds what's wrong with options sent to
+// be saved to validationErrors
+func checkDoorStyleCompatibilityOptions(options []DoorStyleWoodOption, validationErrors ValidationErrors) {
+	woodIDs := map[int64]bool{}
+	for _, option := range options {
+		if option.Wood.ID < 1 {
+			validationErrors["compatibility"] = "Every option needs a wood."
+			continue
+		}
+		if woodIDs[option.Wood.ID] {
+			validationErrors["compatibility"] = "Wood " + strconv.FormatInt(option.Wood.ID, 10) + " is listed twice."
+		}
+		woodIDs[option.Wood.ID] = true
+		if len(option.Colours) == 0 {
+			validationErrors["compatibility"] = "Every wood needs at least one colour."
+		}
+		for _, colour := range option.Colours {
+			if colour.ID < 1 {
+				validationErrors["compatibility"] = "Every colour needs an id."
+			}
+		}
+	}
+}
+
+// saveDoorStyleCompatibility replaces the door style's options
+func saveDoorStyleCompatibility(ctx stdContext.Context, tx *sql.Tx, doorStyle *DoorStyle) error {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := tx.ExecContext(queryCtx, `DELETE FROM door_style_compatibility WHERE door_style_id = $1`, doorStyle.ID)
+	if err != nil {
+		return err
+	}
+	for _, option := range doorStyle.Compatibility {
+		for _, colour := range option.Colours {
+			_, err = tx.ExecContext(queryCtx, `
+				INSERT INTO door_style_compatibility (door_style_id, wood_id, colour_id)
+				VALUES($1,$2,$3)
+				ON CONFLICT DO NOTHING;`,
+				doorStyle.ID, option.Wood.ID, colour.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDoorSampleCompatibility returns ValidationErrors when the door
+// sample's door style isn't made in its wood and colour. queryRow is the
+// QueryRowContext of the transaction the sample is saved in. The door style
+// is locked until it ends, since its options are replaced under the door
+// style's own update lock. A sample without a colour only needs the wood to
+// be available. An unknown door style is left to the foreign key.
+func checkDoorSampleCompatibility(ctx stdContext.Context,
+	queryRow func(stdContext.Context, string, ...interface{}) *sql.Row, doorSample *DoorSample) error {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var restricted, woodAvailable, colourAvailable bool
+	err := queryRow(queryCtx, `
+		SELECT
+			EXISTS (SELECT 1 FROM door_style_compatibility WHERE door_style_id = $1),
+			EXISTS (SELECT 1 FROM door_style_compatibility WHERE door_style_id = $1 AND wood_id = $2),
+			EXISTS (SELECT 1 FROM door_style_compatibility
+				WHERE door_style_id = $1 AND wood_id = $2 AND ($3 < 1 OR colour_id = $3))
+		FROM door_styles
+		WHERE door_styles.id = $1
+		FOR SHARE OF door_styles`,
+		doorSample.DoorStyle.ID, doorSample.Wood.ID, doorSample.Colour.ID).Scan(
+		&restricted, &woodAvailable, &colourAvailable)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !restricted || colourAvailable:
+		return nil
+	case !woodAvailable:
+		return ValidationErrors{"wood": "The door style isn't made in this wood."}
+	default:
+		return ValidationErrors{"colour": "The door style isn't made in this colour with this wood."}
+	}
+}
+
+// DoorStyleOptions are the woods and colours a door style can be ordered
+// in. Restricted is false when the style has no compatibility matrix and
+// every combination is listed.
+type DoorStyleOptions struct {
+	DoorStyle  DoorStyle             `json:"doorStyle"`
+	Restricted bool                  `json:"restricted"`
+	Options    []DoorStyleWoodOption `json:"options"`
+}
+
+// findDoorStyleOptionsHandler returns the valid wood and colour options of
+// a door style, with each wood and colour in full
+func findDoorStyleOptionsHandler(ctx context.Context) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read door style id"})
+		return
+	}
+
+	options, err := FindDoorStyleOptions(ctx.Request().Context(), id)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	// Copy, the woods and colours come from the cache
+	t := newTranslator(ctx.Request().Context(), RequestLocale(ctx))
+	translated := *options
+	translated.DoorStyle = t.doorStyle(options.DoorStyle)
+	translated.Options = make([]DoorStyleWoodOption, len(options.Options))
+	for i, option := range options.Options {
+		translated.Options[i].Wood = t.wood(option.Wood)
+		translated.Options[i].Colours = make([]Colour, len(option.Colours))
+		for j, colour := range option.Colours {
+			translated.Options[i].Colours[j] = t.colour(colour)
+		}
+	}
+
+	ServeCacheable(ctx, translated, "door-style/"+strconv.FormatInt(id, 10))
+}
+
+// FindDoorStyleOptions expands the door style's compatibility matrix to the
+// full woods and colours, or every wood with every colour without one
+func FindDoorStyleOptions(ctx stdContext.Context, id int64) (*DoorStyleOptions, error) {
+	doorStyle, err := FindDoorStyleFromID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	woods, err := FindWoods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	colours, err := FindColours(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DoorStyleOptions{
+		DoorStyle:  DoorStyle{ID: doorStyle.ID, Name: doorStyle.Name, DoorStyleTypes: doorStyle.DoorStyleTypes},
+		Restricted: len(doorStyle.Compatibility) > 0,
+		Options:    []DoorStyleWoodOption{},
+	}
+	if !result.Restricted {
+		for _, wood := range *woods {
+			result.Options = append(result.Options, DoorStyleWoodOption{Wood: wood, Colours: *colours})
+		}
+		return result, nil
+	}
+
+	woodsByID := map[int64]Wood{}
+	for _, wood := range *woods {
+		woodsByID[wood.ID] = wood
+	}
+	coloursByID := map[int64]Colour{}
+	for _, colour := range *colours {
+		coloursByID[colour.ID] = colour
+	}
+	for _, option := range doorStyle.Compatibility {
+		full := DoorStyleWoodOption{Wood: woodsByID[option.Wood.ID], Colours: []Colour{}}
+		for _, colour := range option.Colours {
+			full.Colours = append(full.Colours, coloursByID[colour.ID])
+		}
+		result.Options = append(result.Options, full)
+	}
+	return result, nil
+}
@@ -1,10 +1,12 @@
 package muskoka
 
 import (
+	stdContext "context"
+	"strconv"
+
 	"github.com/kataras/iris"
 	"github.com/kataras/iris/context"
 	"github.com/kataras/iris/core/router"
-	"strconv"
 )
 
 type DoorStyleType struct {
@@ -34,11 +36,13 @@ func createUniqueNameIndex() {
 }
 
 func CreateDoorStyleTypeAPI(party router.Party) {
-
-	party.Get("/findOne/:id", findOneDoorStyleTypeHandler)
-	party.Get("", findDoorStyleTypesHandler)
-	party.Post("", insertDoorStyleTypeHandler)
+	cachePolicy := CatalogCachePolicy("door-style-type")
+	party.Get("/findOne/:id", CacheMiddleware(cachePolicy), findOneDoorStyleTypeHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findDoorStyleTypesHandler)
+	party.Post("", IdempotencyMiddleware, insertDoorStyleTypeHandler)
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(namedEntityBulkResource("door-style-type", "door_style_types")))
 	party.Put("", updateOneDoorStyleTypeHandler)
+	party.Patch("/:id", AdminMiddleware, PatchHandler(namedEntityPatchResource("door-style-type", "door_style_types")))
 	party.Delete("/:id", removeOneDoorStyleTypeHandler)
 }
 
@@ -51,8 +55,15 @@ func findOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
+	if serveShaped(ctx, doorStyleTypeShape, shapeQuery{Where: "door_style_types.id = $1", Args: []interface{}{id}, One: true},
+		"door-style-type/"+strconv.FormatInt(id, 10)) {
+		return
+	}
+
 	doorStyleType := DoorStyleType{ID: id}
-	err = GetDBConnection().QueryRow(`SELECT name FROM door_style_types
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = GetDBConnection().QueryRowContext(queryCtx, `SELECT name FROM door_style_types
 			WHERE id = $1`, doorStyleType.ID).Scan(&doorStyleType.Name)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
@@ -61,38 +72,54 @@ func findOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(doorStyleType)
+	ServeCacheable(ctx, localize(ctx, &doorStyleType), "door-style-type/"+strconv.FormatInt(id, 10))
 }
 
 func findDoorStyleTypesHandler(ctx context.Context) {
-	rows, err := GetDBConnection().Query(`
-		SELECT id, name 
-		FROM door_style_types
-		ORDER BY name`)
+	if serveShaped(ctx, doorStyleTypeShape, shapeQuery{Order: localizedSQL("door-style-type", "name")}) {
+		return
+	}
+
+	doorStyleTypes, err := FindDoorStyleTypes(ctx.Request().Context())
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
-	defer rows.Close()
 
-	doorStyleTypes := []DoorStyleType{}
-	for rows.Next() {
-		doorStyleType := DoorStyleType{}
-		err = rows.Scan(&doorStyleType.ID, &doorStyleType.Name)
+	ServeCacheable(ctx, localize(ctx, doorStyleTypes))
+}
+
+func FindDoorStyleTypes(ctx stdContext.Context) (*[]DoorStyleType, error) {
+	value, err := cachedRead("door-style-type", "list", func() (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		rows, err := GetDBConnection().QueryContext(queryCtx, `
+			SELECT id, name
+			FROM door_style_types
+			ORDER BY name`)
 		if err != nil {
-			statusCode, errObj := HandleDBError(err)
-			ctx.StatusCode(statusCode)
-			ctx.JSON(errObj)
-			return
+			return nil, err
 		}
-		doorStyleTypes = append(doorStyleTypes, doorStyleType)
-	}
+		defer rows.Close()
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(doorStyleTypes)
+		doorStyleTypes := []DoorStyleType{}
+		for rows.Next() {
+			doorStyleType := DoorStyleType{}
+			err = rows.Scan(&doorStyleType.ID, &doorStyleType.Name)
+			if err != nil {
+				return nil, err
+			}
+			doorStyleTypes = append(doorStyleTypes, doorStyleType)
+		}
+
+		return &doorStyleTypes, rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*[]DoorStyleType), nil
 }
 
 func insertDoorStyleTypeHandler(ctx context.Context) {
@@ -103,7 +130,18 @@ func insertDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
-	err := GetDBConnection().QueryRow(`INSERT INTO door_style_types (name)
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `INSERT INTO door_style_types (name)
 		VALUES($1) returning id;`, doorStyleType.Name).Scan(&doorStyleType.ID)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
@@ -112,6 +150,16 @@ func insertDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "door_style_type.created", doorStyleType)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-style-type")
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(doorStyleType)
 }
@@ -124,7 +172,18 @@ func removeOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
-	stmt, err := GetDBConnection().Prepare("delete from door_style_types where id=$1")
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := tx.PrepareContext(queryCtx, "delete from door_style_types where id=$1")
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -132,7 +191,7 @@ func removeOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
-	res, err := stmt.Exec(id)
+	res, err := stmt.ExecContext(queryCtx, id)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -162,6 +221,16 @@ func removeOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "door_style_type.deleted", map[string]interface{}{"id": id})
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-style-type")
+
 	ctx.StatusCode(iris.StatusOK)
 	idString := strconv.FormatInt(id, 10)
 	ctx.JSON(map[string]interface{}{
@@ -177,7 +246,18 @@ func updateOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
-	stmt, err := GetDBConnection().Prepare(`
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := tx.PrepareContext(queryCtx, `
 		UPDATE door_style_types 
 		SET name=$1  
 		WHERE id=$2
@@ -189,7 +269,7 @@ func updateOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
-	res, err := stmt.Exec(doorStyleType.Name, doorStyleType.ID)
+	res, err := stmt.ExecContext(queryCtx, doorStyleType.Name, doorStyleType.ID)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -219,6 +299,16 @@ func updateOneDoorStyleTypeHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "door_style_type.updated", doorStyleType)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "door-style-type")
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(map[string]interface{}{})
 }
@@ -1,9 +1,11 @@
 package muskoka
 
 import (
-	"strconv"
-	"sync"
+	stdContext "context"
+	"database/sql"
 	"encoding/json"
+	"strconv"
+	"strings"
 
 	"github.com/kataras/iris"
 	"github.com/kataras/iris/context"
@@ -14,10 +16,16 @@ type DoorStyle struct {
 	ID             int64           `json:"id"`
 	DoorStyleTypes []DoorStyleType `json:"doorStyleTypes"`
 	Name           string          `json:"name"`
+	// Description, ProfileDrawings and Compatibility are left as they are by
+	// an update without them
+	Description     string                `json:"description,omitempty"`
+	ProfileDrawings []Image               `json:"profileDrawings,omitempty"`
+	Compatibility   []DoorStyleWoodOption `json:"compatibility,omitempty"`
 }
 
 func InitDoorStyle() {
 	createDoorStyleTable()
+	migrateDoorStyleTable()
 	createDoorStyleIndices()
 	createDoorStyleViews()
 }
@@ -26,13 +34,22 @@ func createDoorStyleTable() {
 	_, err := GetDBConnection().Exec(`
 		CREATE TABLE IF NOT EXISTS door_styles (
 			id BIGSERIAL PRIMARY KEY,
-			name text NOT NULL
+			name text NOT NULL,
+			description text
 		);`)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// migrateDoorStyleTable adds the description to older databases
+func migrateDoorStyleTable() {
+	_, err := GetDBConnection().Exec(`ALTER TABLE door_styles ADD COLUMN IF NOT EXISTS description text;`)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func createDoorStyleIndices() {
 	_, err := GetDBConnection().Exec(`CREATE UNIQUE INDEX IF NOT EXISTS door_styles__name__key ON door_styles (lower(name));`)
 	if err != nil {
@@ -49,7 +66,7 @@ func createDoorStylesView() {
 		CREATE OR REPLACE VIEW all_door_styles AS
 		SELECT row_to_json(t)
 		FROM (
-			SELECT door_styles.id, door_styles.name,
+			SELECT door_styles.id, door_styles.name, door_styles.description,
 			(
 				SELECT array_to_json(array_agg(row_to_json(d)))
 				FROM (
@@ -69,97 +86,67 @@ func createDoorStylesView() {
 	}
 }
 
-
-
 func CreateDoorStyleAPI(party router.Party) {
-
-	party.Get("/findOne/:id", findOneDoorStyleHandler)
-	party.Get("", findDoorStylesHandler)
-	party.Post("", insertDoorStyleHandler)
-	party.Put("", updateOneDoorStyleHandler)
-	party.Delete("/:id", removeOneDoorStyleHandler)
+	cachePolicy := CatalogCachePolicy("door-style")
+	party.Get("/findOne/:id", CacheMiddleware(cachePolicy), findOneDoorStyleHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findDoorStylesHandler)
+	party.Get("/options/:id", CacheMiddleware(cachePolicy), findDoorStyleOptionsHandler)
+	party.Post("", IdempotencyMiddleware, SingleHandler(doorStyleBulkResource, "create"))
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(doorStyleBulkResource))
+	party.Put("", SingleHandler(doorStyleBulkResource, "update"))
+	party.Patch("/:id", AdminMiddleware, PatchHandler(doorStylePatchResource))
+	party.Delete("/:id", SingleHandler(doorStyleBulkResource, "delete"))
 }
 
 func findOneDoorStyleHandler(ctx context.Context) {
-	id, err := ctx.URLParamInt64("id")
+	id, err := ctx.Params().GetInt64("id")
 	if err != nil {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.JSON(map[string]interface{}{"error": "Unable to read door style id"})
 		return
 	}
 
-	doorStyleChannel := make(chan *DoorStyle)
-	doorStyleTypesChannel := make(chan *[]DoorStyleType)
-	errChannel := make(chan error, 1)
-
-	go func() {
-		doorStyle, err := FindDoorStyleFromID(id)
-		if err != nil {
-			errChannel <- err
-		}
-		doorStyleChannel <- doorStyle
-	}()
-	go func() {
-		doorStyleTypes, err := FindDoorStyleTypesFromDoorStyleID(id)
-		if err != nil {
-			errChannel <- err
-		}
-		doorStyleTypesChannel <- doorStyleTypes
-	}()
-
-	func(doorStyle *DoorStyle, doorStyleTypes *[]DoorStyleType) {
-
-		if err := <-errChannel; err != nil {
-			statusCode, errObj := HandleDBError(err)
-			ctx.StatusCode(statusCode)
-			ctx.JSON(errObj)
-			return
-		}
-
-		doorStyle.DoorStyleTypes = *doorStyleTypes
-		ctx.StatusCode(iris.StatusOK)
-		ctx.JSON(doorStyle)
+	if serveShaped(ctx, doorStyleShape, shapeQuery{Where: "door_styles.id = $1", Args: []interface{}{id}, One: true},
+		"door-style/"+strconv.FormatInt(id, 10)) {
+		return
+	}
 
-	}(<-doorStyleChannel, <-doorStyleTypesChannel)
-}
+	doorStyle, err := FindDoorStyleFromID(ctx.Request().Context(), id)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
 
-func FindDoorStyleFromID(id int64) (*DoorStyle, error) {
-	doorStyle := DoorStyle{ID: id}
-	err := GetDBConnection().QueryRow(`
-		SELECT name
-		FROM door_styles
-		WHERE door_style_id = $1
-		`, doorStyle.ID).Scan(&doorStyle.Name)
-	return &doorStyle, err
+	ServeCacheable(ctx, localize(ctx, doorStyle), "door-style/"+strconv.FormatInt(id, 10))
 }
 
-func FindDoorStyleTypesFromDoorStyleID(id int64) (*[]DoorStyleType, error) {
-	rows, err := GetDBConnection().Query(`
-		SELECT door_style_types.id, door_style_types.name
-		FROM door_style_door_style_types
-		WHERE door_style_door_style_types.door_style_id = $1
-		INNER JOIN door_style_types ON door_style_door_style_types.door_style_type_id = door_style_types.id`,
-		id)
+// FindDoorStyleFromID picks the door style out of the cached list, which
+// has its types, drawings and compatibility already
+func FindDoorStyleFromID(ctx stdContext.Context, id int64) (*DoorStyle, error) {
+	doorStyles, err := FindDoorStyles(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	doorStyleTypes := []DoorStyleType{}
-	for rows.Next() {
-		doorStyleType := DoorStyleType{}
-		err = rows.Scan(&doorStyleType.ID, &doorStyleType.Name)
-		if err != nil {
-			return nil, err
+	for i := range *doorStyles {
+		if (*doorStyles)[i].ID == id {
+			return &(*doorStyles)[i], nil
 		}
-		doorStyleTypes = append(doorStyleTypes, doorStyleType)
 	}
-
-	return &doorStyleTypes, nil
+	return nil, sql.ErrNoRows
 }
 
 func findDoorStylesHandler(ctx context.Context) {
-	doorStyles, err := FindDoorStyles()
+	if serveExport(ctx, exportQuery{Name: "door-styles", From: "door_styles", Order: localizedSQL("door-style", "name"),
+		Columns: doorStyleExportColumns}) {
+		return
+	}
+	if serveShaped(ctx, doorStyleShape, shapeQuery{Order: localizedSQL("door-style", "name")}) {
+		return
+	}
+
+	doorStyles, err := FindDoorStyles(ctx.Request().Context())
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -167,13 +154,23 @@ func findDoorStylesHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(doorStyles)
+	ServeCacheable(ctx, localize(ctx, doorStyles))
 }
 
-func FindDoorStyles() (*[]DoorStyle, error) {
+func FindDoorStyles(ctx stdContext.Context) (*[]DoorStyle, error) {
+	value, err := cachedRead("door-style", "list", func() (interface{}, error) {
+		return loadDoorStyles(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*[]DoorStyle), nil
+}
 
-	rows, err := GetDBConnection().Query(`
+func loadDoorStyles(ctx stdContext.Context) (*[]DoorStyle, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
 		SELECT * FROM all_door_styles
 	`)
 	if err != nil {
@@ -202,7 +199,7 @@ func FindDoorStyles() (*[]DoorStyle, error) {
 		if err != nil {
 			return nil, err
 		}
-		
+
 	}
 
 	err = rows.Close()
@@ -210,265 +207,274 @@ func FindDoorStyles() (*[]DoorStyle, error) {
 		return nil, err
 	}
 
-	return &doorStyles, err
-}
-
-func insertDoorStyleHandler(ctx context.Context) {
-	doorStyle := &DoorStyle{}
-	if err := ctx.ReadJSON(doorStyle); err != nil {
-		panic(err)
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read door style"})
-		return
+	ids := []int64{}
+	for _, doorStyle := range doorStyles {
+		ids = append(ids, doorStyle.ID)
 	}
-
-	// Insert Door Style
-	err := GetDBConnection().QueryRow(`
-		INSERT INTO door_styles (name)
-		VALUES($1) returning id;`,
-		doorStyle.Name).Scan(&doorStyle.ID)
+	drawings, err := findOwnedImages(ctx, doorStyleImageOwner, ids)
 	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+		return nil, err
+	}
+	compatibility, err := findDoorStyleCompatibility(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range doorStyles {
+		doorStyles[i].ProfileDrawings = drawings[doorStyles[i].ID]
+		doorStyles[i].Compatibility = compatibility[doorStyles[i].ID]
 	}
 
-	// Insert DoorStyleDoorStyleTypes
-	var wg sync.WaitGroup
-	waitGroupLength := len(doorStyle.DoorStyleTypes)
-	errChannel := make(chan error, 1)
+	return &doorStyles, nil
+}
 
-	wg.Add(waitGroupLength)
-	finished := make(chan bool, 1)
+var doorStyleBulkResource = BulkResource{
+	Entity: "door-style",
+	Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		doorStyle, err := readBulkDoorStyle(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		for i := range doorStyle.ProfileDrawings {
+			doorStyle.ProfileDrawings[i].ID = 0
+		}
 
-	for i := 0; i < waitGroupLength; i++ {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO door_styles (name, description)
+			VALUES($1,$2) returning id;`,
+			doorStyle.Name, doorStyleDescription(doorStyle)).Scan(&doorStyle.ID)
+		if err != nil {
+			return BulkChange{}, err
+		}
 
-		go func(i int) {
-			var doorStyleDoorStyleTypeID int64
-			err := GetDBConnection().QueryRow(`
-				INSERT INTO door_style_door_style_types (door_style_id, door_style_type_id)
-				VALUES($1,$2) returning id;`,
-				doorStyle.ID, doorStyle.DoorStyleTypes[i].ID).Scan(&doorStyleDoorStyleTypeID)
+		change := BulkChange{ID: doorStyle.ID, Data: doorStyle}
+		if err = insertDoorStyleDoorStyleTypes(queryCtx, tx, doorStyle); err != nil {
+			return change, err
+		}
+		if _, err = saveOwnedImages(ctx, tx, doorStyleImageOwner, doorStyle.ID, "profileDrawings",
+			doorStyle.ProfileDrawings); err != nil {
+			return change, err
+		}
+		return change, saveDoorStyleCompatibility(ctx, tx, doorStyle)
+	},
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		doorStyle, err := readBulkDoorStyle(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		var sent struct {
+			Description *string `json:"description"`
+		}
+		if err = json.Unmarshal(data, &sent); err != nil {
+			return BulkChange{ID: doorStyle.ID}, err
+		}
+		if sent.Description == nil {
+			queryCtx, cancel := withQueryTimeout(ctx)
+			defer cancel()
+			err = tx.QueryRowContext(queryCtx, `
+				SELECT COALESCE(description, '')
+				FROM door_styles
+				WHERE id = $1
+				FOR UPDATE`,
+				doorStyle.ID).Scan(&doorStyle.Description)
 			if err != nil {
-				errChannel <- err
+				return BulkChange{ID: doorStyle.ID}, err
 			}
+		}
+		return saveDoorStyle(ctx, tx, doorStyle)
+	},
+	Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		// Door style types, drawings and compatibility go with it through
+		// ON DELETE CASCADE
+		drawings, err := findOwnedImagesForUpdate(queryCtx, tx, doorStyleImageOwner, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		filenames := []string{}
+		for _, drawing := range drawings {
+			filenames = append(filenames, drawing.Filename)
+		}
 
-			wg.Done()
-		}(i)
-	}
-
-	go func() {
-		wg.Wait()
-		close(finished)
-	}()
-
-	select {
-	case <-finished:
-		ctx.StatusCode(iris.StatusOK)
-		ctx.JSON(doorStyle)
-		break
-	case err := <-errChannel:
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		break
-	}
+		res, err := tx.ExecContext(queryCtx, "delete from door_styles where id=$1", id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return BulkChange{}, err
+		}
+		return BulkChange{AfterCommit: deleteS3ObjectAfterCommit(ctx, filenames...)}, nil
+	},
 }
 
-func updateOneDoorStyleHandler(ctx context.Context) {
+func readBulkDoorStyle(data json.RawMessage) (*DoorStyle, error) {
 	doorStyle := &DoorStyle{}
-	if err := ctx.ReadJSON(doorStyle); err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read door style"})
-		return
-	}
-
-	// Update Door Style
-	stmt, err := GetDBConnection().Prepare(`
-		UPDATE door_styles 
-		SET name=$1  
-		WHERE id=$2
-	`)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
-
-	res, err := stmt.Exec(doorStyle.Name, doorStyle.ID)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
-
-	err = stmt.Close()
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+	if err := json.Unmarshal(data, doorStyle); err != nil {
+		return nil, err
 	}
 
-	affect, err := res.RowsAffected()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	validationErrors := ValidationErrors{}
+	if len(strings.TrimSpace(doorStyle.Name)) == 0 {
+		validationErrors["name"] = "Name is required."
 	}
-
-	if affect < 1 {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No wood found"})
-		return
+	doorStyle.Description = strings.TrimSpace(doorStyle.Description)
+	checkOwnedImages(doorStyle.ProfileDrawings, "profileDrawings", validationErrors)
+	checkDoorStyleCompatibilityOptions(doorStyle.Compatibility, validationErrors)
+	if len(validationErrors) > 0 {
+		return nil, validationErrors
 	}
+	return doorStyle, nil
+}
 
-	// Delete DoorStyleDoorStyleTypes
-	stmt, err = GetDBConnection().Prepare("delete from door_style_door_style_types where door_style_id=$1")
+// saveDoorStyle updates the door style's columns and types, and its
+// compatibility and profile drawings if it has them
+func saveDoorStyle(ctx stdContext.Context, tx *sql.Tx, doorStyle *DoorStyle) (BulkChange, error) {
+	change := BulkChange{ID: doorStyle.ID, Data: doorStyle}
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	res, err := tx.ExecContext(queryCtx, `
+		UPDATE door_styles
+		SET name=$1, description=$2
+		WHERE id=$3`,
+		doorStyle.Name, doorStyleDescription(doorStyle), doorStyle.ID)
 	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+		return change, err
 	}
-
-	res, err = stmt.Exec(doorStyle.ID)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	if err = requireRowsAffected(res); err != nil {
+		return change, err
 	}
 
-	err = stmt.Close()
+	_, err = tx.ExecContext(queryCtx, "delete from door_style_door_style_types where door_style_id=$1", doorStyle.ID)
 	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+		return change, err
 	}
-
-	// Insert DoorStyleDoorStyleTypes
-	var wg sync.WaitGroup
-	waitGroupLength := len(doorStyle.DoorStyleTypes)
-	errChannel := make(chan error, 1)
-
-	wg.Add(waitGroupLength)
-	finished := make(chan bool, 1)
-
-	for i := 0; i < waitGroupLength; i++ {
-
-		go func(i int) {
-			var doorStyleDoorStyleTypeID int64
-			err := GetDBConnection().QueryRow(`
-				INSERT INTO door_style_door_style_types (door_style_id, door_style_type_id)
-				VALUES($1,$2) returning id;`,
-				doorStyle.ID, doorStyle.DoorStyleTypes[i].ID).Scan(&doorStyleDoorStyleTypeID)
-			if err != nil {
-				errChannel <- err
-			}
-
-			wg.Done()
-		}(i)
+	if err = insertDoorStyleDoorStyleTypes(queryCtx, tx, doorStyle); err != nil {
+		return change, err
 	}
 
-	go func() {
-		wg.Wait()
-		close(finished)
-	}()
-
-	select {
-	case <-finished:
-		ctx.StatusCode(iris.StatusOK)
-		ctx.JSON(map[string]interface{}{})
-		break
-	case err := <-errChannel:
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		break
+	if doorStyle.Compatibility != nil {
+		if err = saveDoorStyleCompatibility(ctx, tx, doorStyle); err != nil {
+			return change, err
+		}
 	}
-}
-
-func removeOneDoorStyleHandler(ctx context.Context) {
-	id, err := ctx.Params().GetInt64("id")
-	if err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read door style id"})
-		return
+	if doorStyle.ProfileDrawings == nil {
+		return change, nil
 	}
-
-	// Delete Door Style
-	stmt, err := GetDBConnection().Prepare("delete from door_styles where id=$1")
+	removed, err := saveOwnedImages(ctx, tx, doorStyleImageOwner, doorStyle.ID, "profileDrawings",
+		doorStyle.ProfileDrawings)
 	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+		return change, err
 	}
-
-	res, err := stmt.Exec(id)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	if len(removed) > 0 {
+		change.AfterCommit = deleteS3ObjectAfterCommit(ctx, removed...)
 	}
+	return change, nil
+}
 
-	err = stmt.Close()
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+// doorStyleDescription is the description column, NULL when empty
+func doorStyleDescription(doorStyle *DoorStyle) interface{} {
+	if len(doorStyle.Description) == 0 {
+		return nil
 	}
+	return doorStyle.Description
+}
 
-	affect, err := res.RowsAffected()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+// insertDoorStyleDoorStyleTypes runs the inserts one after the other, a
+// transaction can't be shared between goroutines
+func insertDoorStyleDoorStyleTypes(ctx stdContext.Context, tx *sql.Tx, doorStyle *DoorStyle) error {
+	for _, doorStyleType := range doorStyle.DoorStyleTypes {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO door_style_door_style_types (door_style_id, door_style_type_id)
+			VALUES($1,$2);`,
+			doorStyle.ID, doorStyleType.ID)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if affect < 1 {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No door style found"})
-		return
-	}
+var doorStylePatchResource = PatchResource{
+	Entity: "door-style",
+	Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
 
-	// Delete DoorStyleDoorStyleTypes
-	stmt, err = GetDBConnection().Prepare("delete from door_style_door_style_types where door_style_id=$1")
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
+		doorStyle := &DoorStyle{ID: id, DoorStyleTypes: []DoorStyleType{}}
+		err := tx.QueryRowContext(queryCtx, `
+			SELECT name, COALESCE(description, '')
+			FROM door_styles
+			WHERE id = $1
+			FOR UPDATE`,
+			id).Scan(&doorStyle.Name, &doorStyle.Description)
+		if err != nil {
+			return nil, err
+		}
 
-	res, err = stmt.Exec(id)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
+		doorStyle.ProfileDrawings, err = findOwnedImagesForUpdate(queryCtx, tx, doorStyleImageOwner, id)
+		if err != nil {
+			return nil, err
+		}
+		// Ids are enough, the patched door style is saved back as ids
+		doorStyle.Compatibility = []DoorStyleWoodOption{}
+		compatibilityRows, err := tx.QueryContext(queryCtx, `
+			SELECT wood_id, colour_id
+			FROM door_style_compatibility
+			WHERE door_style_id = $1
+			ORDER BY wood_id, colour_id`,
+			id)
+		if err != nil {
+			return nil, err
+		}
+		for compatibilityRows.Next() {
+			wood := Wood{}
+			colour := Colour{}
+			if err = compatibilityRows.Scan(&wood.ID, &colour.ID); err != nil {
+				compatibilityRows.Close()
+				return nil, err
+			}
+			last := len(doorStyle.Compatibility) - 1
+			if last < 0 || doorStyle.Compatibility[last].Wood.ID != wood.ID {
+				doorStyle.Compatibility = append(doorStyle.Compatibility, DoorStyleWoodOption{Wood: wood})
+				last++
+			}
+			doorStyle.Compatibility[last].Colours = append(doorStyle.Compatibility[last].Colours, colour)
+		}
+		compatibilityRows.Close()
+		if err = compatibilityRows.Err(); err != nil {
+			return nil, err
+		}
 
-	err = stmt.Close()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
-	}
+		rows, err := tx.QueryContext(queryCtx, `
+			SELECT door_style_types.id, door_style_types.name
+			FROM door_style_door_style_types
+			INNER JOIN door_style_types ON door_style_door_style_types.door_style_type_id = door_style_types.id
+			WHERE door_style_door_style_types.door_style_id = $1
+			ORDER BY door_style_types.name ASC`,
+			id)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
 
-	ctx.StatusCode(iris.StatusOK)
-	idString := strconv.FormatInt(id, 10)
-	ctx.JSON(map[string]interface{}{
-		"id": idString,
-	})
+		for rows.Next() {
+			doorStyleType := DoorStyleType{}
+			if err = rows.Scan(&doorStyleType.ID, &doorStyleType.Name); err != nil {
+				return nil, err
+			}
+			doorStyle.DoorStyleTypes = append(doorStyle.DoorStyleTypes, doorStyleType)
+		}
+		return doorStyle, rows.Err()
+	},
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		doorStyle, err := readBulkDoorStyle(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		return saveDoorStyle(ctx, tx, doorStyle)
+	},
 }
@@ -1,7 +1,7 @@
 package muskoka
 
 import (
-	"fmt"
+	stdContext "context"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -20,13 +20,14 @@ var sesService *ses.SES
 func InitSES() {
 	sess, err := session.NewSession()
 	if err != nil {
-		fmt.Println("failed to create session,", err)
+		GetLogger().Error("failed to create ses session", "error", err)
 		return
 	}
 	sesService = ses.New(sess, aws.NewConfig().WithRegion("us-east-1"))
 }
 
-func (email *Email) Send() error {
+func (email *Email) Send(ctx stdContext.Context) error {
+	ctx, span := startSpan(ctx, "ses.SendEmail")
 
 	stringArrayToAWS(email.To)
 	params := &ses.SendEmailInput{
@@ -49,7 +50,9 @@ func (email *Email) Send() error {
 			// More values...
 		},
 	}
-	_, err := sesService.SendEmail(params)
+	_, err := sesService.SendEmailWithContext(ctx, params)
+	sesEmailsTotal.WithLabelValues(resultLabel(err)).Inc()
+	endSpan(span, err)
 	return err
 }
 
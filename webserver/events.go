@@ -0,0 +1,362 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/kataras/iris/core/router"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const catalogEventsChannel = "muskoka_catalog_events"
+
+var eventStreamsActive = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "muskoka_event_streams_active",
+	Help: "Open /events streams on this instance.",
+})
+
+// CatalogEvent is a saved change, e.g. door_sample.created. Data is what the
+// write endpoint returned or was sent, deletes only have the id.
+type CatalogEvent struct {
+	ID        int64           `json:"id"`
+	Event     string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+func InitCatalogEvents() {
+	createCatalogEventTable()
+}
+
+// catalog_events keeps the last MUSKOKA_EVENT_LOG_SIZE events so streams
+// can resume with Last-Event-ID
+func createCatalogEventTable() {
+	_, err := GetDBConnection().Exec(`
+		CREATE TABLE IF NOT EXISTS catalog_events (
+			id BIGSERIAL PRIMARY KEY,
+			event text NOT NULL,
+			data jsonb NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// EmitCatalogEventTx logs the event and queues its webhook deliveries in
+// tx, so they're saved if and only if the change is. Streams are notified
+// when tx commits; the caller should wakeWebhookWorker after that.
+func EmitCatalogEventTx(ctx stdContext.Context, tx *sql.Tx, event string, data interface{}) error {
+	if err := recordCatalogEvent(ctx, tx, event, data); err != nil {
+		return err
+	}
+	return queueWebhookDeliveries(ctx, tx, event, data)
+}
+
+// commitWithCatalogEvent records the event in tx, commits it and wakes the
+// webhook worker, for handlers that commit their own transaction
+func commitWithCatalogEvent(ctx stdContext.Context, tx *sql.Tx, event string, data interface{}) error {
+	if err := EmitCatalogEventTx(ctx, tx, event, data); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	wakeWebhookWorker()
+	return nil
+}
+
+// recordCatalogEvent appends to the event log, trims it and notifies every
+// instance. Ids are handed out under a lock held until tx ends, so they
+// commit in order and reading after the last id seen never skips one that
+// committed late.
+func recordCatalogEvent(ctx stdContext.Context, tx *sql.Tx, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err = tx.ExecContext(queryCtx, `SELECT pg_advisory_xact_lock(hashtext($1))`, catalogEventsChannel)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(queryCtx, `
+		WITH inserted AS (
+			INSERT INTO catalog_events (event, data)
+			VALUES ($1, $2::jsonb)
+			RETURNING id
+		), trimmed AS (
+			DELETE FROM catalog_events
+			WHERE id <= (SELECT id FROM inserted) - $3
+		)
+		SELECT pg_notify($4, id::text) FROM inserted
+		`, event, string(payload), GetConfig().EventLogSize, catalogEventsChannel)
+	return err
+}
+
+// EventBroker fans the event log out to the streams on this instance
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan CatalogEvent]struct{}
+	lastID      int64
+}
+
+var eventBroker *EventBroker
+var eventBrokerOnce sync.Once
+
+func GetEventBroker() *EventBroker {
+	eventBrokerOnce.Do(func() {
+		eventBroker = &EventBroker{
+			subscribers: map[chan CatalogEvent]struct{}{},
+		}
+	})
+	return eventBroker
+}
+
+// Subscribe returns a channel of new events. The broker closes it if the
+// subscriber falls too far behind, the stream should end then so the client
+// resumes from the log.
+func (b *EventBroker) Subscribe() chan CatalogEvent {
+	ch := make(chan CatalogEvent, 64)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *EventBroker) Unsubscribe(ch chan CatalogEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *EventBroker) publish(events []CatalogEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		for _, event := range events {
+			select {
+			case ch <- event:
+			default:
+				delete(b.subscribers, ch)
+				close(ch)
+			}
+			if _, ok := b.subscribers[ch]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// catchUp publishes everything logged since the last event it saw.
+// Notifications only wake it, so ones missed while disconnected are picked
+// up on the next.
+func (b *EventBroker) catchUp(ctx stdContext.Context) error {
+	for {
+		events, err := FindCatalogEvents(ctx, b.lastID, 500)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		b.lastID = events[len(events)-1].ID
+		b.publish(events)
+	}
+}
+
+// StartEventBroker listens for events from every instance, starting from
+// the newest one already logged
+func StartEventBroker() error {
+	broker := GetEventBroker()
+
+	queryCtx, cancel := withQueryTimeout(stdContext.Background())
+	err := GetDBConnection().QueryRowContext(queryCtx,
+		`SELECT COALESCE(max(id), 0) FROM catalog_events`).Scan(&broker.lastID)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	listener := pq.NewListener(GetConfig().DB.ConnectionString(), 10*time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				GetLogger().Warn("event listener", "event", event, "error", err)
+			}
+		})
+	if err := listener.Listen(catalogEventsChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	ctx, stop := stdContext.WithCancel(stdContext.Background())
+	done := make(chan struct{})
+	OnShutdown(func() {
+		stop()
+		<-done
+		listener.Close()
+	})
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				// A nil notification is a reconnect, catching up covers it
+				if err := broker.catchUp(ctx); err != nil && ctx.Err() == nil {
+					GetLogger().Error("unable to read catalog events", "error", err)
+				}
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// FindCatalogEvents returns up to limit logged events after afterID, oldest
+// first
+func FindCatalogEvents(ctx stdContext.Context, afterID int64, limit int) ([]CatalogEvent, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT id, event, data, created_at
+		FROM catalog_events
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2
+		`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []CatalogEvent{}
+	for rows.Next() {
+		event := CatalogEvent{}
+		if err := rows.Scan(&event.ID, &event.Event, &event.Data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func CreateEventsAPI(party router.Party) {
+	party.Get("", AdminMiddleware, eventsHandler)
+}
+
+// eventsHandler streams catalog events to admins as Server-Sent Events.
+// AdminMiddleware reads the token from the Authorization header, so
+// clients need a fetch based EventSource. A Last-Event-ID older than the
+// log gets a reset event, meaning the client should reload everything.
+func eventsHandler(ctx context.Context) {
+	lastEventID := int64(-1)
+	if header := ctx.GetHeader("Last-Event-ID"); len(header) > 0 {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "Unable to read Last-Event-ID"})
+			return
+		}
+		lastEventID = id
+	}
+
+	// Subscribe before reading the log, events seen twice are skipped below
+	broker := GetEventBroker()
+	events := broker.Subscribe()
+	defer broker.Unsubscribe(events)
+
+	reqCtx := ctx.Request().Context()
+	var backlog []CatalogEvent
+	if lastEventID >= 0 {
+		var err error
+		backlog, err = FindCatalogEvents(reqCtx, lastEventID, GetConfig().EventLogSize)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+	}
+
+	ctx.ContentType("text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	// Stops nginx buffering the stream
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.StatusCode(iris.StatusOK)
+
+	eventStreamsActive.Inc()
+	defer eventStreamsActive.Dec()
+
+	w := ctx.ResponseWriter()
+	fmt.Fprintf(w, "retry: %d\n\n", GetConfig().EventRetry.Nanoseconds()/int64(time.Millisecond))
+
+	sent := lastEventID
+	if lastEventID >= 0 && lastEventID+1 < oldestCatalogEventID(reqCtx) {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range backlog {
+		writeCatalogEvent(w, event)
+		sent = event.ID
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(GetConfig().EventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-reqCtx.Done():
+			return
+		case <-Draining():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// Too far behind, the client resumes from the log
+				return
+			}
+			if event.ID <= sent {
+				continue
+			}
+			writeCatalogEvent(w, event)
+			sent = event.ID
+			w.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			w.Flush()
+		}
+	}
+}
+
+// oldestCatalogEventID is zero if the log can't be read, which doesn't
+// reset anything
+func oldestCatalogEventID(ctx stdContext.Context) int64 {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var id int64
+	GetDBConnection().QueryRowContext(queryCtx,
+		`SELECT COALESCE(min(id), 0) FROM catalog_events`).Scan(&id)
+	return id
+}
+
+func writeCatalogEvent(w context.ResponseWriter, event CatalogEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.ID, data)
+}
@@ -0,0 +1,314 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"encoding/csv"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	csvContentType  = "text/csv"
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+	// Rows written between flushes of a CSV export
+	exportFlushRows = 100
+)
+
+// exportColumn is one column of a spreadsheet export. Numbers are written
+// as numbers to XLSX, everything else as text.
+type exportColumn struct {
+	Header string
+	SQL    string
+	Number bool
+}
+
+// exportQuery selects one row per spreadsheet row. Where and Joins are the
+// same the JSON endpoint filters with.
+type exportQuery struct {
+	Name    string
+	From    string
+	Joins   []string
+	Where   string
+	Args    []interface{}
+	Order   string
+	Columns []exportColumn
+	// Locale is the language of translated columns, serveExport sets it
+	Locale string
+}
+
+var (
+	colourExportColumns = []exportColumn{
+		{Header: "ID", SQL: "colours.id", Number: true},
+		{Header: "Name", SQL: localizedSQL("colour", "name")},
+		{Header: "Hex", SQL: "colours.hex"},
+		{Header: "Family", SQL: "colours.family"},
+		{Header: "Finish", SQL: "colours.finish"},
+	}
+
+	woodExportColumns = []exportColumn{
+		{Header: "ID", SQL: "wood.id", Number: true},
+		{Header: "Name", SQL: localizedSQL("wood", "name")},
+		{Header: "Janka Hardness", SQL: "wood.janka_hardness", Number: true},
+		{Header: "Grain Pattern", SQL: "wood.grain_pattern"},
+		{Header: "Paintable", SQL: "CASE WHEN wood.paintable THEN 'yes' ELSE 'no' END"},
+		{Header: "Stainable", SQL: "CASE WHEN wood.stainable THEN 'yes' ELSE 'no' END"},
+		{Header: "Price Tier", SQL: "wood.price_tier"},
+		{Header: "Sustainability Notes", SQL: "wood.sustainability_notes"},
+	}
+
+	doorStyleExportColumns = []exportColumn{
+		{Header: "ID", SQL: "door_styles.id", Number: true},
+		{Header: "Name", SQL: localizedSQL("door-style", "name")},
+		{Header: "Description", SQL: "door_styles.description"},
+		{Header: "Door Style Types", SQL: `(
+			SELECT string_agg(` + localizedSQL("door-style-type", "name") + `, ', '
+				ORDER BY ` + localizedSQL("door-style-type", "name") + ` ASC)
+			FROM door_style_door_style_types
+			INNER JOIN door_style_types ON door_style_door_style_types.door_style_type_id = door_style_types.id
+			WHERE door_style_door_style_types.door_style_id = door_styles.id
+		)`},
+	}
+
+	doorSampleExportColumns = []exportColumn{
+		{Header: "ID", SQL: "door_samples.id", Number: true},
+		{Header: "Door Style", SQL: localizedSQL("door-style", "name")},
+		{Header: "Wood", SQL: localizedSQL("wood", "name")},
+		{Header: "Colour", SQL: localizedSQL("colour", "name")},
+		{Header: "Image", SQL: "images.filename"},
+	}
+
+	dealerExportColumns = []exportColumn{
+		{Header: "ID", SQL: "dealers.id", Number: true},
+		{Header: "Name", SQL: "dealers.name"},
+		{Header: "Location", SQL: localizedSQL("dealer", "location")},
+		{Header: "Phone", SQL: "dealers.phone_num"},
+		{Header: "Email", SQL: "dealers.email"},
+		{Header: "Order", SQL: "dealers.order_num", Number: true},
+		{Header: "Link", SQL: "dealers.link"},
+		{Header: "Description", SQL: localizedSQL("dealer", "description")},
+	}
+)
+
+// exportFormat picks csv or xlsx from ?format=, or else from Accept, and
+// returns "" for JSON. ok is false for a format we don't have.
+func exportFormat(ctx context.Context) (format string, ok bool) {
+	return parseExportFormat(ctx.URLParam("format"), ctx.GetHeader("Accept"))
+}
+
+func parseExportFormat(param string, accept string) (format string, ok bool) {
+	switch param {
+	case "":
+	case "json":
+		return "", true
+	case "csv":
+		return "csv", true
+	case "xlsx":
+		return "xlsx", true
+	default:
+		return "", false
+	}
+
+	// The first type we can serve wins, browsers send */* last
+	for _, accepted := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case csvContentType:
+			return "csv", true
+		case xlsxContentType:
+			return "xlsx", true
+		case "application/json", "*/*":
+			return "", true
+		}
+	}
+	return "", true
+}
+
+// serveExport answers a list GET that asked for CSV or XLSX and reports
+// false for the handler to answer with JSON. Rows are written as they're
+// read, so a failure part way through ends the file early and is only
+// logged.
+func serveExport(ctx context.Context, query exportQuery) bool {
+	// The same URL answers with different types
+	ctx.ResponseWriter().Header().Add("Vary", "Accept")
+
+	format, ok := exportFormat(ctx)
+	if !ok {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Format must be json, csv or xlsx"})
+		return true
+	}
+	if len(format) == 0 {
+		return false
+	}
+	// Downloads skip ServeCacheable's ETag, so always ask again
+	ctx.Header("Cache-Control", "no-cache")
+	query.Locale = RequestLocale(ctx)
+
+	columns := []string{}
+	for _, column := range query.Columns {
+		columns = append(columns, "("+column.SQL+")::text")
+	}
+	sqlQuery := "SELECT " + strings.Join(columns, ", ") + "\nFROM " + query.From
+	for _, join := range query.Joins {
+		sqlQuery += "\n" + join
+	}
+	if len(query.Where) > 0 {
+		sqlQuery += "\nWHERE " + query.Where
+	}
+	if len(query.Order) > 0 {
+		sqlQuery += "\nORDER BY " + query.Order
+	}
+	sqlQuery, args := bindLocale(sqlQuery, query.Args, query.Locale)
+
+	// Exports take longer than one statement is allowed to
+	queryCtx, cancel := stdContext.WithTimeout(ctx.Request().Context(), GetConfig().ExportTimeout)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, sqlQuery, args...)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return true
+	}
+	defer rows.Close()
+
+	if format == "csv" {
+		err = writeCSVExport(ctx, query, rows)
+	} else {
+		err = writeXLSXExport(ctx, query, rows)
+	}
+	if err != nil {
+		LoggerFrom(ctx.Request().Context()).Error("export failed", "export", query.Name,
+			"format", format, "error", err)
+	}
+	return true
+}
+
+func scanExportRow(rows *sql.Rows, count int) ([]sql.NullString, error) {
+	values := make([]sql.NullString, count)
+	dest := make([]interface{}, count)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	return values, rows.Scan(dest...)
+}
+
+func writeCSVExport(ctx context.Context, query exportQuery, rows *sql.Rows) error {
+	ctx.ContentType(csvContentType + "; charset=utf-8")
+	ctx.Header("Content-Disposition", `attachment; filename="`+query.Name+`.csv"`)
+	ctx.StatusCode(iris.StatusOK)
+
+	w := csv.NewWriter(ctx.ResponseWriter())
+	record := []string{}
+	for _, column := range query.Columns {
+		record = append(record, column.Header)
+	}
+	if err := w.Write(record); err != nil {
+		return err
+	}
+
+	for count := 1; rows.Next(); count++ {
+		values, err := scanExportRow(rows, len(query.Columns))
+		if err != nil {
+			return err
+		}
+		for i, value := range values {
+			record[i] = csvCell(value.String)
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+
+		if count%exportFlushRows == 0 {
+			w.Flush()
+			ctx.ResponseWriter().Flush()
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+// csvCell stops spreadsheet apps from running a cell as a formula
+func csvCell(value string) string {
+	if len(value) > 0 && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// writeXLSXExport streams rows into the sheet, which excelize keeps on disk
+// once it grows, and then writes the workbook
+func writeXLSXExport(ctx context.Context, query exportQuery, rows *sql.Rows) error {
+	file := excelize.NewFile()
+	defer file.Close()
+
+	sheet := file.GetSheetName(0)
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+
+	header := []interface{}{}
+	for _, column := range query.Columns {
+		header = append(header, column.Header)
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	row := 2
+	for rows.Next() {
+		values, err := scanExportRow(rows, len(query.Columns))
+		if err != nil {
+			return err
+		}
+
+		cells := make([]interface{}, len(values))
+		for i, value := range values {
+			if !value.Valid {
+				continue
+			}
+			cells[i] = value.String
+			if query.Columns[i].Number {
+				if number, err := strconv.ParseInt(value.String, 10, 64); err == nil {
+					cells[i] = number
+				}
+			}
+		}
+
+		cell, err := excelize.CoordinatesToCellName(1, row)
+		if err != nil {
+			return err
+		}
+		if err := stream.SetRow(cell, cells); err != nil {
+			return err
+		}
+		row++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := stream.Flush(); err != nil {
+		return err
+	}
+
+	ctx.ContentType(xlsxContentType)
+	ctx.Header("Content-Disposition", `attachment; filename="`+query.Name+`.xlsx"`)
+	ctx.StatusCode(iris.StatusOK)
+	_, err = file.WriteTo(ctx.ResponseWriter())
+	return err
+}
@@ -0,0 +1,62 @@
+package muskoka
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"Maple", "Maple"},
+		{"12", "12"},
+		{"a=b", "a=b"},
+		{"=SUM(A1:A2)", "'=SUM(A1:A2)"},
+		{"+1 416 555 0100", "'+1 416 555 0100"},
+		{"-5", "'-5"},
+		{"@cmd", "'@cmd"},
+		{"\tindented", "'\tindented"},
+		{"\rreturn", "'\rreturn"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if got := csvCell(test.value); got != test.want {
+				t.Errorf("csvCell(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseExportFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		param  string
+		accept string
+		format string
+		ok     bool
+	}{
+		{"nothing asked for", "", "", "", true},
+		{"format csv", "csv", "", "csv", true},
+		{"format xlsx", "xlsx", "", "xlsx", true},
+		{"format json", "json", csvContentType, "", true},
+		{"format wins over accept", "csv", xlsxContentType, "csv", true},
+		{"unknown format", "pdf", "", "", false},
+		{"accept csv", "", "text/csv; charset=utf-8", "csv", true},
+		{"accept xlsx", "", xlsxContentType, "xlsx", true},
+		{"first type wins", "", "text/csv, application/json", "csv", true},
+		{"browser", "", "text/html,application/xhtml+xml,*/*;q=0.8", "", true},
+		{"json before csv", "", "application/json, text/csv", "", true},
+		{"unknown type", "", "application/pdf", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, ok := parseExportFormat(test.param, test.accept)
+			if format != test.format || ok != test.ok {
+				t.Errorf("parseExportFormat(%q, %q) = %q, %v, want %q, %v",
+					test.param, test.accept, format, ok, test.format, test.ok)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package muskoka
 
 import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
 	"net/url"
 	"strconv"
 	"github.com/kataras/iris"
@@ -27,11 +30,13 @@ func createGallerySampleTable() {
 }
 
 func CreateGallerySampleAPI(party router.Party) {
-
-	party.Get("/findOne/:id", findOneGallerySampleHandler)
-	party.Get("", findGallerySamplesHandler)
+	cachePolicy := CatalogCachePolicy("gallery-sample")
+	party.Get("/findOne/:id", CacheMiddleware(cachePolicy), findOneGallerySampleHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findGallerySamplesHandler)
 	party.Put("", updateOneGallerySampleHandler)
-	party.Post("", insertGallerySampleHandler)
+	party.Post("", IdempotencyMiddleware, insertGallerySampleHandler)
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(gallerySampleBulkResource))
+	party.Patch("/:id", AdminMiddleware, PatchHandler(gallerySamplePatchResource))
 	party.Delete("/:id", removeOneGallerySampleHandler)
 }
 
@@ -43,8 +48,17 @@ func findOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
+	if serveShaped(ctx, gallerySampleShape, shapeQuery{
+		Joins: []string{joinGallerySampleImages},
+		Where: "gallery_samples.id = $1", Args: []interface{}{id}, One: true,
+	}, "gallery-sample/"+strconv.FormatInt(id, 10)) {
+		return
+	}
+
 	gallerySample := GallerySample{ID: id}
-	err = GetDBConnection().QueryRow(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = GetDBConnection().QueryRowContext(queryCtx, `
 		SELECT images.id, images.filename, images.size
 		FROM gallery_samples
 		INNER JOIN images ON gallery_samples.id = images.gallery_sample_id
@@ -58,12 +72,15 @@ func findOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(gallerySample)
+	ServeCacheable(ctx, gallerySample, "gallery-sample/"+strconv.FormatInt(id, 10))
 }
 
 func findGallerySamplesHandler(ctx context.Context) {
-	gallerySamples, err := FindGallerySamples()
+	if serveShaped(ctx, gallerySampleShape, shapeQuery{Joins: []string{joinGallerySampleImages}}) {
+		return
+	}
+
+	gallerySamples, err := FindGallerySamples(ctx.Request().Context())
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -71,12 +88,13 @@ func findGallerySamplesHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(gallerySamples)
+	ServeCacheable(ctx, gallerySamples)
 }
 
-func FindGallerySamples() (*[]GallerySample, error) {
-	rows, err := GetDBConnection().Query(`
+func FindGallerySamples(ctx stdContext.Context) (*[]GallerySample, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
 		SELECT gallery_samples.id, images.id, images.filename, images.size
 		FROM gallery_samples
 		INNER JOIN images ON gallery_samples.id = images.gallery_sample_id`)
@@ -109,8 +127,19 @@ func insertGallerySampleHandler(ctx context.Context) {
 
 	gallerySample.Image.Filename = url.QueryEscape(gallerySample.Image.Filename)
 
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
 	// Create Gallery Sample
-	err := GetDBConnection().QueryRow(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `
 		INSERT INTO gallery_samples (id) VALUES (DEFAULT) returning id;
 		`).Scan(&gallerySample.ID)
 	if err != nil {
@@ -121,7 +150,9 @@ func insertGallerySampleHandler(ctx context.Context) {
 	}
 
 	// Create new image 
-	err = GetDBConnection().QueryRow(`
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `
 		INSERT INTO images (filename, size, image_type_id, gallery_sample_id)
 		VALUES($1,$2,$3,$4) 
 		returning id;`,
@@ -134,6 +165,14 @@ func insertGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "gallery_sample.created", gallerySample)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(gallerySample)
 }
@@ -146,17 +185,20 @@ func removeOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
-	tx, err := GetDBConnection().Begin()
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
+	defer tx.Rollback()
 
 	// Get image filename
 	filename := ""
-	dbErr := tx.QueryRow(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	dbErr := tx.QueryRowContext(queryCtx, `
 		SELECT filename 
 		FROM images
 		WHERE gallery_sample_id=$1`,
@@ -168,15 +210,10 @@ func removeOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
-	// Delete from S3
-	err = deleteS3Object(filename)
-	if err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(err)
-	}
-
 	// Delete Image from database
-	stmt, err := tx.Prepare(`delete from images where filename=$1`)
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := tx.PrepareContext(queryCtx, `delete from images where filename=$1`)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -184,7 +221,7 @@ func removeOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
-	res, err := stmt.Exec(filename)
+	res, err := stmt.ExecContext(queryCtx, filename)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -215,7 +252,9 @@ func removeOneGallerySampleHandler(ctx context.Context) {
 	}
 
 	// Delete doorsample from database
-	stmt, err = tx.Prepare(`delete from gallery_samples where id=$1`)
+	queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err = tx.PrepareContext(queryCtx, `delete from gallery_samples where id=$1`)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -223,7 +262,7 @@ func removeOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
-	res, err = stmt.Exec(id)
+	res, err = stmt.ExecContext(queryCtx, id)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -239,14 +278,6 @@ func removeOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
-	}
-
 	affect, err = res.RowsAffected()
 	if err != nil {
 		statusCode, result := HandleDBError(err)
@@ -261,6 +292,17 @@ func removeOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "gallery_sample.deleted", map[string]interface{}{"id": id})
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	// Delete from S3
+	deleteS3ObjectAfterCommit(ctx.Request().Context(), filename)()
+
 	ctx.StatusCode(iris.StatusOK)
 	idString := strconv.FormatInt(id, 10)
 	ctx.JSON(map[string]interface{}{
@@ -276,9 +318,20 @@ func updateOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
 	// Are we updating the image?
 	oldImage := &Image{}
-	err := GetDBConnection().QueryRow(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `
 		SELECT filename, size 
 		FROM images
 		WHERE gallery_sample_id = $1`,
@@ -290,10 +343,13 @@ func updateOneGallerySampleHandler(ctx context.Context) {
 		return
 	}
 
+	var oldFilename string
 	if gallerySample.Image.Filename != oldImage.Filename || gallerySample.Image.Size != oldImage.Size {
 
 		// Update the database
-		stmt, err := GetDBConnection().Prepare(`
+		queryCtx, cancel = withQueryTimeout(ctx.Request().Context())
+		defer cancel()
+		stmt, err := tx.PrepareContext(queryCtx, `
 			UPDATE images 
 			SET filename = $1, size = $2
 			WHERE id = $3
@@ -305,7 +361,7 @@ func updateOneGallerySampleHandler(ctx context.Context) {
 			return
 		}
 
-		res, err := stmt.Exec(gallerySample.Image.Filename, gallerySample.Image.Size, gallerySample.Image.ID)
+		res, err := stmt.ExecContext(queryCtx, gallerySample.Image.Filename, gallerySample.Image.Size, gallerySample.Image.ID)
 		if err != nil {
 			statusCode, result := HandleDBError(err)
 			ctx.StatusCode(statusCode)
@@ -334,14 +390,142 @@ func updateOneGallerySampleHandler(ctx context.Context) {
 			return
 		}
 
-		// Update S3
-		err = deleteS3Object(oldImage.Filename)
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.JSON(err)
-		}
+		oldFilename = oldImage.Filename
+	}
+
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "gallery_sample.updated", gallerySample)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	// Update S3
+	if len(oldFilename) > 0 {
+		deleteS3ObjectAfterCommit(ctx.Request().Context(), oldFilename)()
 	}
 
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(map[string]interface{}{})
 }
+
+var gallerySampleBulkResource = BulkResource{
+	Entity: "gallery-sample",
+	Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		gallerySample, err := readBulkGallerySample(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		gallerySample.Image.Filename = url.QueryEscape(gallerySample.Image.Filename)
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO gallery_samples (id) VALUES (DEFAULT) returning id;`).Scan(&gallerySample.ID)
+		if err != nil {
+			return BulkChange{}, err
+		}
+
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO images (filename, size, image_type_id, gallery_sample_id)
+			VALUES($1,$2,$3,$4)
+			returning id;`,
+			gallerySample.Image.Filename, gallerySample.Image.Size, gallerySample.Image.ImageType.ID,
+			gallerySample.ID).Scan(&gallerySample.Image.ID)
+		return BulkChange{ID: gallerySample.ID, Data: gallerySample}, err
+	},
+	// Stored filenames are escaped, updates send them back as they are
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		gallerySample, err := readBulkGallerySample(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		change := BulkChange{ID: gallerySample.ID, Data: gallerySample}
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		images, err := findOwnedImagesForUpdate(queryCtx, tx, gallerySampleImageOwner, gallerySample.ID)
+		if err != nil {
+			return change, err
+		}
+		if len(images) == 0 {
+			return change, sql.ErrNoRows
+		}
+		oldImage := images[0]
+		gallerySample.Image.ID = oldImage.ID
+
+		if gallerySample.Image.Filename == oldImage.Filename && gallerySample.Image.Size == oldImage.Size &&
+			gallerySample.Image.ImageType.ID == oldImage.ImageType.ID {
+			return change, nil
+		}
+		_, err = tx.ExecContext(queryCtx, `
+			UPDATE images
+			SET filename = $1, size = $2, image_type_id = $3
+			WHERE id = $4`,
+			gallerySample.Image.Filename, gallerySample.Image.Size, gallerySample.Image.ImageType.ID,
+			oldImage.ID)
+		if err != nil {
+			return change, err
+		}
+		if gallerySample.Image.Filename != oldImage.Filename {
+			change.AfterCommit = deleteS3ObjectAfterCommit(ctx, oldImage.Filename)
+		}
+		return change, nil
+	},
+	Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		// The image goes with the gallery sample through ON DELETE CASCADE
+		images, err := findOwnedImagesForUpdate(queryCtx, tx, gallerySampleImageOwner, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		filenames := []string{}
+		for _, image := range images {
+			filenames = append(filenames, image.Filename)
+		}
+
+		res, err := tx.ExecContext(queryCtx, `DELETE FROM gallery_samples WHERE id=$1`, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return BulkChange{}, err
+		}
+		return BulkChange{AfterCommit: deleteS3ObjectAfterCommit(ctx, filenames...)}, nil
+	},
+}
+
+var gallerySamplePatchResource = PatchResource{
+	Entity: "gallery-sample",
+	Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		images, err := findOwnedImagesForUpdate(queryCtx, tx, gallerySampleImageOwner, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(images) == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return &GallerySample{ID: id, Image: images[0]}, nil
+	},
+	Update: gallerySampleBulkResource.Update,
+}
+
+func readBulkGallerySample(data json.RawMessage) (*GallerySample, error) {
+	gallerySample := &GallerySample{}
+	if err := json.Unmarshal(data, gallerySample); err != nil {
+		return nil, err
+	}
+	if len(gallerySample.Image.Filename) == 0 {
+		return nil, ValidationErrors{"image": "Image is required."}
+	}
+	if gallerySample.Image.ImageType.ID < 1 {
+		return nil, ValidationErrors{"imageType": "Image Type is required."}
+	}
+	return gallerySample, nil
+}
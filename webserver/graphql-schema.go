@@ -0,0 +1,850 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+var graphqlSchema graphql.Schema
+var graphqlSchemaOnce sync.Once
+
+func GetGraphQLSchema() graphql.Schema {
+	graphqlSchemaOnce.Do(func() {
+		var err error
+		graphqlSchema, err = graphql.NewSchema(graphql.SchemaConfig{
+			Query:    graphqlQueryType,
+			Mutation: graphqlMutationType,
+		})
+		if err != nil {
+			panic(err)
+		}
+	})
+	return graphqlSchema
+}
+
+var graphqlImageTypeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ImageType",
+	Fields: graphql.Fields{
+		"id":                  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name":                &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"isSpecificDimension": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"width":               &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"height":              &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphqlImageType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Image",
+	Fields: graphql.Fields{
+		"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"filename": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"size":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		// Only door sample images are ordered and have a primary
+		"position":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"primary":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"placeholder": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"imageType": &graphql.Field{
+			Type: graphqlImageTypeType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				image := p.Source.(Image)
+				return loadersFrom(p.Context).imageTypes.Load(p.Context, image.ID), nil
+			},
+		},
+	},
+})
+
+var graphqlColourRGBType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ColourRGB",
+	Fields: graphql.Fields{
+		"r": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"g": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"b": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphqlColourLABType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ColourLAB",
+	Fields: graphql.Fields{
+		"l": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		"a": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		"b": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+	},
+})
+
+var graphqlColourType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Colour",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.String),
+			Resolve: graphqlName("colour"),
+		},
+		"hex": &graphql.Field{
+			Type: graphql.String,
+			Resolve: graphqlColourField(func(colour *Colour) interface{} {
+				if len(colour.Hex) == 0 {
+					return nil
+				}
+				return colour.Hex
+			}),
+		},
+		"rgb": &graphql.Field{
+			Type: graphqlColourRGBType,
+			Resolve: graphqlColourField(func(colour *Colour) interface{} {
+				if colour.RGB == nil {
+					return nil
+				}
+				return *colour.RGB
+			}),
+		},
+		"lab": &graphql.Field{
+			Type: graphqlColourLABType,
+			Resolve: graphqlColourField(func(colour *Colour) interface{} {
+				if colour.LAB == nil {
+					return nil
+				}
+				return *colour.LAB
+			}),
+		},
+		"family": &graphql.Field{
+			Type: graphql.String,
+			Resolve: graphqlColourField(func(colour *Colour) interface{} {
+				if len(colour.Family) == 0 {
+					return nil
+				}
+				return colour.Family
+			}),
+		},
+		"finish": &graphql.Field{
+			Type: graphql.String,
+			Resolve: graphqlColourField(func(colour *Colour) interface{} {
+				if len(colour.Finish) == 0 {
+					return nil
+				}
+				return colour.Finish
+			}),
+		},
+		"swatch": &graphql.Field{
+			Type: graphqlImageType,
+			Resolve: graphqlColourField(func(colour *Colour) interface{} {
+				if colour.Swatch == nil {
+					return nil
+				}
+				return *colour.Swatch
+			}),
+		},
+	},
+})
+
+var graphqlWoodType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Wood",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.String),
+			Resolve: graphqlName("wood"),
+		},
+		"jankaHardness": &graphql.Field{
+			Type: graphql.Int,
+			Resolve: graphqlWoodField(func(wood *Wood) interface{} {
+				if wood.JankaHardness == 0 {
+					return nil
+				}
+				return wood.JankaHardness
+			}),
+		},
+		"grainPattern": &graphql.Field{
+			Type: graphql.String,
+			Resolve: graphqlWoodField(func(wood *Wood) interface{} {
+				if len(wood.GrainPattern) == 0 {
+					return nil
+				}
+				return wood.GrainPattern
+			}),
+		},
+		"paintable": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.Boolean),
+			Resolve: graphqlWoodField(func(wood *Wood) interface{} { return wood.Paintable }),
+		},
+		"stainable": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.Boolean),
+			Resolve: graphqlWoodField(func(wood *Wood) interface{} { return wood.Stainable }),
+		},
+		"priceTier": &graphql.Field{
+			Type: graphql.String,
+			Resolve: graphqlWoodField(func(wood *Wood) interface{} {
+				if len(wood.PriceTier) == 0 {
+					return nil
+				}
+				return wood.PriceTier
+			}),
+		},
+		"sustainabilityNotes": &graphql.Field{
+			Type: graphql.String,
+			Resolve: graphqlWoodField(func(wood *Wood) interface{} {
+				if len(wood.SustainabilityNotes) == 0 {
+					return nil
+				}
+				return wood.SustainabilityNotes
+			}),
+		},
+		"images": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlImageType))),
+			Resolve: graphqlWoodField(func(wood *Wood) interface{} {
+				if wood.Images == nil {
+					return []Image{}
+				}
+				return wood.Images
+			}),
+		},
+	},
+})
+
+var graphqlDoorStyleTypeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "DoorStyleType",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.String),
+			Resolve: graphqlName("door-style-type"),
+		},
+	},
+})
+
+var graphqlDoorStyleType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "DoorStyle",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.String),
+			Resolve: graphqlName("door-style"),
+		},
+		"doorStyleTypes": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlDoorStyleTypeType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				doorStyle := p.Source.(DoorStyle)
+				// The door style list already has them, door samples don't
+				if doorStyle.DoorStyleTypes != nil {
+					return doorStyle.DoorStyleTypes, nil
+				}
+				return loadersFrom(p.Context).doorStyleTypes.Load(p.Context, doorStyle.ID), nil
+			},
+		},
+		"description": &graphql.Field{
+			Type: graphql.String,
+			Resolve: graphqlDoorStyleField(func(doorStyle *DoorStyle) interface{} {
+				if len(doorStyle.Description) == 0 {
+					return nil
+				}
+				return doorStyle.Description
+			}),
+		},
+		"profileDrawings": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlImageType))),
+			Resolve: graphqlDoorStyleField(func(doorStyle *DoorStyle) interface{} {
+				if doorStyle.ProfileDrawings == nil {
+					return []Image{}
+				}
+				return doorStyle.ProfileDrawings
+			}),
+		},
+		"compatibility": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlDoorStyleWoodOptionType))),
+			Resolve: graphqlDoorStyleField(func(doorStyle *DoorStyle) interface{} {
+				if doorStyle.Compatibility == nil {
+					return []DoorStyleWoodOption{}
+				}
+				return doorStyle.Compatibility
+			}),
+		},
+	},
+})
+
+var graphqlDoorStyleWoodOptionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "DoorStyleWoodOption",
+	Fields: graphql.Fields{
+		"wood":    &graphql.Field{Type: graphql.NewNonNull(graphqlWoodType)},
+		"colours": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlColourType)))},
+	},
+})
+
+var graphqlDoorStyleOptionsType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "DoorStyleOptions",
+	Fields: graphql.Fields{
+		"doorStyle":  &graphql.Field{Type: graphql.NewNonNull(graphqlDoorStyleType)},
+		"restricted": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"options":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlDoorStyleWoodOptionType)))},
+	},
+})
+
+var graphqlDoorSampleType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "DoorSample",
+	Fields: graphql.Fields{
+		"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"doorStyle": &graphql.Field{Type: graphql.NewNonNull(graphqlDoorStyleType)},
+		"wood":      &graphql.Field{Type: graphql.NewNonNull(graphqlWoodType)},
+		"colour":    &graphql.Field{Type: graphql.NewNonNull(graphqlColourType)},
+		"image":     &graphql.Field{Type: graphql.NewNonNull(graphqlImageType)},
+		"images":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlImageType)))},
+	},
+})
+
+var graphqlGallerySampleType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "GallerySample",
+	Fields: graphql.Fields{
+		"id":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"image": &graphql.Field{Type: graphql.NewNonNull(graphqlImageType)},
+	},
+})
+
+var graphqlDealerType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Dealer",
+	Fields: graphql.Fields{
+		"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"link": &graphql.Field{Type: graphql.String},
+		"location": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				source := p.Source.(Dealer)
+				return translatorFrom(p.Context).text("dealer", source.ID, "location", source.Location), nil
+			},
+		},
+		// Phone numbers don't fit in a GraphQL Int
+		"phoneNumber": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return strconv.FormatInt(p.Source.(Dealer).PhoneNumber, 10), nil
+			},
+		},
+		"email":    &graphql.Field{Type: graphql.String},
+		"orderNum": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"image":    &graphql.Field{Type: graphql.NewNonNull(graphqlImageType)},
+		"description": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				source := p.Source.(Dealer)
+				description := translatorFrom(p.Context).text("dealer", source.ID, "description", source.Description)
+				if len(description) == 0 {
+					return nil, nil
+				}
+				return description, nil
+			},
+		},
+	},
+})
+
+var graphqlIDArgs = graphql.FieldConfigArgument{
+	"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+}
+
+var graphqlQueryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"doorSamples": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlDoorSampleType)),
+			Args: graphql.FieldConfigArgument{
+				"colourIds":        &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+				"woodIds":          &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+				"doorStyleIds":     &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+				"searchText":       &graphql.ArgumentConfig{Type: graphql.String},
+				"paintable":        &graphql.ArgumentConfig{Type: graphql.Boolean},
+				"stainable":        &graphql.ArgumentConfig{Type: graphql.Boolean},
+				"priceTiers":       &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				"grainPatterns":    &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				"minJankaHardness": &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				search := DoorSampleSearch{
+					ColourIDs:    intListArg(p.Args, "colourIds"),
+					WoodIDs:      intListArg(p.Args, "woodIds"),
+					DoorStyleIDs: intListArg(p.Args, "doorStyleIds"),
+				}
+				search.SearchText, _ = p.Args["searchText"].(string)
+				search.Wood.Paintable, _ = p.Args["paintable"].(bool)
+				search.Wood.Stainable, _ = p.Args["stainable"].(bool)
+				search.Wood.PriceTiers = stringListArg(p.Args, "priceTiers")
+				search.Wood.GrainPatterns = stringListArg(p.Args, "grainPatterns")
+				search.Wood.MinJankaHardness, _ = p.Args["minJankaHardness"].(int)
+				return graphqlResult(FindDoorSamples(p.Context, &search))
+			},
+		},
+		"doorSample": &graphql.Field{
+			Type: graphqlDoorSampleType,
+			Args: graphqlIDArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindDoorSampleFromID(p.Context, int64(p.Args["id"].(int))))
+			},
+		},
+		"doorStyles": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlDoorStyleType)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindDoorStyles(p.Context))
+			},
+		},
+		"doorStyle": &graphql.Field{
+			Type: graphqlDoorStyleType,
+			Args: graphqlIDArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				doorStyles, err := FindDoorStyles(p.Context)
+				if err != nil {
+					return graphqlResult(nil, err)
+				}
+				id := int64(p.Args["id"].(int))
+				for _, doorStyle := range *doorStyles {
+					if doorStyle.ID == id {
+						return doorStyle, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		"doorStyleOptions": &graphql.Field{
+			Type: graphqlDoorStyleOptionsType,
+			Args: graphqlIDArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindDoorStyleOptions(p.Context, int64(p.Args["id"].(int))))
+			},
+		},
+		"doorStyleTypes": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlDoorStyleTypeType)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindDoorStyleTypes(p.Context))
+			},
+		},
+		"woods": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlWoodType)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindWoods(p.Context))
+			},
+		},
+		"wood": &graphql.Field{
+			Type: graphqlWoodType,
+			Args: graphqlIDArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				wood, err := FindWoodFromID(p.Context, int64(p.Args["id"].(int)))
+				if err != nil {
+					return graphqlResult(nil, err)
+				}
+				return *wood, nil
+			},
+		},
+		"colours": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlColourType)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindColours(p.Context))
+			},
+		},
+		"colour": &graphql.Field{
+			Type: graphqlColourType,
+			Args: graphqlIDArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				colour, err := FindColourFromID(p.Context, int64(p.Args["id"].(int)))
+				if err != nil {
+					return graphqlResult(nil, err)
+				}
+				return *colour, nil
+			},
+		},
+		"gallerySamples": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlGallerySampleType)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindGallerySamples(p.Context))
+			},
+		},
+		"dealers": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlDealerType)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindDealers(p.Context))
+			},
+		},
+		"imageTypes": &graphql.Field{
+			Type: graphql.NewList(graphql.NewNonNull(graphqlImageTypeType)),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlResult(FindImageTypes(p.Context))
+			},
+		},
+	},
+})
+
+// Colours, wood and door style types can be edited here. Images, like the
+// colour swatches, are uploaded and set through the REST API.
+var graphqlMutationType = graphql.NewObject(graphql.ObjectConfig{
+	Name:   "Mutation",
+	Fields: graphql.Fields{},
+})
+
+func init() {
+	addNamedEntityMutations("Colour", graphqlColourType, colourBulkResource, colourPatchResource,
+		graphql.FieldConfigArgument{
+			"hex":    &graphql.ArgumentConfig{Type: graphql.String},
+			"family": &graphql.ArgumentConfig{Type: graphql.String},
+			"finish": &graphql.ArgumentConfig{Type: graphql.String},
+		})
+	addNamedEntityMutations("Wood", graphqlWoodType, woodBulkResource, woodPatchResource,
+		graphql.FieldConfigArgument{
+			"jankaHardness":       &graphql.ArgumentConfig{Type: graphql.Int},
+			"grainPattern":        &graphql.ArgumentConfig{Type: graphql.String},
+			"paintable":           &graphql.ArgumentConfig{Type: graphql.Boolean},
+			"stainable":           &graphql.ArgumentConfig{Type: graphql.Boolean},
+			"priceTier":           &graphql.ArgumentConfig{Type: graphql.String},
+			"sustainabilityNotes": &graphql.ArgumentConfig{Type: graphql.String},
+		})
+	addNamedEntityMutations("DoorStyleType", graphqlDoorStyleTypeType,
+		namedEntityBulkResource("door-style-type", "door_style_types"),
+		namedEntityPatchResource("door-style-type", "door_style_types"),
+		graphql.FieldConfigArgument{})
+}
+
+// addNamedEntityMutations adds create, update and delete mutations that run
+// through the entity's bulk resource, so they're validated, keep S3 in step
+// and emit events like the REST endpoints. attributes are the arguments
+// besides the name. Updates are merge patches, arguments left out keep their
+// values.
+func addNamedEntityMutations(typeName string, object *graphql.Object, resource BulkResource,
+	patchResource PatchResource, attributes graphql.FieldConfigArgument) {
+	createArgs := graphql.FieldConfigArgument{
+		"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+	}
+	updateArgs := graphql.FieldConfigArgument{
+		"id":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+		"name": &graphql.ArgumentConfig{Type: graphql.String},
+	}
+	for name, arg := range attributes {
+		createArgs[name] = arg
+		updateArgs[name] = arg
+	}
+
+	graphqlMutationType.AddFieldConfig("create"+typeName, &graphql.Field{
+		Type: object,
+		Args: createArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			data, err := json.Marshal(p.Args)
+			if err != nil {
+				return nil, err
+			}
+			change, err := RunSingleOperation(p.Context, resource, BulkOperation{Op: "create", Data: data})
+			if err != nil {
+				return graphqlResult(nil, err)
+			}
+			return graphqlNamedEntity(change.Data)
+		},
+	})
+
+	graphqlMutationType.AddFieldConfig("update"+typeName, &graphql.Field{
+		Type: object,
+		Args: updateArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			patch := map[string]interface{}{}
+			for name, value := range p.Args {
+				if name != "id" {
+					patch[name] = value
+				}
+			}
+			change, err := ApplyPatch(p.Context, patchResource, int64(p.Args["id"].(int)), patch)
+			if err != nil {
+				return graphqlResult(nil, err)
+			}
+			return graphqlNamedEntity(change.Data)
+		},
+	})
+
+	graphqlMutationType.AddFieldConfig("delete"+typeName, &graphql.Field{
+		Type: graphql.NewNonNull(graphql.Boolean),
+		Args: graphqlIDArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			_, err := RunSingleOperation(p.Context, resource,
+				BulkOperation{Op: "delete", ID: int64(p.Args["id"].(int))})
+			if errors.Is(err, sql.ErrNoRows) {
+				return false, nil
+			}
+			if err != nil {
+				return graphqlResult(nil, err)
+			}
+			return true, nil
+		},
+	})
+}
+
+// graphqlNamedEntity reduces what a bulk resource saved to its id and name,
+// the other fields are resolved from the cache
+func graphqlNamedEntity(data interface{}) (interface{}, error) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	item := namedEntity{}
+	return item, json.Unmarshal(dataJSON, &item)
+}
+
+// graphqlName resolves the name of a catalog object in the request's
+// locale. The named entity mutations return a namedEntity rather than the
+// entity's own type.
+func graphqlName(entity string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		var id int64
+		var name string
+		switch source := p.Source.(type) {
+		case Colour:
+			id, name = source.ID, source.Name
+		case Wood:
+			id, name = source.ID, source.Name
+		case DoorStyleType:
+			id, name = source.ID, source.Name
+		case DoorStyle:
+			id, name = source.ID, source.Name
+		case namedEntity:
+			id, name = source.ID, source.Name
+		}
+		return translatorFrom(p.Context).text(entity, id, "name", name), nil
+	}
+}
+
+// graphqlDoorStyleField resolves a door style attribute from the cached
+// door style, like graphqlColourField
+func graphqlDoorStyleField(field func(doorStyle *DoorStyle) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		var id int64
+		switch source := p.Source.(type) {
+		case DoorStyle:
+			id = source.ID
+		case namedEntity:
+			id = source.ID
+		}
+		doorStyle, err := FindDoorStyleFromID(p.Context, id)
+		if err != nil {
+			return graphqlResult(nil, err)
+		}
+		return field(doorStyle), nil
+	}
+}
+
+// graphqlWoodField resolves a wood attribute from the cached wood, like
+// graphqlColourField
+func graphqlWoodField(field func(wood *Wood) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		var id int64
+		switch source := p.Source.(type) {
+		case Wood:
+			id = source.ID
+		case namedEntity:
+			id = source.ID
+		}
+		wood, err := FindWoodFromID(p.Context, id)
+		if err != nil {
+			return graphqlResult(nil, err)
+		}
+		return field(wood), nil
+	}
+}
+
+// graphqlColourField resolves a colour attribute from the cached colour,
+// since door samples and the mutations only give its id and name
+func graphqlColourField(field func(colour *Colour) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		var id int64
+		switch source := p.Source.(type) {
+		case Colour:
+			id = source.ID
+		case namedEntity:
+			id = source.ID
+		}
+		colour, err := FindColourFromID(p.Context, id)
+		if err != nil {
+			return graphqlResult(nil, err)
+		}
+		return field(colour), nil
+	}
+}
+
+// graphqlResult turns a store result into a resolver result. Missing rows
+// are null rather than an error, and database errors get the same messages
+// the REST API gives.
+func graphqlResult(value interface{}, err error) (interface{}, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err == nil {
+		return value, nil
+	}
+
+	result := bulkErrorResult(err)
+	for _, message := range result.ValidationErrors {
+		return nil, errors.New(fmt.Sprint(message))
+	}
+	if len(result.Error) > 0 {
+		return nil, errors.New(result.Error)
+	}
+	return nil, errors.New("Unknown Error")
+}
+
+func intListArg(args map[string]interface{}, name string) []int {
+	values, _ := args[name].([]interface{})
+	ints := []int{}
+	for _, value := range values {
+		if i, ok := value.(int); ok {
+			ints = append(ints, i)
+		}
+	}
+	return ints
+}
+
+// stringListArg reads a list argument as lower case values, nil when empty
+func stringListArg(args map[string]interface{}, name string) []string {
+	values, _ := args[name].([]interface{})
+	strs := []string{}
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			strs = append(strs, strings.ToLower(strings.TrimSpace(s)))
+		}
+	}
+	if len(strs) == 0 {
+		return nil
+	}
+	return strs
+}
+
+// batchLoader collects the ids requested by sibling fields and loads them
+// with one query when the first of their thunks runs. graphql-go resolves
+// thunks breadth first, so a whole list level is queued by then.
+type batchLoader struct {
+	mu      sync.Mutex
+	fetch   func(ctx stdContext.Context, ids []int64) (map[int64]interface{}, error)
+	pending []int64
+	queued  map[int64]bool
+	results map[int64]interface{}
+	errs    map[int64]error
+}
+
+func newBatchLoader(fetch func(ctx stdContext.Context, ids []int64) (map[int64]interface{}, error)) *batchLoader {
+	return &batchLoader{
+		fetch:   fetch,
+		queued:  map[int64]bool{},
+		results: map[int64]interface{}{},
+		errs:    map[int64]error{},
+	}
+}
+
+func (l *batchLoader) Load(ctx stdContext.Context, id int64) func() (interface{}, error) {
+	l.mu.Lock()
+	if !l.queued[id] {
+		l.queued[id] = true
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.pending) > 0 {
+			ids := l.pending
+			l.pending = nil
+			values, err := l.fetch(ctx, ids)
+			for _, pendingID := range ids {
+				if err != nil {
+					l.errs[pendingID] = err
+				} else {
+					l.results[pendingID] = values[pendingID]
+				}
+			}
+		}
+
+		if err := l.errs[id]; err != nil {
+			return graphqlResult(nil, err)
+		}
+		return l.results[id], nil
+	}
+}
+
+type graphqlLoaders struct {
+	doorStyleTypes *batchLoader
+	imageTypes     *batchLoader
+}
+
+type graphqlLoadersKey struct{}
+
+func withGraphQLLoaders(ctx stdContext.Context) stdContext.Context {
+	return stdContext.WithValue(ctx, graphqlLoadersKey{}, &graphqlLoaders{
+		doorStyleTypes: newBatchLoader(fetchDoorStyleTypesByDoorStyle),
+		imageTypes:     newBatchLoader(fetchImageTypesByImage),
+	})
+}
+
+func loadersFrom(ctx stdContext.Context) *graphqlLoaders {
+	return ctx.Value(graphqlLoadersKey{}).(*graphqlLoaders)
+}
+
+func fetchDoorStyleTypesByDoorStyle(ctx stdContext.Context, ids []int64) (map[int64]interface{}, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT door_style_door_style_types.door_style_id, door_style_types.id, door_style_types.name
+		FROM door_style_door_style_types
+		INNER JOIN door_style_types ON door_style_door_style_types.door_style_type_id = door_style_types.id
+		WHERE door_style_door_style_types.door_style_id = ANY($1)
+		ORDER BY door_style_types.name ASC`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	doorStyleTypes := map[int64][]DoorStyleType{}
+	for _, id := range ids {
+		doorStyleTypes[id] = []DoorStyleType{}
+	}
+	for rows.Next() {
+		var doorStyleID int64
+		doorStyleType := DoorStyleType{}
+		err = rows.Scan(&doorStyleID, &doorStyleType.ID, &doorStyleType.Name)
+		if err != nil {
+			return nil, err
+		}
+		doorStyleTypes[doorStyleID] = append(doorStyleTypes[doorStyleID], doorStyleType)
+	}
+
+	values := map[int64]interface{}{}
+	for id, types := range doorStyleTypes {
+		values[id] = types
+	}
+	return values, rows.Err()
+}
+
+func fetchImageTypesByImage(ctx stdContext.Context, ids []int64) (map[int64]interface{}, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT images.id, image_types.id, image_types.name, image_types.is_specific_dimension,
+			image_types.width, image_types.height
+		FROM images
+		INNER JOIN image_types ON images.image_type_id = image_types.id
+		WHERE images.id = ANY($1)`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[int64]interface{}{}
+	for rows.Next() {
+		var imageID int64
+		imageType := ImageType{}
+		err = rows.Scan(&imageID, &imageType.ID, &imageType.Name,
+			&imageType.IsSpecificDimension, &imageType.Width, &imageType.Height)
+		if err != nil {
+			return nil, err
+		}
+		values[imageID] = imageType
+	}
+	return values, rows.Err()
+}
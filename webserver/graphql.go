@@ -0,0 +1,243 @@
+package muskoka
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/kataras/iris/core/router"
+)
+
+// Fields under a list cost this many times more, as they're resolved for
+// every item
+const graphqlListCostFactor = 10
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func CreateGraphQLAPI(party router.Party) {
+	party.Get("", graphqlHandler)
+	party.Post("", graphqlHandler)
+}
+
+func graphqlHandler(ctx context.Context) {
+	request := GraphQLRequest{}
+	if ctx.Method() == http.MethodGet {
+		request.Query = ctx.URLParam("query")
+		request.OperationName = ctx.URLParam("operationName")
+		if variables := ctx.URLParam("variables"); len(variables) > 0 {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				writeGraphQLError(ctx, iris.StatusBadRequest, "Unable to read variables")
+				return
+			}
+		}
+	} else if err := ctx.ReadJSON(&request); err != nil {
+		writeGraphQLError(ctx, iris.StatusBadRequest, "Unable to read GraphQL request")
+		return
+	}
+
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"errors": gqlerrors.FormatErrors(err)})
+		return
+	}
+
+	schema := GetGraphQLSchema()
+	validation := graphql.ValidateDocument(&schema, document, nil)
+	if !validation.IsValid {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"errors": validation.Errors})
+		return
+	}
+
+	operation := selectedOperation(document, request.OperationName)
+	if operation == nil {
+		writeGraphQLError(ctx, iris.StatusBadRequest, "Unknown operation")
+		return
+	}
+
+	if operation.Operation == ast.OperationTypeMutation {
+		// GET requests can be replayed by anything that can make a link
+		if ctx.Method() != http.MethodPost {
+			writeGraphQLError(ctx, iris.StatusMethodNotAllowed, "Mutations must use POST")
+			return
+		}
+		if len(UsernameFromRequest(ctx.Request())) == 0 {
+			writeGraphQLError(ctx, iris.StatusUnauthorized, "Login required")
+			return
+		}
+		if !IsAdminRequest(ctx.Request()) {
+			writeGraphQLError(ctx, iris.StatusForbidden, "Admin required")
+			return
+		}
+	}
+
+	config := GetConfig()
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+	rootType := schema.QueryType()
+	if operation.Operation == ast.OperationTypeMutation {
+		rootType = schema.MutationType()
+	}
+	cost := operationCost(operation.SelectionSet, rootType, fragments)
+	if cost.Depth > config.GraphQLMaxDepth {
+		writeGraphQLError(ctx, iris.StatusBadRequest,
+			fmt.Sprintf("Query depth %d is more than the limit of %d", cost.Depth, config.GraphQLMaxDepth))
+		return
+	}
+	if cost.Complexity > config.GraphQLMaxComplexity {
+		writeGraphQLError(ctx, iris.StatusBadRequest,
+			fmt.Sprintf("Query complexity %d is more than the limit of %d", cost.Complexity, config.GraphQLMaxComplexity))
+		return
+	}
+	if cost.IntrospectionDepth > config.GraphQLMaxIntrospectionDepth {
+		writeGraphQLError(ctx, iris.StatusBadRequest,
+			fmt.Sprintf("Introspection depth %d is more than the limit of %d",
+				cost.IntrospectionDepth, config.GraphQLMaxIntrospectionDepth))
+		return
+	}
+	if cost.IntrospectionComplexity > config.GraphQLMaxIntrospectionComplexity {
+		writeGraphQLError(ctx, iris.StatusBadRequest,
+			fmt.Sprintf("Introspection complexity %d is more than the limit of %d",
+				cost.IntrospectionComplexity, config.GraphQLMaxIntrospectionComplexity))
+		return
+	}
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context: withTranslator(withGraphQLLoaders(ctx.Request().Context()),
+			newTranslator(ctx.Request().Context(), RequestLocale(ctx))),
+	})
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(result)
+}
+
+func writeGraphQLError(ctx context.Context, statusCode int, message string) {
+	ctx.StatusCode(statusCode)
+	ctx.JSON(map[string]interface{}{
+		"errors": []gqlerrors.FormattedError{gqlerrors.NewFormattedError(message)},
+	})
+}
+
+func selectedOperation(document *ast.Document, operationName string) *ast.OperationDefinition {
+	var selected *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if len(operationName) == 0 {
+			// Without a name there must be exactly one operation
+			if selected != nil {
+				return nil
+			}
+			selected = operation
+		} else if operation.Name != nil && operation.Name.Value == operationName {
+			return operation
+		}
+	}
+	return selected
+}
+
+// graphqlCost is the depth and complexity of an operation. The schema
+// introspection fields __schema and __type are counted apart, against their
+// own limits, as loading the whole schema is deeper than any catalog query.
+type graphqlCost struct {
+	Depth                   int
+	Complexity              int
+	IntrospectionDepth      int
+	IntrospectionComplexity int
+}
+
+// operationCost runs after validation so fragments can't be cyclic
+func operationCost(selectionSet *ast.SelectionSet, root *graphql.Object, fragments map[string]*ast.FragmentDefinition) graphqlCost {
+	cost := graphqlCost{}
+	cost.Complexity = selectionCost(selectionSet, root, fragments, 0, false, &cost)
+	return cost
+}
+
+// selectionCost returns the complexity of a selection set, where every
+// field costs one plus the cost of its own selections, and records the
+// depths reached in cost
+func selectionCost(selectionSet *ast.SelectionSet, parent *graphql.Object, fragments map[string]*ast.FragmentDefinition,
+	depth int, introspection bool, cost *graphqlCost) int {
+	if selectionSet == nil {
+		return 0
+	}
+
+	complexity := 0
+	for _, selection := range selectionSet.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			name := selection.Name.Value
+			if !introspection && (name == "__schema" || name == "__type") {
+				cost.IntrospectionDepth = max(cost.IntrospectionDepth, depth+1)
+				cost.IntrospectionComplexity += 1 + selectionCost(selection.SelectionSet, nil, fragments, depth+1, true, cost)
+				continue
+			}
+
+			if introspection {
+				cost.IntrospectionDepth = max(cost.IntrospectionDepth, depth+1)
+			} else {
+				cost.Depth = max(cost.Depth, depth+1)
+			}
+
+			var child *graphql.Object
+			isList := false
+			if parent != nil {
+				if field, ok := parent.Fields()[name]; ok {
+					child, isList = unwrapGraphQLType(field.Type)
+				}
+			}
+
+			childCost := selectionCost(selection.SelectionSet, child, fragments, depth+1, introspection, cost)
+			if isList {
+				childCost *= graphqlListCostFactor
+			}
+			complexity += 1 + childCost
+		case *ast.InlineFragment:
+			complexity += selectionCost(selection.SelectionSet, parent, fragments, depth, introspection, cost)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[selection.Name.Value]; ok {
+				complexity += selectionCost(fragment.SelectionSet, parent, fragments, depth, introspection, cost)
+			}
+		}
+	}
+
+	return complexity
+}
+
+func unwrapGraphQLType(t graphql.Output) (*graphql.Object, bool) {
+	isList := false
+	for {
+		switch wrapped := t.(type) {
+		case *graphql.NonNull:
+			t = wrapped.OfType
+		case *graphql.List:
+			isList = true
+			t = wrapped.OfType
+		case *graphql.Object:
+			return wrapped, isList
+		default:
+			return nil, isList
+		}
+	}
+}
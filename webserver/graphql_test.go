@@ -0,0 +1,149 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"encoding/json"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/testutil"
+)
+
+func TestOperationCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  graphqlCost
+	}{
+		{
+			"scalars",
+			`{ colour(id: 1) { id name } }`,
+			graphqlCost{Depth: 2, Complexity: 3},
+		},
+		{
+			"list",
+			`{ colours { id name } }`,
+			graphqlCost{Depth: 2, Complexity: 1 + 2*graphqlListCostFactor},
+		},
+		{
+			"fragment",
+			`{ colours { ...names } } fragment names on Colour { id name }`,
+			graphqlCost{Depth: 2, Complexity: 1 + 2*graphqlListCostFactor},
+		},
+		{
+			"typename counts like any field",
+			`{ colour(id: 1) { __typename id } }`,
+			graphqlCost{Depth: 2, Complexity: 3},
+		},
+		{
+			"introspection counted apart",
+			`{ colour(id: 1) { id } __schema { types { name fields { name } } } }`,
+			graphqlCost{Depth: 2, Complexity: 2, IntrospectionDepth: 4, IntrospectionComplexity: 5},
+		},
+		{
+			"type introspection",
+			`{ __type(name: "Colour") { name fields { name type { name ofType { name } } } } }`,
+			graphqlCost{IntrospectionDepth: 5, IntrospectionComplexity: 8},
+		},
+	}
+
+	schema := GetGraphQLSchema()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operation, fragments := parseGraphQLOperation(t, test.query)
+			got := operationCost(operation.SelectionSet, schema.QueryType(), fragments)
+			if got != test.want {
+				t.Errorf("operationCost() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// The default limits have to let through the query GraphQL tools load the
+// schema with
+func TestOperationCostIntrospectionQuery(t *testing.T) {
+	config := GetConfig()
+	schema := GetGraphQLSchema()
+	operation, fragments := parseGraphQLOperation(t, testutil.IntrospectionQuery)
+	cost := operationCost(operation.SelectionSet, schema.QueryType(), fragments)
+
+	if cost.Depth != 0 || cost.Complexity != 0 {
+		t.Errorf("catalog cost = %d deep and %d complex, want none", cost.Depth, cost.Complexity)
+	}
+	if cost.IntrospectionDepth > config.GraphQLMaxIntrospectionDepth {
+		t.Errorf("IntrospectionDepth = %d, more than the default limit of %d",
+			cost.IntrospectionDepth, config.GraphQLMaxIntrospectionDepth)
+	}
+	if cost.IntrospectionComplexity > config.GraphQLMaxIntrospectionComplexity {
+		t.Errorf("IntrospectionComplexity = %d, more than the default limit of %d",
+			cost.IntrospectionComplexity, config.GraphQLMaxIntrospectionComplexity)
+	}
+}
+
+func parseGraphQLOperation(t *testing.T, query string) (*ast.OperationDefinition, map[string]*ast.FragmentDefinition) {
+	t.Helper()
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	operation := selectedOperation(document, "")
+	if operation == nil {
+		t.Fatal("no operation")
+	}
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+	return operation, fragments
+}
+
+// cacheCatalogValue stores value as if entity's key had been read, so
+// resolvers run without a database
+func cacheCatalogValue(t *testing.T, entity string, key string, value interface{}) {
+	t.Helper()
+	cache := GetCatalogCache()
+	cache.set(entity+":"+key, value, cacheDependencies[entity], cache.currentGeneration())
+	t.Cleanup(cache.flush)
+}
+
+func TestGraphQLColour(t *testing.T) {
+	cacheCatalogValue(t, "colour", "1", &Colour{ID: 1, Name: "Harbour Grey", Hex: "#8a9597"})
+
+	result := graphql.Do(graphql.Params{
+		Schema:        GetGraphQLSchema(),
+		RequestString: `{ colour(id: 1) { id name hex } }`,
+		Context:       stdContext.Background(),
+	})
+	if len(result.Errors) > 0 {
+		t.Fatalf("errors = %v", result.Errors)
+	}
+
+	got, _ := json.Marshal(result.Data)
+	want := `{"colour":{"hex":"#8a9597","id":1,"name":"Harbour Grey"}}`
+	if string(got) != want {
+		t.Errorf("data = %s, want %s", got, want)
+	}
+}
+
+func TestGraphQLWood(t *testing.T) {
+	cacheCatalogValue(t, "wood", "2", &Wood{ID: 2, Name: "White Oak", JankaHardness: 1360, Stainable: true})
+
+	result := graphql.Do(graphql.Params{
+		Schema:        GetGraphQLSchema(),
+		RequestString: `{ wood(id: 2) { id name jankaHardness stainable } }`,
+		Context:       stdContext.Background(),
+	})
+	if len(result.Errors) > 0 {
+		t.Fatalf("errors = %v", result.Errors)
+	}
+
+	got, _ := json.Marshal(result.Data)
+	want := `{"wood":{"id":2,"jankaHardness":1360,"name":"White Oak","stainable":true}}`
+	if string(got) != want {
+		t.Errorf("data = %s, want %s", got, want)
+	}
+}
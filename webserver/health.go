@@ -0,0 +1,205 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/kataras/iris/core/router"
+	"github.com/lib/pq"
+)
+
+// readinessCheck is one dependency of /readyz. Only critical checks take the
+// instance out of rotation, the others report it degraded. Checks with a
+// cache call out at most once per MUSKOKA_READY_CHECK_CACHE_TTL rather than
+// on every probe.
+type readinessCheck struct {
+	Name     string
+	Check    func(ctx stdContext.Context) error
+	Critical bool
+	cache    *cachedCheckResult
+}
+
+type CheckResult struct {
+	Status   string `json:"status"`
+	Latency  string `json:"latency"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+}
+
+type cachedCheckResult struct {
+	mu      sync.Mutex
+	result  CheckResult
+	expires time.Time
+}
+
+// Uploads and email going down leaves the catalog readable, so S3 and SES
+// don't fail readiness
+var readinessChecks = []readinessCheck{
+	{Name: "database", Check: checkDatabase, Critical: true},
+	{Name: "migrations", Check: checkMigrations, Critical: true},
+	{Name: "storage", Check: checkStorage, cache: &cachedCheckResult{}},
+	{Name: "email", Check: checkEmail, cache: &cachedCheckResult{}},
+}
+
+var shuttingDown atomic.Bool
+
+// SetShuttingDown makes /readyz fail so load balancers stop sending traffic
+// while in-flight requests drain.
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+func CreateHealthAPI(party router.Party) {
+	party.Get("/healthz", healthzHandler)
+	party.Get("/readyz", readyzHandler)
+}
+
+func healthzHandler(ctx context.Context) {
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{"status": "ok"})
+}
+
+// readyzHandler only reports the overall status to anyone but admins, the
+// checks and their errors describe the infrastructure
+func readyzHandler(ctx context.Context) {
+	if shuttingDown.Load() {
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+		ctx.JSON(map[string]interface{}{"status": "shutting down"})
+		return
+	}
+
+	results := RunReadinessChecks(ctx.Request().Context())
+
+	status := "ok"
+	statusCode := iris.StatusOK
+	for _, result := range results {
+		switch {
+		case result.Status == "ok":
+		case result.Critical:
+			status = "fail"
+			statusCode = iris.StatusServiceUnavailable
+		case status == "ok":
+			status = "degraded"
+		}
+	}
+
+	ctx.StatusCode(statusCode)
+	if !IsAdminRequest(ctx.Request()) {
+		ctx.JSON(map[string]interface{}{"status": status})
+		return
+	}
+	ctx.JSON(map[string]interface{}{
+		"status": status,
+		"checks": results,
+	})
+}
+
+// RunReadinessChecks runs every /readyz check at once, each with its own
+// timeout
+func RunReadinessChecks(ctx stdContext.Context) map[string]CheckResult {
+	timeout := GetConfig().ReadyCheckTimeout
+	results := map[string]CheckResult{}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(readinessChecks))
+
+	for _, check := range readinessChecks {
+		go func(check readinessCheck) {
+			defer wg.Done()
+
+			result := check.run(ctx, timeout)
+
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func (check readinessCheck) run(ctx stdContext.Context, timeout time.Duration) CheckResult {
+	if check.cache == nil {
+		return check.runUncached(ctx, timeout)
+	}
+
+	// Concurrent probes wait for the one calling out rather than all calling
+	check.cache.mu.Lock()
+	defer check.cache.mu.Unlock()
+	if time.Now().Before(check.cache.expires) {
+		return check.cache.result
+	}
+	// A probe hanging up early shouldn't cache a failure
+	check.cache.result = check.runUncached(stdContext.WithoutCancel(ctx), timeout)
+	check.cache.expires = time.Now().Add(GetConfig().ReadyCheckCacheTTL)
+	return check.cache.result
+}
+
+func (check readinessCheck) runUncached(ctx stdContext.Context, timeout time.Duration) CheckResult {
+	checkCtx, cancel := stdContext.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(checkCtx)
+	result := CheckResult{Status: "ok", Latency: time.Since(start).String(), Critical: check.Critical}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func checkDatabase(ctx stdContext.Context) error {
+	return GetDBConnection().PingContext(ctx)
+}
+
+func checkMigrations(ctx stdContext.Context) error {
+	if !schemaReady.Load() {
+		return errors.New("schema has not been initialized")
+	}
+	return checkSchemaTables(ctx)
+}
+
+func checkSchemaTables(ctx stdContext.Context) error {
+	var missing int
+	err := GetDBConnection().QueryRowContext(ctx, `
+		SELECT count(*)
+		FROM unnest($1::text[]) AS t(name)
+		WHERE to_regclass(t.name) IS NULL`,
+		pq.Array(schemaTables)).Scan(&missing)
+	if err != nil {
+		return err
+	}
+	if missing > 0 {
+		return errors.New("schema is missing tables")
+	}
+	return nil
+}
+
+func checkStorage(ctx stdContext.Context) error {
+	if s3Service == nil {
+		return errors.New("s3 is not initialized")
+	}
+	_, err := s3Service.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(assetsBucket),
+	})
+	return err
+}
+
+func checkEmail(ctx stdContext.Context) error {
+	if sesService == nil {
+		return errors.New("ses is not initialized")
+	}
+	_, err := sesService.GetSendQuotaWithContext(ctx, &ses.GetSendQuotaInput{})
+	return err
+}
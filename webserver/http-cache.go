@@ -0,0 +1,207 @@
+package muskoka
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/lib/pq"
+)
+
+// catalogTables lists the tables each public response is built from, their
+// newest change is the response's Last-Modified. That includes the tables
+// ?include= can add and the translations.
+var catalogTables = map[string][]string{
+	"colour":          {"colours", "colour_translations", "images"},
+	"wood":            {"wood", "wood_translations", "images", "image_types"},
+	"door-style-type": {"door_style_types", "door_style_type_translations"},
+	"door-style":      {"door_styles", "door_style_translations", "door_style_door_style_types", "door_style_types", "door_style_type_translations", "door_style_compatibility", "wood", "wood_translations", "colours", "colour_translations", "images", "image_types"},
+	"door-sample":     {"door_samples", "door_styles", "door_style_translations", "wood", "wood_translations", "colours", "colour_translations", "images", "door_style_door_style_types", "door_style_types", "door_style_type_translations", "image_types"},
+	"gallery-sample":  {"gallery_samples", "images", "image_types"},
+	"dealer":          {"dealers", "dealer_translations", "images", "image_types"},
+	"image-type":      {"image_types"},
+}
+
+func InitCatalogVersions() {
+	createCatalogVersionsTable()
+	createCatalogVersionsTriggers()
+}
+
+// catalog_versions has a row per table holding the time it last changed.
+// Statement level triggers keep it current, including for deletes which a
+// per row updated_at column can't show.
+func createCatalogVersionsTable() {
+	_, err := GetDBConnection().Exec(`
+		CREATE TABLE IF NOT EXISTS catalog_versions (
+			name text PRIMARY KEY,
+			updated_at timestamptz NOT NULL DEFAULT now()
+		);`)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = GetDBConnection().Exec(`
+		CREATE OR REPLACE FUNCTION touch_catalog_version() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO catalog_versions (name, updated_at)
+			VALUES (TG_TABLE_NAME, now())
+			ON CONFLICT (name) DO UPDATE SET updated_at = EXCLUDED.updated_at;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func createCatalogVersionsTriggers() {
+	tables := map[string]bool{}
+	for _, entityTables := range catalogTables {
+		for _, table := range entityTables {
+			tables[table] = true
+		}
+	}
+
+	for table := range tables {
+		_, err := GetDBConnection().Exec(`
+			INSERT INTO catalog_versions (name) VALUES ($1)
+			ON CONFLICT (name) DO NOTHING`, table)
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = GetDBConnection().Exec(`
+			DROP TRIGGER IF EXISTS ` + table + `__catalog_version ON ` + table + `;
+			CREATE TRIGGER ` + table + `__catalog_version
+			AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON ` + table + `
+			FOR EACH STATEMENT EXECUTE PROCEDURE touch_catalog_version();`)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// CachePolicy decides how a public GET may be cached by browsers and CDNs
+type CachePolicy struct {
+	CacheControl  string
+	SurrogateKeys []string
+	Tables        []string
+}
+
+const cachePolicyKey = "cachePolicy"
+
+// CatalogCachePolicy is the default policy for the public catalog routes of
+// entity, tagged so a CDN can purge everything built from it
+func CatalogCachePolicy(entity string) CachePolicy {
+	return CachePolicy{
+		CacheControl:  GetConfig().CatalogCacheControl,
+		SurrogateKeys: []string{entity},
+		Tables:        catalogTables[entity],
+	}
+}
+
+// CacheMiddleware sets the policy that ServeCacheable applies to the route
+func CacheMiddleware(policy CachePolicy) context.Handler {
+	return func(ctx context.Context) {
+		ctx.Values().Set(cachePolicyKey, policy)
+		ctx.Next()
+	}
+}
+
+// ServeCacheable writes v as JSON with a strong ETag and a Last-Modified from
+// the route's tables, answering 304 when the client's copy is still current.
+// keys are added to the route's Surrogate-Key, e.g. "colour/12" for one item.
+func ServeCacheable(ctx context.Context, v interface{}, keys ...string) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{"error": "Unable to encode response"})
+		return
+	}
+
+	policy, _ := ctx.Values().Get(cachePolicyKey).(CachePolicy)
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	ctx.Header("ETag", etag)
+
+	cacheControl := policy.CacheControl
+	if len(cacheControl) == 0 {
+		cacheControl = "no-cache"
+	}
+	ctx.Header("Cache-Control", cacheControl)
+
+	surrogateKeys := append(append([]string{}, policy.SurrogateKeys...), keys...)
+	if len(surrogateKeys) > 0 {
+		ctx.Header("Surrogate-Key", strings.Join(surrogateKeys, " "))
+	}
+
+	var lastModified time.Time
+	if len(policy.Tables) > 0 {
+		lastModified, err = tablesLastModified(ctx, policy.Tables)
+		if err != nil {
+			// The ETag is enough to revalidate without it
+			LoggerFrom(ctx.Request().Context()).Warn("unable to read catalog versions", "error", err)
+		}
+	}
+	if !lastModified.IsZero() {
+		ctx.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if isNotModified(ctx.Request(), etag, lastModified) {
+		ctx.StatusCode(iris.StatusNotModified)
+		return
+	}
+
+	ctx.ContentType("application/json; charset=UTF-8")
+	ctx.StatusCode(iris.StatusOK)
+	ctx.Write(body)
+}
+
+func tablesLastModified(ctx context.Context, tables []string) (time.Time, error) {
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+
+	var updatedAt pq.NullTime
+	err := GetDBConnection().QueryRowContext(queryCtx, `
+		SELECT max(updated_at)
+		FROM catalog_versions
+		WHERE name = ANY($1)`,
+		pq.Array(tables)).Scan(&updatedAt)
+	if err != nil || !updatedAt.Valid {
+		return time.Time{}, err
+	}
+	// HTTP dates have no fractional seconds
+	return updatedAt.Time.Truncate(time.Second), nil
+}
+
+// isNotModified follows RFC 7232, If-None-Match wins over If-Modified-Since
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); len(ifNoneMatch) > 0 {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ifModifiedSince)
+}
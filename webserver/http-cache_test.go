@@ -0,0 +1,52 @@
+package muskoka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsNotModified(t *testing.T) {
+	etag := `"abc123"`
+	lastModified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	before := lastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := lastModified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		lastModified time.Time
+		want         bool
+	}{
+		{"no conditions", http.MethodGet, nil, lastModified, false},
+		{"matching etag", http.MethodGet, map[string]string{"If-None-Match": etag}, lastModified, true},
+		{"weak etag", http.MethodGet, map[string]string{"If-None-Match": `W/"abc123"`}, lastModified, true},
+		{"etag in a list", http.MethodGet, map[string]string{"If-None-Match": `"old", "abc123"`}, lastModified, true},
+		{"any etag", http.MethodGet, map[string]string{"If-None-Match": "*"}, lastModified, true},
+		{"other etag", http.MethodGet, map[string]string{"If-None-Match": `"old"`}, lastModified, false},
+		{"head", http.MethodHead, map[string]string{"If-None-Match": etag}, lastModified, true},
+		{"post", http.MethodPost, map[string]string{"If-None-Match": etag}, lastModified, false},
+		{"modified since", http.MethodGet, map[string]string{"If-Modified-Since": before}, lastModified, false},
+		{"not modified since", http.MethodGet, map[string]string{"If-Modified-Since": after}, lastModified, true},
+		{"same second", http.MethodGet,
+			map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, lastModified, true},
+		{"bad date", http.MethodGet, map[string]string{"If-Modified-Since": "yesterday"}, lastModified, false},
+		{"unknown last modified", http.MethodGet, map[string]string{"If-Modified-Since": after}, time.Time{}, false},
+		{"etag wins over date", http.MethodGet,
+			map[string]string{"If-None-Match": `"old"`, "If-Modified-Since": after}, lastModified, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/colour", nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			if got := isNotModified(r, etag, test.lastModified); got != test.want {
+				t.Errorf("isNotModified = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
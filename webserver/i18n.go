@@ -0,0 +1,673 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/kataras/iris/core/router"
+)
+
+// localeParam stands for the request's locale in localized SQL until
+// bindLocale numbers it
+const localeParam = "$locale"
+
+// translatableEntity is a catalog entity whose text can be translated. The
+// default locale stays in Table, each other locale is a row of
+// Translations with the same Fields.
+type translatableEntity struct {
+	Table        string
+	Translations string
+	Key          string
+	Fields       []string
+}
+
+var translatableEntities = map[string]translatableEntity{
+	"colour":          {Table: "colours", Translations: "colour_translations", Key: "colour_id", Fields: []string{"name"}},
+	"wood":            {Table: "wood", Translations: "wood_translations", Key: "wood_id", Fields: []string{"name"}},
+	"door-style-type": {Table: "door_style_types", Translations: "door_style_type_translations", Key: "door_style_type_id", Fields: []string{"name"}},
+	"door-style":      {Table: "door_styles", Translations: "door_style_translations", Key: "door_style_id", Fields: []string{"name"}},
+	"dealer":          {Table: "dealers", Translations: "dealer_translations", Key: "dealer_id", Fields: []string{"location", "description"}},
+}
+
+func InitTranslations() {
+	for _, entity := range translatableEntities {
+		createTranslationTable(entity)
+		migrateTranslationTable(entity)
+		createTranslationIndices(entity)
+	}
+}
+
+func createTranslationTable(entity translatableEntity) {
+	columns := ""
+	for _, field := range entity.Fields {
+		columns += field + " text,\n"
+	}
+	_, err := GetDBConnection().Exec(`
+		CREATE TABLE IF NOT EXISTS ` + entity.Translations + ` (
+			` + entity.Key + ` integer references ` + entity.Table + ` ON DELETE CASCADE NOT NULL,
+			locale text NOT NULL,
+			` + columns + `
+			PRIMARY KEY (` + entity.Key + `, locale)
+		);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// migrateTranslationTable adds fields made translatable after the table
+// was created, e.g. the dealer description
+func migrateTranslationTable(entity translatableEntity) {
+	for _, field := range entity.Fields {
+		_, err := GetDBConnection().Exec(`ALTER TABLE ` + entity.Translations +
+			` ADD COLUMN IF NOT EXISTS ` + field + ` text;`)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Names are unique within a locale, like the lower(name) index on the
+// entity's own table is for the default locale
+func createTranslationIndices(entity translatableEntity) {
+	for _, field := range entity.Fields {
+		if field != "name" {
+			continue
+		}
+		_, err := GetDBConnection().Exec(`CREATE UNIQUE INDEX IF NOT EXISTS ` + entity.Translations +
+			`__name__key ON ` + entity.Translations + ` (locale, lower(name));`)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RequestLocale picks a supported locale from ?lang=, then Accept-Language,
+// then the default. It marks the response as varying by language.
+func RequestLocale(ctx context.Context) string {
+	ctx.ResponseWriter().Header().Add("Vary", "Accept-Language")
+	locale := negotiateLocale(ctx.URLParam("lang"), ctx.GetHeader("Accept-Language"))
+	ctx.Header("Content-Language", locale)
+	return locale
+}
+
+func negotiateLocale(lang string, acceptLanguage string) string {
+	config := GetConfig()
+	if locale, ok := supportedLocale(lang); ok {
+		return locale
+	}
+
+	best := config.DefaultLocale
+	bestQuality := 0.0
+	for _, accepted := range strings.Split(acceptLanguage, ",") {
+		parts := strings.Split(accepted, ";")
+		locale, ok := supportedLocale(parts[0])
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = locale, quality
+		}
+	}
+	return best
+}
+
+// supportedLocale matches a language tag to a locale, fr-CA to fr
+func supportedLocale(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if len(tag) == 0 {
+		return "", false
+	}
+	for _, locale := range GetConfig().Locales {
+		if tag == locale || strings.HasPrefix(tag, locale+"-") {
+			return locale, true
+		}
+	}
+	return "", false
+}
+
+// localizedSQL is field of the entity's row in the request's locale, falling
+// back to the default. The query needs bindLocale.
+func localizedSQL(entity string, field string) string {
+	t := translatableEntities[entity]
+	return `COALESCE((
+		SELECT ` + t.Translations + `.` + field + `
+		FROM ` + t.Translations + `
+		WHERE ` + t.Translations + `.` + t.Key + ` = ` + t.Table + `.id AND ` + t.Translations + `.locale = ` + localeParam + `
+	), ` + t.Table + `.` + field + `)`
+}
+
+// bindLocale numbers localeParam as the argument after args
+func bindLocale(sql string, args []interface{}, locale string) (string, []interface{}) {
+	return bindNamedParam(sql, args, localeParam, locale)
+}
+
+// translatedSearchSQL matches field of the entity's row in any locale
+// against a LIKE pattern
+func translatedSearchSQL(entity string, field string, pattern string) string {
+	t := translatableEntities[entity]
+	return fmt.Sprintf(`(LOWER(%[1]s.%[3]s) LIKE %[5]s OR EXISTS (
+		SELECT 1 FROM %[2]s
+		WHERE %[2]s.%[4]s = %[1]s.id AND LOWER(%[2]s.%[3]s) LIKE %[5]s
+	))`, t.Table, t.Translations, field, t.Key, pattern)
+}
+
+// translations is id to field to text for one locale
+type translations map[int64]map[string]string
+
+// loadTranslations returns every translation of entity by locale, cached
+// with the entity so its writes clear them
+func loadTranslations(ctx stdContext.Context, entity string) (map[string]translations, error) {
+	value, err := cachedRead(entity, "translations", func() (interface{}, error) {
+		t := translatableEntities[entity]
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		rows, err := GetDBConnection().QueryContext(queryCtx, `
+			SELECT `+t.Key+`, locale, `+strings.Join(t.Fields, ", ")+`
+			FROM `+t.Translations)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		byLocale := map[string]translations{}
+		for rows.Next() {
+			var id int64
+			var locale string
+			values := make([]sql.NullString, len(t.Fields))
+			dest := []interface{}{&id, &locale}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return nil, err
+			}
+
+			if byLocale[locale] == nil {
+				byLocale[locale] = translations{}
+			}
+			fields := map[string]string{}
+			for i, value := range values {
+				if value.Valid {
+					fields[t.Fields[i]] = value.String
+				}
+			}
+			byLocale[locale][id] = fields
+		}
+		return byLocale, rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]translations), nil
+}
+
+// translator swaps catalog text for the request's locale. It copies, the
+// values it's given may be shared through the cache.
+type translator struct {
+	ctx    stdContext.Context
+	locale string
+
+	mu     sync.Mutex
+	loaded map[string]translations
+}
+
+type translatorKey struct{}
+
+func newTranslator(ctx stdContext.Context, locale string) *translator {
+	return &translator{ctx: ctx, locale: locale, loaded: map[string]translations{}}
+}
+
+func withTranslator(ctx stdContext.Context, t *translator) stdContext.Context {
+	return stdContext.WithValue(ctx, translatorKey{}, t)
+}
+
+// translatorFrom returns the context's translator, or one for the default
+// locale
+func translatorFrom(ctx stdContext.Context) *translator {
+	if t, ok := ctx.Value(translatorKey{}).(*translator); ok {
+		return t
+	}
+	return newTranslator(ctx, GetConfig().DefaultLocale)
+}
+
+// text is the translation of field, or fallback if it has none. A failed
+// load is logged and falls back too, rather than failing the response.
+func (t *translator) text(entity string, id int64, field string, fallback string) string {
+	if t.locale == GetConfig().DefaultLocale {
+		return fallback
+	}
+
+	t.mu.Lock()
+	byID, ok := t.loaded[entity]
+	if !ok {
+		byLocale, err := loadTranslations(t.ctx, entity)
+		if err != nil {
+			LoggerFrom(t.ctx).Error("unable to load translations", "entity", entity, "error", err)
+		}
+		byID = byLocale[t.locale]
+		t.loaded[entity] = byID
+	}
+	t.mu.Unlock()
+
+	if value, ok := byID[id][field]; ok {
+		return value
+	}
+	return fallback
+}
+
+// localize returns a translated copy of the entities the REST API serves,
+// anything else as is
+func localize(ctx context.Context, v interface{}) interface{} {
+	t := newTranslator(ctx.Request().Context(), RequestLocale(ctx))
+	if t.locale == GetConfig().DefaultLocale {
+		return v
+	}
+
+	switch v := v.(type) {
+	case *Colour:
+		colour := t.colour(*v)
+		return &colour
+	case *[]Colour:
+		colours := make([]Colour, len(*v))
+		for i, colour := range *v {
+			colours[i] = t.colour(colour)
+		}
+		sort.SliceStable(colours, func(i, j int) bool {
+			return strings.ToLower(colours[i].Name) < strings.ToLower(colours[j].Name)
+		})
+		return &colours
+	case *Wood:
+		wood := t.wood(*v)
+		return &wood
+	case *[]Wood:
+		woods := make([]Wood, len(*v))
+		for i, wood := range *v {
+			woods[i] = t.wood(wood)
+		}
+		sort.SliceStable(woods, func(i, j int) bool {
+			return strings.ToLower(woods[i].Name) < strings.ToLower(woods[j].Name)
+		})
+		return &woods
+	case *DoorStyleType:
+		doorStyleType := t.doorStyleType(*v)
+		return &doorStyleType
+	case *[]DoorStyleType:
+		doorStyleTypes := t.doorStyleTypes(*v)
+		return &doorStyleTypes
+	case *DoorStyle:
+		doorStyle := t.doorStyle(*v)
+		return &doorStyle
+	case *[]DoorStyle:
+		doorStyles := make([]DoorStyle, len(*v))
+		for i, doorStyle := range *v {
+			doorStyles[i] = t.doorStyle(doorStyle)
+		}
+		sort.SliceStable(doorStyles, func(i, j int) bool {
+			return strings.ToLower(doorStyles[i].Name) < strings.ToLower(doorStyles[j].Name)
+		})
+		return &doorStyles
+	case *DoorSample:
+		doorSample := t.doorSample(*v)
+		return &doorSample
+	case *[]DoorSample:
+		doorSamples := make([]DoorSample, len(*v))
+		for i, doorSample := range *v {
+			doorSamples[i] = t.doorSample(doorSample)
+		}
+		return &doorSamples
+	case *Dealer:
+		dealer := t.dealer(*v)
+		return &dealer
+	case *[]Dealer:
+		dealers := make([]Dealer, len(*v))
+		for i, dealer := range *v {
+			dealers[i] = t.dealer(dealer)
+		}
+		return &dealers
+	}
+	return v
+}
+
+func (t *translator) colour(colour Colour) Colour {
+	colour.Name = t.text("colour", colour.ID, "name", colour.Name)
+	return colour
+}
+
+func (t *translator) wood(wood Wood) Wood {
+	wood.Name = t.text("wood", wood.ID, "name", wood.Name)
+	return wood
+}
+
+func (t *translator) doorStyleType(doorStyleType DoorStyleType) DoorStyleType {
+	doorStyleType.Name = t.text("door-style-type", doorStyleType.ID, "name", doorStyleType.Name)
+	return doorStyleType
+}
+
+func (t *translator) doorStyleTypes(doorStyleTypes []DoorStyleType) []DoorStyleType {
+	if doorStyleTypes == nil {
+		return nil
+	}
+	translated := make([]DoorStyleType, len(doorStyleTypes))
+	for i, doorStyleType := range doorStyleTypes {
+		translated[i] = t.doorStyleType(doorStyleType)
+	}
+	// Lists are sorted by name, which may have changed, and so are the
+	// top level lists in localize
+	sort.SliceStable(translated, func(i, j int) bool {
+		return strings.ToLower(translated[i].Name) < strings.ToLower(translated[j].Name)
+	})
+	return translated
+}
+
+func (t *translator) doorStyle(doorStyle DoorStyle) DoorStyle {
+	doorStyle.Name = t.text("door-style", doorStyle.ID, "name", doorStyle.Name)
+	doorStyle.DoorStyleTypes = t.doorStyleTypes(doorStyle.DoorStyleTypes)
+	if doorStyle.Compatibility != nil {
+		compatibility := make([]DoorStyleWoodOption, len(doorStyle.Compatibility))
+		for i, option := range doorStyle.Compatibility {
+			compatibility[i].Wood = t.wood(option.Wood)
+			compatibility[i].Colours = make([]Colour, len(option.Colours))
+			for j, colour := range option.Colours {
+				compatibility[i].Colours[j] = t.colour(colour)
+			}
+		}
+		doorStyle.Compatibility = compatibility
+	}
+	return doorStyle
+}
+
+func (t *translator) doorSample(doorSample DoorSample) DoorSample {
+	doorSample.DoorStyle = t.doorStyle(doorSample.DoorStyle)
+	doorSample.Wood = t.wood(doorSample.Wood)
+	doorSample.Colour = t.colour(doorSample.Colour)
+	return doorSample
+}
+
+func (t *translator) dealer(dealer Dealer) Dealer {
+	dealer.Location = t.text("dealer", dealer.ID, "location", dealer.Location)
+	dealer.Description = t.text("dealer", dealer.ID, "description", dealer.Description)
+	return dealer
+}
+
+// CreateTranslationAPI manages the translations of one entity row, e.g.
+// PUT /translation/colour/12/fr {"name": "Blanc"}
+func CreateTranslationAPI(party router.Party) {
+	party.Use(AdminMiddleware)
+	party.Get("/:entity/:id", findTranslationsHandler)
+	party.Put("/:entity/:id/:locale", putTranslationHandler)
+	party.Delete("/:entity/:id/:locale", removeTranslationHandler)
+}
+
+// translationParams reads the entity and id, and the locale when the route
+// has one. It answers the request itself if they're wrong.
+func translationParams(ctx context.Context, withLocale bool) (string, translatableEntity, int64, string, bool) {
+	entityName := ctx.Params().Get("entity")
+	entity, ok := translatableEntities[entityName]
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]interface{}{"error": "Unknown translatable entity " + entityName})
+		return "", entity, 0, "", false
+	}
+
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read " + entityName + " id"})
+		return "", entity, 0, "", false
+	}
+
+	if !withLocale {
+		return entityName, entity, id, "", true
+	}
+	locale := strings.ToLower(ctx.Params().Get("locale"))
+	if locale == GetConfig().DefaultLocale {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": locale + " is the default locale, update the " + entityName + " itself"})
+		return "", entity, 0, "", false
+	}
+	if supported, ok := supportedLocale(locale); !ok || supported != locale {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Locale must be one of " + strings.Join(GetConfig().Locales, ", ")})
+		return "", entity, 0, "", false
+	}
+	return entityName, entity, id, locale, true
+}
+
+func findTranslationsHandler(ctx context.Context) {
+	entityName, entity, id, _, ok := translationParams(ctx, false)
+	if !ok {
+		return
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	var exists bool
+	err := GetDBConnection().QueryRowContext(queryCtx, `SELECT EXISTS (SELECT 1 FROM `+entity.Table+` WHERE id = $1)`,
+		id).Scan(&exists)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	if !exists {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]interface{}{"error": "No " + entityName + " found"})
+		return
+	}
+
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT locale, `+strings.Join(entity.Fields, ", ")+`
+		FROM `+entity.Translations+`
+		WHERE `+entity.Key+` = $1
+		ORDER BY locale`, id)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer rows.Close()
+
+	byLocale := map[string]map[string]interface{}{}
+	for rows.Next() {
+		var locale string
+		values := make([]sql.NullString, len(entity.Fields))
+		dest := []interface{}{&locale}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+
+		fields := map[string]interface{}{}
+		for i, value := range values {
+			if value.Valid {
+				fields[entity.Fields[i]] = value.String
+			} else {
+				fields[entity.Fields[i]] = nil
+			}
+		}
+		byLocale[locale] = fields
+	}
+	if err := rows.Err(); err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{
+		"id":            id,
+		"defaultLocale": GetConfig().DefaultLocale,
+		"translations":  byLocale,
+	})
+}
+
+// putTranslationHandler replaces the locale's translation, fields left out
+// fall back to the default locale
+func putTranslationHandler(ctx context.Context) {
+	entityName, entity, id, locale, ok := translationParams(ctx, true)
+	if !ok {
+		return
+	}
+
+	body := map[string]*string{}
+	if err := ctx.ReadJSON(&body); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read translation"})
+		return
+	}
+	for field := range body {
+		if !containsField(entity.Fields, field) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "Unknown field " + field})
+			return
+		}
+	}
+
+	args := []interface{}{id, locale}
+	placeholders := []string{}
+	updates := []string{}
+	translation := map[string]interface{}{"id": id, "locale": locale}
+	for _, field := range entity.Fields {
+		args = append(args, body[field])
+		placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+		updates = append(updates, field+" = EXCLUDED."+field)
+		translation[field] = body[field]
+	}
+
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	// Nothing is inserted if the row doesn't exist
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	res, err := tx.ExecContext(queryCtx, `
+		INSERT INTO `+entity.Translations+` (`+entity.Key+`, locale, `+strings.Join(entity.Fields, ", ")+`)
+		SELECT $1, $2, `+strings.Join(placeholders, ", ")+`
+		WHERE EXISTS (SELECT 1 FROM `+entity.Table+` WHERE id = $1)
+		ON CONFLICT (`+entity.Key+`, locale) DO UPDATE SET `+strings.Join(updates, ", "), args...)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	affect, err := res.RowsAffected()
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	if affect < 1 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]interface{}{"error": "No " + entityName + " found"})
+		return
+	}
+
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, WebhookEventName(entityName, "updated"), translation)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), entityName)
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(translation)
+}
+
+func removeTranslationHandler(ctx context.Context) {
+	entityName, entity, id, locale, ok := translationParams(ctx, true)
+	if !ok {
+		return
+	}
+
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	res, err := tx.ExecContext(queryCtx, `
+		DELETE FROM `+entity.Translations+`
+		WHERE `+entity.Key+` = $1 AND locale = $2`, id, locale)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	affect, err := res.RowsAffected()
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	if affect < 1 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]interface{}{"error": "No translation found"})
+		return
+	}
+
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, WebhookEventName(entityName, "updated"),
+		map[string]interface{}{"id": id, "locale": locale})
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), entityName)
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{"id": id, "locale": locale})
+}
+
+func containsField(fields []string, field string) bool {
+	for _, existing := range fields {
+		if existing == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package muskoka
+
+import (
+	"bytes"
+	stdContext "context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentResponse is a saved response. StatusCode is zero while the first
+// request with the key is still running.
+type idempotentResponse struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+func InitIdempotency() {
+	createIdempotencyKeyTable()
+	createIdempotencyKeyCreatedAtIndex()
+}
+
+// idempotency_keys holds the first response to each key for
+// MUSKOKA_IDEMPOTENCY_WINDOW. Keys belong to the user and route they were
+// sent to, so they can't collide across either.
+func createIdempotencyKeyTable() {
+	_, err := GetDBConnection().Exec(`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			owner text NOT NULL,
+			route text NOT NULL,
+			key text NOT NULL,
+			request_hash text NOT NULL,
+			status_code integer,
+			content_type text,
+			body bytea,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			PRIMARY KEY (owner, route, key)
+		);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func createIdempotencyKeyCreatedAtIndex() {
+	_, err := GetDBConnection().Exec(`CREATE INDEX IF NOT EXISTS idempotency_keys__created_at__idx ON idempotency_keys (created_at);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// IdempotencyMiddleware makes a create safe to retry. The first request with
+// an Idempotency-Key runs and its response is saved, retries with the same
+// key and body get that response again with Idempotent-Replayed, and the
+// same key with a different body is rejected. Server errors aren't saved so
+// they can be retried.
+func IdempotencyMiddleware(ctx context.Context) {
+	key := ctx.GetHeader(idempotencyKeyHeader)
+	if len(key) == 0 {
+		ctx.Next()
+		return
+	}
+	if len(key) > 255 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Idempotency-Key must be at most 255 characters"})
+		return
+	}
+
+	maxBody := int64(GetConfig().IdempotencyMaxBody)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(ctx.ResponseWriter(), ctx.Request().Body, maxBody))
+	if _, ok := err.(*http.MaxBytesError); ok {
+		ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+		ctx.JSON(map[string]interface{}{"error": "Request body must be at most " + strconv.FormatInt(maxBody, 10) + " bytes"})
+		return
+	}
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read request body"})
+		return
+	}
+	ctx.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(sum[:])
+
+	reqCtx := ctx.Request().Context()
+	owner := idempotencyOwner(ctx.Request())
+	route := ctx.GetCurrentRoute().Path()
+
+	claimed, saved, err := claimIdempotencyKey(reqCtx, owner, route, key, requestHash)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	if !claimed {
+		switch {
+		case saved.RequestHash != requestHash:
+			ctx.StatusCode(iris.StatusUnprocessableEntity)
+			ctx.JSON(map[string]interface{}{"error": "Idempotency-Key was already used with a different request"})
+		case saved.StatusCode == 0:
+			ctx.Header("Retry-After", "1")
+			ctx.StatusCode(iris.StatusConflict)
+			ctx.JSON(map[string]interface{}{"error": "A request with this Idempotency-Key is still in progress"})
+		default:
+			ctx.Header("Idempotent-Replayed", "true")
+			if len(saved.ContentType) > 0 {
+				ctx.Header("Content-Type", saved.ContentType)
+			}
+			ctx.StatusCode(saved.StatusCode)
+			ctx.Write(saved.Body)
+		}
+		return
+	}
+
+	saved = idempotentResponse{RequestHash: requestHash}
+	defer func() {
+		// Also runs if the handler panics, so the key isn't stuck in progress
+		if saved.StatusCode == 0 {
+			releaseIdempotencyKey(reqCtx, owner, route, key)
+		}
+	}()
+
+	ctx.Record()
+	ctx.Next()
+
+	recorder := ctx.Recorder()
+	if recorder.StatusCode() >= iris.StatusInternalServerError {
+		return
+	}
+	response := idempotentResponse{
+		RequestHash: requestHash,
+		StatusCode:  recorder.StatusCode(),
+		ContentType: recorder.Header().Get("Content-Type"),
+		Body:        recorder.Body(),
+	}
+	if err := saveIdempotentResponse(owner, route, key, response); err != nil {
+		LoggerFrom(reqCtx).Error("unable to save idempotent response", "route", route, "error", err)
+		return
+	}
+	saved = response
+}
+
+// idempotencyOwner scopes keys to the user, or the client ip for anonymous
+// callers so they can't replay each other's responses
+func idempotencyOwner(r *http.Request) string {
+	if username := UsernameFromRequest(r); len(username) > 0 {
+		return username
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// claimIdempotencyKey reports whether this request is the first with key.
+// If it isn't, the saved response is returned instead.
+func claimIdempotencyKey(ctx stdContext.Context, owner string, route string, key string,
+	requestHash string) (bool, idempotentResponse, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	db := GetDBConnection()
+	config := GetConfig()
+
+	// Expired keys, and this key if it was left in progress, go first so the
+	// insert can take their place
+	_, err := db.ExecContext(queryCtx, `
+		DELETE FROM idempotency_keys
+		WHERE created_at < now() - make_interval(secs => $1)
+			OR (owner = $2 AND route = $3 AND key = $4 AND status_code IS NULL
+				AND created_at < now() - make_interval(secs => $5))
+		`, config.IdempotencyWindow.Seconds(), owner, route, key, config.IdempotencyLockTimeout.Seconds())
+	if err != nil {
+		return false, idempotentResponse{}, err
+	}
+
+	res, err := db.ExecContext(queryCtx, `
+		INSERT INTO idempotency_keys (owner, route, key, request_hash)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		`, owner, route, key, requestHash)
+	if err != nil {
+		return false, idempotentResponse{}, err
+	}
+	affect, err := res.RowsAffected()
+	if err != nil {
+		return false, idempotentResponse{}, err
+	}
+	if affect == 1 {
+		return true, idempotentResponse{}, nil
+	}
+
+	saved := idempotentResponse{}
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	err = db.QueryRowContext(queryCtx, `
+		SELECT request_hash, status_code, content_type, body
+		FROM idempotency_keys
+		WHERE owner = $1 AND route = $2 AND key = $3
+		`, owner, route, key).Scan(&saved.RequestHash, &statusCode, &contentType, &saved.Body)
+	if err == sql.ErrNoRows {
+		// Released since the insert, treat it as still in progress
+		return false, idempotentResponse{RequestHash: requestHash}, nil
+	}
+	if err != nil {
+		return false, idempotentResponse{}, err
+	}
+	saved.StatusCode = int(statusCode.Int64)
+	saved.ContentType = contentType.String
+	return false, saved, nil
+}
+
+// saveIdempotentResponse and releaseIdempotencyKey run after the handler, so
+// they don't stop if the client has gone away
+func saveIdempotentResponse(owner string, route string, key string, response idempotentResponse) error {
+	queryCtx, cancel := withQueryTimeout(stdContext.Background())
+	defer cancel()
+	_, err := GetDBConnection().ExecContext(queryCtx, `
+		UPDATE idempotency_keys
+		SET status_code = $4, content_type = $5, body = $6
+		WHERE owner = $1 AND route = $2 AND key = $3
+		`, owner, route, key, response.StatusCode, response.ContentType, response.Body)
+	return err
+}
+
+func releaseIdempotencyKey(ctx stdContext.Context, owner string, route string, key string) {
+	queryCtx, cancel := withQueryTimeout(stdContext.Background())
+	defer cancel()
+	_, err := GetDBConnection().ExecContext(queryCtx, `
+		DELETE FROM idempotency_keys
+		WHERE owner = $1 AND route = $2 AND key = $3 AND status_code IS NULL
+		`, owner, route, key)
+	if err != nil {
+		LoggerFrom(ctx).Error("unable to release idempotency key", "route", route, "error", err)
+	}
+}
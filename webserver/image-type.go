@@ -1,7 +1,11 @@
 package muskoka
 
 import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
 	"strconv"
+	"strings"
 
 	"github.com/kataras/iris"
 	"github.com/kataras/iris/context"
@@ -42,10 +46,13 @@ func createImageTypeIndices() {
 }
 
 func CreateImageTypeAPI(party router.Party) {
-	party.Get("findOne/:id", findOneImageTypeHandler)
-	party.Get("", findImageTypesHandler)
-	party.Post("", insertImageTypeHandler)
+	cachePolicy := CatalogCachePolicy("image-type")
+	party.Get("findOne/:id", CacheMiddleware(cachePolicy), findOneImageTypeHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findImageTypesHandler)
+	party.Post("", IdempotencyMiddleware, insertImageTypeHandler)
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(imageTypeBulkResource))
 	party.Put("", updateOneImageTypeHandler)
+	party.Patch("/:id", AdminMiddleware, PatchHandler(imageTypePatchResource))
 	party.Delete("/:id", removeOneImageTypeHandler)
 }
 
@@ -57,8 +64,15 @@ func findOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
+	if serveShaped(ctx, imageTypeShape, shapeQuery{Where: "image_types.id = $1", Args: []interface{}{id}, One: true},
+		"image-type/"+strconv.FormatInt(id, 10)) {
+		return
+	}
+
 	imageType := ImageType{ID: id}
-	err = GetDBConnection().QueryRow(`
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = GetDBConnection().QueryRowContext(queryCtx, `
 		SELECT name, is_specific_dimension, width, height
 		FROM image_types
 		WHERE id = $1
@@ -72,18 +86,32 @@ func findOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(imageType)
+	ServeCacheable(ctx, imageType, "image-type/"+strconv.FormatInt(id, 10))
 }
 
 func findImageTypesHandler(ctx context.Context) {
-	rows, err := GetDBConnection().Query("SELECT id, name, is_specific_dimension, width, height FROM image_types")
+	if serveShaped(ctx, imageTypeShape, shapeQuery{}) {
+		return
+	}
+
+	imageTypes, err := FindImageTypes(ctx.Request().Context())
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
+
+	ServeCacheable(ctx, imageTypes)
+}
+
+func FindImageTypes(ctx stdContext.Context) (*[]ImageType, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, "SELECT id, name, is_specific_dimension, width, height FROM image_types")
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	imageTypes := []ImageType{}
@@ -92,16 +120,12 @@ func findImageTypesHandler(ctx context.Context) {
 		err = rows.Scan(&imageType.ID, &imageType.Name,
 			&imageType.IsSpecificDimension, &imageType.Width, &imageType.Height)
 		if err != nil {
-			statusCode, errObj := HandleDBError(err)
-			ctx.StatusCode(statusCode)
-			ctx.JSON(errObj)
-			return
+			return nil, err
 		}
 		imageTypes = append(imageTypes, imageType)
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(imageTypes)
+	return &imageTypes, nil
 }
 
 func insertImageTypeHandler(ctx context.Context) {
@@ -113,7 +137,18 @@ func insertImageTypeHandler(ctx context.Context) {
 		return
 	}
 
-	err := GetDBConnection().QueryRow(`
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = tx.QueryRowContext(queryCtx, `
 		INSERT INTO image_types (name, is_specific_dimension, width, height)
 		VALUES($1,$2,$3,$4) 
 		returning id;`,
@@ -125,6 +160,14 @@ func insertImageTypeHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "image_type.created", imageType)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(imageType)
 }
@@ -137,7 +180,18 @@ func updateOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
-	stmt, err := GetDBConnection().Prepare(`
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := tx.PrepareContext(queryCtx, `
 		UPDATE image_types 
 		SET name=$1, is_specific_dimension=$2, width=$3, height=$4  
 		WHERE id=$5
@@ -149,7 +203,7 @@ func updateOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
-	res, err := stmt.Exec(imageType.Name, imageType.IsSpecificDimension,
+	res, err := stmt.ExecContext(queryCtx, imageType.Name, imageType.IsSpecificDimension,
 		imageType.Width, imageType.Height, imageType.ID)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
@@ -180,6 +234,16 @@ func updateOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "image_type.updated", imageType)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "image-type")
+
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(map[string]interface{}{})
 }
@@ -192,7 +256,18 @@ func removeOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
-	stmt, err := GetDBConnection().Prepare("delete from image_types where id=$1")
+	tx, err := GetDBConnection().BeginTx(ctx.Request().Context(), nil)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer tx.Rollback()
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	stmt, err := tx.PrepareContext(queryCtx, "delete from image_types where id=$1")
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -200,7 +275,7 @@ func removeOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
-	res, err := stmt.Exec(id)
+	res, err := stmt.ExecContext(queryCtx, id)
 	if err != nil {
 		statusCode, result := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -230,9 +305,104 @@ func removeOneImageTypeHandler(ctx context.Context) {
 		return
 	}
 
+	err = commitWithCatalogEvent(ctx.Request().Context(), tx, "image_type.deleted", map[string]interface{}{"id": id})
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	InvalidateCache(ctx.Request().Context(), "image-type")
+
 	ctx.StatusCode(iris.StatusOK)
 	idString := strconv.FormatInt(id, 10)
 	ctx.JSON(map[string]interface{}{
 		"id": idString,
 	})
 }
+
+var imageTypeBulkResource = BulkResource{
+	Entity: "image-type",
+	Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		imageType, err := readBulkImageType(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO image_types (name, is_specific_dimension, width, height)
+			VALUES($1,$2,$3,$4)
+			returning id;`,
+			imageType.Name, imageType.IsSpecificDimension, imageType.Width, imageType.Height).Scan(&imageType.ID)
+		return BulkChange{ID: imageType.ID, Data: imageType}, err
+	},
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		imageType, err := readBulkImageType(data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		change := BulkChange{ID: imageType.ID, Data: imageType}
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		res, err := tx.ExecContext(queryCtx, `
+			UPDATE image_types
+			SET name=$1, is_specific_dimension=$2, width=$3, height=$4
+			WHERE id=$5`,
+			imageType.Name, imageType.IsSpecificDimension, imageType.Width, imageType.Height, imageType.ID)
+		if err != nil {
+			return change, err
+		}
+		return change, requireRowsAffected(res)
+	},
+	// Image types still in use can't be deleted, the foreign key error says so
+	Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		res, err := tx.ExecContext(queryCtx, `DELETE FROM image_types WHERE id=$1`, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		return BulkChange{}, requireRowsAffected(res)
+	},
+}
+
+var imageTypePatchResource = PatchResource{
+	Entity: "image-type",
+	Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		imageType := &ImageType{ID: id}
+		err := tx.QueryRowContext(queryCtx, `
+			SELECT name, is_specific_dimension, width, height
+			FROM image_types
+			WHERE id = $1
+			FOR UPDATE`,
+			id).Scan(&imageType.Name, &imageType.IsSpecificDimension, &imageType.Width, &imageType.Height)
+		return imageType, err
+	},
+	Update: imageTypeBulkResource.Update,
+}
+
+func readBulkImageType(data json.RawMessage) (*ImageType, error) {
+	imageType := &ImageType{}
+	if err := json.Unmarshal(data, imageType); err != nil {
+		return nil, err
+	}
+
+	validationErrors := ValidationErrors{}
+	if len(strings.TrimSpace(imageType.Name)) == 0 {
+		validationErrors["name"] = "Name is required."
+	}
+	if imageType.IsSpecificDimension && (imageType.Width < 1 || imageType.Height < 1) {
+		validationErrors["width"] = "Width and height are required for a specific dimension."
+	}
+	if len(validationErrors) > 0 {
+		return nil, validationErrors
+	}
+	return imageType, nil
+}
@@ -1,15 +1,30 @@
 package muskoka
 
+import (
+	stdContext "context"
+	"database/sql"
+	"net/url"
+	"strconv"
+
+	"github.com/lib/pq"
+)
 
 type Image struct {
 	ID        	int64     	`json:"id"`
 	Filename  	string    	`json:"filename"`
 	Size		int64		`json:"size"`
 	ImageType 	ImageType 	`json:"imageType"`
+	// Door sample, wood and door style images are ordered by position, and
+	// one door sample image is the primary
+	Position	int		`json:"position,omitempty"`
+	Primary		bool		`json:"primary,omitempty"`
+	// Placeholder is set on the stand-in for a door sample without images
+	Placeholder	bool		`json:"placeholder,omitempty"`
 }
 
 func InitImage() {
 	createImageTable()
+	migrateImageTable()
 	createImageIndices()
 }
 
@@ -21,7 +36,44 @@ func createImageTable() {
             image_type_id integer references image_types NOT NULL,
 			door_sample_id integer references door_samples ON DELETE CASCADE,
 			gallery_sample_id integer references gallery_samples ON DELETE CASCADE,
-			dealer_id integer references dealers ON DELETE CASCADE
+			dealer_id integer references dealers ON DELETE CASCADE,
+			colour_id integer references colours ON DELETE CASCADE,
+			wood_id integer references wood ON DELETE CASCADE,
+			door_style_id integer references door_styles ON DELETE CASCADE,
+			position integer NOT NULL DEFAULT 0,
+			is_primary boolean NOT NULL DEFAULT FALSE
+		);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// migrateImageTable adds the newer columns to older databases, where each
+// door sample had one image, which becomes its primary
+func migrateImageTable() {
+	_, err := GetDBConnection().Exec(`
+		ALTER TABLE images
+			ADD COLUMN IF NOT EXISTS colour_id integer references colours ON DELETE CASCADE,
+			ADD COLUMN IF NOT EXISTS wood_id integer references wood ON DELETE CASCADE,
+			ADD COLUMN IF NOT EXISTS door_style_id integer references door_styles ON DELETE CASCADE,
+			ADD COLUMN IF NOT EXISTS position integer NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS is_primary boolean NOT NULL DEFAULT FALSE;`)
+	if err != nil {
+		panic(err)
+	}
+
+	// New images start at position 1, so this only numbers the old ones
+	_, err = GetDBConnection().Exec(`
+		UPDATE images
+		SET position = numbered.position, is_primary = numbered.position = 1
+		FROM (
+			SELECT id, row_number() OVER (PARTITION BY door_sample_id ORDER BY id) AS position
+			FROM images
+			WHERE door_sample_id IS NOT NULL
+		) numbered
+		WHERE images.id = numbered.id AND NOT EXISTS (
+			SELECT 1 FROM images ordered
+			WHERE ordered.door_sample_id = images.door_sample_id AND ordered.position > 0
 		);`)
 	if err != nil {
 		panic(err)
@@ -33,4 +85,164 @@ func createImageIndices() {
 	if err != nil {
 		panic(err)
 	}
+
+	_, err = GetDBConnection().Exec(`CREATE UNIQUE INDEX IF NOT EXISTS images__primary__key ON images (door_sample_id) WHERE is_primary;`)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = GetDBConnection().Exec(`CREATE INDEX IF NOT EXISTS images__door_sample_id__idx ON images (door_sample_id, position);`)
+	if err != nil {
+		panic(err)
+	}
+
+	// A colour has at most one swatch
+	_, err = GetDBConnection().Exec(`CREATE UNIQUE INDEX IF NOT EXISTS images__swatch__key ON images (colour_id);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// placeholderImage stands in for the primary image of a door sample without
+// any
+func placeholderImage() Image {
+	return Image{Filename: GetConfig().PlaceholderImage, Placeholder: true}
+}
+
+// Wood and door styles own a list of images through a column of images,
+// e.g. wood_id, kept in the order they were sent. Dealers and gallery
+// samples own just the one. owner is always one of these constants, never
+// user input.
+const (
+	woodImageOwner          = "wood_id"
+	doorStyleImageOwner     = "door_style_id"
+	dealerImageOwner        = "dealer_id"
+	gallerySampleImageOwner = "gallery_sample_id"
+)
+
+// checkOwnedImages numbers the images in order and adds what's wrong with
+// them to validationErrors under field
+func checkOwnedImages(images []Image, field string, validationErrors ValidationErrors) {
+	for i := range images {
+		image := &images[i]
+		if len(image.Filename) == 0 {
+			validationErrors[field] = "Every image needs a filename."
+		} else if image.ImageType.ID < 1 {
+			validationErrors["imageType"] = "Image Type is required."
+		}
+		image.Position = i + 1
+	}
+}
+
+// findOwnedImages returns the images of each owner in order
+func findOwnedImages(ctx stdContext.Context, owner string, ids []int64) (map[int64][]Image, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT images.`+owner+`, images.id, images.filename, images.size, images.position,
+			image_types.id, image_types.name, image_types.is_specific_dimension,
+			image_types.width, image_types.height
+		FROM images
+		INNER JOIN image_types ON images.image_type_id = image_types.id
+		WHERE images.`+owner+` = ANY($1)
+		ORDER BY images.position ASC, images.id ASC`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := map[int64][]Image{}
+	for rows.Next() {
+		var ownerID int64
+		image := Image{}
+		err = rows.Scan(&ownerID, &image.ID, &image.Filename, &image.Size, &image.Position,
+			&image.ImageType.ID, &image.ImageType.Name, &image.ImageType.IsSpecificDimension,
+			&image.ImageType.Width, &image.ImageType.Height)
+		if err != nil {
+			return nil, err
+		}
+		images[ownerID] = append(images[ownerID], image)
+	}
+	return images, rows.Err()
+}
+
+// findOwnedImagesForUpdate reads and locks the owner's images in order
+// within tx
+func findOwnedImagesForUpdate(ctx stdContext.Context, tx *sql.Tx, owner string, id int64) ([]Image, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, filename, size, image_type_id, position
+		FROM images
+		WHERE `+owner+` = $1
+		ORDER BY position ASC, id ASC
+		FOR UPDATE`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []Image{}
+	for rows.Next() {
+		image := Image{}
+		err = rows.Scan(&image.ID, &image.Filename, &image.Size, &image.ImageType.ID, &image.Position)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// saveOwnedImages makes the owner's images match images, in their order.
+// Images with an id are kept, the others added, and the ones left out are
+// removed. It returns the uploads to delete once committed.
+func saveOwnedImages(ctx stdContext.Context, tx *sql.Tx, owner string, id int64, field string,
+	images []Image) ([]string, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	oldImages, err := findOwnedImagesForUpdate(queryCtx, tx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	oldFilenames := map[int64]string{}
+	for _, image := range oldImages {
+		oldFilenames[image.ID] = image.Filename
+	}
+
+	for i := range images {
+		image := &images[i]
+		if image.ID > 0 {
+			if _, ok := oldFilenames[image.ID]; !ok {
+				return nil, ValidationErrors{field: "Image " + strconv.FormatInt(image.ID, 10) + " belongs to something else."}
+			}
+			delete(oldFilenames, image.ID)
+			_, err = tx.ExecContext(queryCtx, `UPDATE images SET position = $1 WHERE id = $2`,
+				image.Position, image.ID)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Stored filenames are escaped, updates send them back as they are
+		image.Filename = url.QueryEscape(image.Filename)
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO images (filename, size, image_type_id, `+owner+`, position)
+			VALUES($1,$2,$3,$4,$5)
+			returning id;`,
+			image.Filename, image.Size, image.ImageType.ID, id, image.Position).Scan(&image.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	removed := []string{}
+	for imageID, filename := range oldFilenames {
+		if _, err = tx.ExecContext(queryCtx, `DELETE FROM images WHERE id = $1`, imageID); err != nil {
+			return nil, err
+		}
+		removed = append(removed, filename)
+	}
+	return removed, nil
 }
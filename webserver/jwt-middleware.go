@@ -1,14 +1,16 @@
 package muskoka
 
 import (
+	"net/http"
+	"strings"
 	"sync"
+
 	jwt "github.com/dgrijalva/jwt-go"
 	jwtmiddleware "github.com/iris-contrib/middleware/jwt"
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
 )
 
-var JWT_SECRET = []byte("secret")
-
-
 var jwtMiddleware *jwtmiddleware.Middleware
 var jwtOnce sync.Once
 
@@ -17,10 +19,67 @@ func JWTMiddleware() *jwtmiddleware.Middleware {
 	jwtOnce.Do(func() {
 		jwtMiddleware = jwtmiddleware.New(jwtmiddleware.Config{
 			ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-				return JWT_SECRET, nil
+				return GetConfig().JWTSecret, nil
 			},
 			SigningMethod: jwt.SigningMethodHS256,
 		})
 	})
 	return jwtMiddleware
 }
+
+// UsernameFromRequest returns the username of a valid bearer token, or an
+// empty string. It doesn't reject anything, use JWTMiddleware for that.
+func UsernameFromRequest(r *http.Request) string {
+	username, _ := claimsFromRequest(r)["username"].(string)
+	return username
+}
+
+// IsAdminRequest reports whether the request has a valid bearer token for an
+// admin, as issued by LoginHandler and VerifyHandler.
+func IsAdminRequest(r *http.Request) bool {
+	isAdmin, _ := claimsFromRequest(r)["isAdmin"].(bool)
+	return isAdmin
+}
+
+// AdminMiddleware rejects requests without an admin bearer token
+func AdminMiddleware(ctx context.Context) {
+	if len(UsernameFromRequest(ctx.Request())) == 0 {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(map[string]interface{}{"error": "Login required"})
+		return
+	}
+	if !IsAdminRequest(ctx.Request()) {
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.JSON(map[string]interface{}{"error": "Admin required"})
+		return
+	}
+	ctx.Next()
+}
+
+func claimsFromRequest(r *http.Request) jwt.MapClaims {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil
+	}
+	return claimsFromToken(strings.TrimPrefix(authHeader, "Bearer "))
+}
+
+// claimsFromToken returns the claims of a valid token, or nil
+func claimsFromToken(tokenString string) jwt.MapClaims {
+	if len(tokenString) == 0 {
+		return nil
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return GetConfig().JWTSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return claims
+}
@@ -0,0 +1,169 @@
+package muskoka
+
+import (
+	"bufio"
+	stdContext "context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/context"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type loggerKey struct{}
+type requestInfoKey struct{}
+
+// requestInfo is filled in by the iris middleware once the route is known
+// and read back by the access log.
+type requestInfo struct {
+	ID    string
+	Route string
+	User  string
+}
+
+var logger *slog.Logger
+var loggerOnce sync.Once
+
+func GetLogger() *slog.Logger {
+	loggerOnce.Do(func() {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+			Level: GetConfig().LogLevel,
+		}))
+	})
+	return logger
+}
+
+// LoggerFrom returns the request scoped logger, or the base logger outside
+// of a request.
+func LoggerFrom(ctx stdContext.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return GetLogger()
+}
+
+func WithLogger(ctx stdContext.Context, l *slog.Logger) stdContext.Context {
+	return stdContext.WithValue(ctx, loggerKey{}, l)
+}
+
+// RequestID returns the id assigned by RequestLogWrapper
+func RequestID(ctx stdContext.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.ID
+	}
+	return ""
+}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,128}$`)
+
+// RequestLogWrapper assigns the request id, puts a logger on the request
+// context and writes the access log. Register it with app.WrapRouter.
+func RequestLogWrapper(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+
+	requestID := r.Header.Get("X-Request-ID")
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+
+	info, r := requestInfoFrom(r)
+	info.ID = requestID
+
+	requestLogger := GetLogger().With("requestId", requestID)
+	if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+		requestLogger = requestLogger.With("traceId", spanContext.TraceID().String())
+	}
+	ctx := WithLogger(r.Context(), requestLogger)
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next(recorder, r.WithContext(ctx))
+
+	level := slog.LevelInfo
+	if recorder.status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	requestLogger.LogAttrs(ctx, level, "request",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("route", info.Route),
+		slog.Int("status", recorder.status),
+		slog.Int64("bytes", recorder.bytes),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("ip", ClientIP(r)),
+		slog.String("user", info.User),
+	)
+}
+
+// RequestInfoMiddleware records the matched route pattern and user for the
+// access log.
+func RequestInfoMiddleware(ctx context.Context) {
+	if info, ok := ctx.Request().Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		if route := ctx.GetCurrentRoute(); route != nil {
+			info.Route = route.Path()
+		}
+		info.User = UsernameFromRequest(ctx.Request())
+	}
+
+	ctx.Next()
+}
+
+// requestInfoFrom returns the request's info, attaching a new one if the
+// request doesn't have one yet.
+func requestInfoFrom(r *http.Request) (*requestInfo, *http.Request) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info, r
+	}
+
+	info := &requestInfo{}
+	return info, r.WithContext(stdContext.WithValue(r.Context(), requestInfoKey{}, info))
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,123 @@
+package muskoka
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/kataras/iris/core/router"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "muskoka_http_requests_total",
+		Help: "HTTP requests by method, route pattern and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "muskoka_http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route pattern and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	s3OperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "muskoka_s3_operations_total",
+		Help: "S3 presign and delete calls by result.",
+	}, []string{"operation", "result"})
+
+	sesEmailsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "muskoka_ses_emails_total",
+		Help: "SES send calls by result.",
+	}, []string{"result"})
+
+	loginsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "muskoka_logins_total",
+		Help: "Login attempts by result.",
+	}, []string{"result"})
+)
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// MetricsWrapper observes every request. Register it with app.WrapRouter
+// before RequestLogWrapper so the route pattern is available.
+func MetricsWrapper(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next(recorder, r)
+
+	route := "unmatched"
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok && len(info.Route) > 0 {
+		route = info.Route
+	}
+
+	status := strconv.Itoa(recorder.status)
+	httpRequestsTotal.WithLabelValues(r.Method, route, status).Inc()
+	httpRequestDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+}
+
+func CreateMetricsAPI(party router.Party) {
+	handler := promhttp.Handler()
+	party.Get("", metricsAuthMiddleware, func(ctx context.Context) {
+		handler.ServeHTTP(ctx.ResponseWriter(), ctx.Request())
+	})
+	party.Get("/db", metricsAuthMiddleware, dbStatsHandler)
+}
+
+func dbStatsHandler(ctx context.Context) {
+	stats := DBStats()
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{
+		"maxOpenConnections": stats.MaxOpenConnections,
+		"openConnections":    stats.OpenConnections,
+		"inUse":              stats.InUse,
+		"idle":               stats.Idle,
+		"waitCount":          stats.WaitCount,
+		"waitDuration":       stats.WaitDuration.String(),
+		"maxIdleClosed":      stats.MaxIdleClosed,
+		"maxIdleTimeClosed":  stats.MaxIdleTimeClosed,
+		"maxLifetimeClosed":  stats.MaxLifetimeClosed,
+	})
+}
+
+// metricsAuthMiddleware accepts the configured bearer token or a client in
+// the allowlist. Without either configured only loopback is allowed.
+func metricsAuthMiddleware(ctx context.Context) {
+	config := GetConfig()
+
+	if len(config.MetricsToken) > 0 {
+		token := ctx.GetHeader("Authorization")
+		if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+config.MetricsToken)) == 1 {
+			ctx.Next()
+			return
+		}
+	}
+
+	ip := net.ParseIP(ClientIP(ctx.Request()))
+	if ip != nil {
+		if len(config.MetricsAllowlist) == 0 && len(config.MetricsToken) == 0 && ip.IsLoopback() {
+			ctx.Next()
+			return
+		}
+		for _, network := range config.MetricsAllowlist {
+			if network.Contains(ip) {
+				ctx.Next()
+				return
+			}
+		}
+	}
+
+	ctx.StatusCode(iris.StatusForbidden)
+	ctx.JSON(map[string]interface{}{"error": "Forbidden"})
+}
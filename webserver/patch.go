@@ -0,0 +1,166 @@
+package muskoka
+
+import (
+	"bytes"
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+// PatchResource loads the current item for update inside the transaction
+// and saves the patched item with the same function bulk updates use, so
+// the merged result is validated the same way.
+type PatchResource struct {
+	Entity string
+	Load   func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error)
+	Update func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error)
+}
+
+// PatchHandler applies an RFC 7396 JSON Merge Patch to the item with the
+// path's id. Fields left out of the patch keep their values and null
+// removes a field, which for most resources means its zero value.
+func PatchHandler(resource PatchResource) context.Handler {
+	return func(ctx context.Context) {
+		id, err := ctx.Params().GetInt64("id")
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "Unable to read id"})
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+		if err != nil || (mediaType != mergePatchContentType && mediaType != "application/json") {
+			ctx.Header("Accept-Patch", mergePatchContentType)
+			ctx.StatusCode(iris.StatusUnsupportedMediaType)
+			ctx.JSON(map[string]interface{}{"error": "Patch must be " + mergePatchContentType})
+			return
+		}
+
+		var patch interface{}
+		if err := decodeJSONNumbers(ctx.Request().Body, &patch); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(map[string]interface{}{"error": "Unable to read patch"})
+			return
+		}
+
+		change, err := ApplyPatch(ctx.Request().Context(), resource, id, patch)
+		if err == errPatchNotObject {
+			ctx.StatusCode(iris.StatusUnprocessableEntity)
+			ctx.JSON(map[string]interface{}{"error": "Patch must be an object"})
+			return
+		}
+		if err != nil {
+			writeBulkError(ctx, err)
+			return
+		}
+
+		ctx.StatusCode(iris.StatusOK)
+		ctx.JSON(change.Data)
+	}
+}
+
+var errPatchNotObject = errors.New("patch must be an object")
+
+// ApplyPatch merges patch into the item with id and saves it with its event
+// in one transaction, then clears the cache. The patch is decoded JSON,
+// numbers as json.Number or Go numbers.
+func ApplyPatch(ctx stdContext.Context, resource PatchResource, id int64, patch interface{}) (BulkChange, error) {
+	tx, err := GetDBConnection().BeginTx(ctx, nil)
+	if err != nil {
+		return BulkChange{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := resource.Load(ctx, tx, id)
+	if err != nil {
+		return BulkChange{}, err
+	}
+
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		return BulkChange{}, err
+	}
+	var document interface{}
+	if err := decodeJSONNumbers(bytes.NewReader(currentJSON), &document); err != nil {
+		return BulkChange{}, err
+	}
+
+	merged, ok := MergePatch(document, patch).(map[string]interface{})
+	if !ok {
+		return BulkChange{}, errPatchNotObject
+	}
+	// The path decides which item changes
+	merged["id"] = id
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return BulkChange{}, err
+	}
+
+	change, err := resource.Update(ctx, tx, mergedJSON)
+	if err != nil {
+		return change, err
+	}
+	err = EmitCatalogEventTx(ctx, tx, WebhookEventName(resource.Entity, "updated"), change.Data)
+	if err != nil {
+		return change, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return change, err
+	}
+	if change.AfterCommit != nil {
+		change.AfterCommit()
+	}
+	InvalidateCache(ctx, resource.Entity)
+	wakeWebhookWorker()
+	return change, nil
+}
+
+// MergePatch returns target with patch applied as described in RFC 7396
+func MergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = MergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// decodeJSONNumbers keeps numbers as json.Number so large ids and phone
+// numbers survive the round trip
+func decodeJSONNumbers(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// writeBulkError writes the error of a single bulk style operation the way
+// the single item handlers do
+func writeBulkError(ctx context.Context, err error) {
+	result := bulkErrorResult(err)
+	ctx.StatusCode(result.Status)
+	if len(result.ValidationErrors) > 0 {
+		ctx.JSON(map[string]interface{}{"validationErrors": result.ValidationErrors})
+		return
+	}
+	ctx.JSON(map[string]interface{}{"error": result.Error})
+}
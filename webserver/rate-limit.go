@@ -0,0 +1,243 @@
+package muskoka
+
+import (
+	"crypto/subtle"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
+
+// RateLimitStore keeps the token buckets. The in-memory store is per process,
+// set a shared store with SetRateLimitStore when running multiple instances.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type RateLimitKeyFunc func(ctx context.Context) string
+
+type RateLimitPolicy struct {
+	Name    string
+	Limit   RateLimit
+	KeyFunc RateLimitKeyFunc
+}
+
+var rateLimitStore RateLimitStore
+var rateLimitStoreOnce sync.Once
+
+func getRateLimitStore() RateLimitStore {
+	rateLimitStoreOnce.Do(func() {
+		if rateLimitStore == nil {
+			store := NewMemoryRateLimitStore(10 * time.Minute)
+			OnShutdown(store.Close)
+			rateLimitStore = store
+		}
+	})
+	return rateLimitStore
+}
+
+// SetRateLimitStore must be called before the app starts serving
+func SetRateLimitStore(store RateLimitStore) {
+	rateLimitStore = store
+}
+
+func RateLimitMiddleware(policy RateLimitPolicy) context.Handler {
+	return func(ctx context.Context) {
+		key := policy.Name + ":" + policy.KeyFunc(ctx)
+		result, err := getRateLimitStore().Take(key, policy.Limit)
+		if err != nil {
+			// Fail open, an unavailable store shouldn't take the API down with it
+			ctx.Next()
+			return
+		}
+
+		ctx.Header("RateLimit-Limit", strconv.Itoa(policy.Limit.Limit))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		ctx.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			ctx.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			ctx.StatusCode(iris.StatusTooManyRequests)
+			ctx.JSON(map[string]interface{}{"error": "Too many requests"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func KeyByIP(ctx context.Context) string {
+	return "ip:" + ClientIP(ctx.Request())
+}
+
+// KeyByUser falls back to the client ip for anonymous requests
+func KeyByUser(ctx context.Context) string {
+	if username := UsernameFromRequest(ctx.Request()); len(username) > 0 {
+		return "user:" + strings.ToLower(username)
+	}
+	return KeyByIP(ctx)
+}
+
+// KeyByAPIKey only keys on X-API-Key when it's one of the configured keys,
+// otherwise anyone could pick a new bucket for every request. Unknown keys
+// fall back to the user, then the client ip.
+func KeyByAPIKey(ctx context.Context) string {
+	if apiKey := ctx.GetHeader("X-API-Key"); len(apiKey) > 0 && isKnownAPIKey(apiKey) {
+		return "key:" + apiKey
+	}
+	return KeyByUser(ctx)
+}
+
+func isKnownAPIKey(apiKey string) bool {
+	known := false
+	for _, key := range GetConfig().APIKeys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+			known = true
+		}
+	}
+	return known
+}
+
+// ClientIP only trusts X-Forwarded-For when the request came through one of
+// the configured trusted proxies.
+func ClientIP(r *http.Request) string {
+	clientIP := remoteIP(r)
+	if !isTrustedProxy(clientIP) {
+		return clientIP
+	}
+
+	// Walk right to left, the first address we don't trust is the client
+	forwardedFor := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if !isTrustedProxy(ip) {
+			return ip
+		}
+		clientIP = ip
+	}
+
+	return clientIP
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func isTrustedProxy(ipString string) bool {
+	ip := net.ParseIP(ipString)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range GetConfig().TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	interval time.Duration
+	stop     chan struct{}
+}
+
+// NewMemoryRateLimitStore drops refilled buckets every interval
+func NewMemoryRateLimitStore(interval time.Duration) *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{
+		buckets:  map[string]*tokenBucket{},
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	go store.cleanup()
+	return store
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+	capacity := float64(limit.Limit)
+	perToken := limit.Period / time.Duration(limit.Limit)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		s.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.updated)
+	bucket.tokens = math.Min(capacity, bucket.tokens+float64(elapsed)/float64(perToken))
+	bucket.updated = now
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) * float64(perToken))
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((capacity - bucket.tokens) * float64(perToken))
+	bucket.full = now.Add(result.Reset)
+
+	return result, nil
+}
+
+func (s *MemoryRateLimitStore) Close() {
+	close(s.stop)
+}
+
+func (s *MemoryRateLimitStore) cleanup() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, bucket := range s.buckets {
+				// A full bucket is the same as no bucket
+				if now.After(bucket.full) {
+					delete(s.buckets, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
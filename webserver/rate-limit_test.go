@@ -0,0 +1,92 @@
+package muskoka
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreTake(t *testing.T) {
+	limit := RateLimit{Limit: 3, Period: time.Hour}
+
+	tests := []struct {
+		name      string
+		takes     int
+		allowed   bool
+		remaining int
+	}{
+		{"first request", 1, true, 2},
+		{"last token", 3, true, 0},
+		{"empty bucket", 4, false, 0},
+		{"well past empty", 10, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryRateLimitStore(time.Hour)
+			defer store.Close()
+
+			var result RateLimitResult
+			var err error
+			for i := 0; i < test.takes; i++ {
+				result, err = store.Take("test", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if result.Allowed != test.allowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, test.allowed)
+			}
+			if result.Remaining != test.remaining {
+				t.Errorf("Remaining = %d, want %d", result.Remaining, test.remaining)
+			}
+			if !result.Allowed && result.RetryAfter <= 0 {
+				t.Errorf("RetryAfter = %v, want it to be positive", result.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStoreRefill(t *testing.T) {
+	store := NewMemoryRateLimitStore(time.Hour)
+	defer store.Close()
+
+	limit := RateLimit{Limit: 1, Period: 20 * time.Millisecond}
+	if result, _ := store.Take("test", limit); !result.Allowed {
+		t.Fatal("first request wasn't allowed")
+	}
+	if result, _ := store.Take("test", limit); result.Allowed {
+		t.Fatal("second request was allowed before the bucket refilled")
+	}
+	if result, _ := store.Take("other", limit); !result.Allowed {
+		t.Fatal("another key shared the bucket")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if result, _ := store.Take("test", limit); !result.Allowed {
+		t.Fatal("request wasn't allowed after the bucket refilled")
+	}
+}
+
+func TestIsKnownAPIKey(t *testing.T) {
+	c := GetConfig()
+	apiKeys := c.APIKeys
+	c.APIKeys = []string{"partner-one", "partner-two"}
+	defer func() { c.APIKeys = apiKeys }()
+
+	tests := []struct {
+		apiKey string
+		known  bool
+	}{
+		{"partner-one", true},
+		{"partner-two", true},
+		{"partner-three", false},
+		{"partner", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if known := isKnownAPIKey(test.apiKey); known != test.known {
+			t.Errorf("isKnownAPIKey(%q) = %v, want %v", test.apiKey, known, test.known)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package muskoka
 
 import (
+	stdContext "context"
 	"strings"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/kataras/iris"
 	"github.com/kataras/iris/context"
 	"github.com/kataras/iris/core/router"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type SignedURLRequest struct {
@@ -17,6 +19,8 @@ type SignedURLRequest struct {
 	MimeType string `json:"mimeType"`
 }
 
+const assetsBucket = "assets.muskokacabco.com"
+
 var s3Service *s3.S3
 
 func InitS3() {
@@ -25,7 +29,9 @@ func InitS3() {
 
 func CreateUploadAPI(party router.Party) {
 
-	party.Post("/get-signed-url", getSignedURLHandler)
+	party.Post("/get-signed-url", RateLimitMiddleware(RateLimitPolicy{
+		Name: "signed-url", Limit: GetConfig().SignedURLRateLimit, KeyFunc: KeyByAPIKey,
+	}), getSignedURLHandler)
 }
 
 func getSignedURLHandler(ctx context.Context) {
@@ -37,32 +43,55 @@ func getSignedURLHandler(ctx context.Context) {
 		return
 	}
 
-	url, err := getUploadURL(signedURLRequest.Filename, signedURLRequest.MimeType)
+	url, err := getUploadURL(ctx.Request().Context(), signedURLRequest.Filename, signedURLRequest.MimeType)
 	if err != nil {
-		panic(err)
+		LoggerFrom(ctx.Request().Context()).Error("unable to sign upload url", "error", err)
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(map[string]interface{}{"error": "Unable to create signed url"})
+		return
 	}
 
 	ctx.StatusCode(iris.StatusOK)
 	ctx.JSON(map[string]interface{}{"signedUrl": url})
 }
 
-func getUploadURL(filename string, mimeType string) (string, error) {
+func getUploadURL(ctx stdContext.Context, filename string, mimeType string) (string, error) {
+	_, span := startSpan(ctx, "s3.PresignPutObject", attribute.String("s3.key", "assets/uploads/"+filename))
 	req, _ := s3Service.PutObjectRequest(&s3.PutObjectInput{
-		Bucket:      aws.String("assets.muskokacabco.com"),
+		Bucket:      aws.String(assetsBucket),
 		Key:         aws.String("assets/uploads/" + filename),
 		ContentType: aws.String(mimeType),
 	})
-	return req.Presign(15 * time.Minute)
+	url, err := req.Presign(15 * time.Minute)
+	s3OperationsTotal.WithLabelValues("presign", resultLabel(err)).Inc()
+	endSpan(span, err)
+	return url, err
 }
 
-func deleteS3Object(filename string) error {
+func deleteS3Object(ctx stdContext.Context, filename string) error {
 	cleanFilename := strings.Replace(filename, "+", " ", 1)
 	input := &s3.DeleteObjectInput{
-		Bucket: aws.String("assets.muskokacabco.com"),
+		Bucket: aws.String(assetsBucket),
 		Key:    aws.String("assets/uploads/" + cleanFilename),
 	}
 
+	ctx, span := startSpan(ctx, "s3.DeleteObject", attribute.String("s3.key", *input.Key))
 	/*result*/
-	_, err := s3Service.DeleteObject(input)
+	_, err := s3Service.DeleteObjectWithContext(ctx, input)
+	s3OperationsTotal.WithLabelValues("delete", resultLabel(err)).Inc()
+	endSpan(span, err)
 	return err
 }
+
+// deleteS3ObjectAfterCommit is for files whose rows are deleted in a
+// transaction. A failed delete only leaves an orphaned file, so it's logged
+// rather than reported.
+func deleteS3ObjectAfterCommit(ctx stdContext.Context, filenames ...string) func() {
+	return func() {
+		for _, filename := range filenames {
+			if err := deleteS3Object(ctx, filename); err != nil {
+				LoggerFrom(ctx).Error("unable to delete s3 object", "filename", filename, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Fixtures is the catalog read by the seed command. Rows refer to each other
+// by name, e.g. a door sample's doorStyle is the door style's name, and
+// image types are seeded before anything with an image.
+type Fixtures struct {
+	Colours        []string               `json:"colours"`
+	Wood           []string               `json:"wood"`
+	DoorStyleTypes []string               `json:"doorStyleTypes"`
+	ImageTypes     []ImageType            `json:"imageTypes"`
+	DoorStyles     []DoorStyleFixture     `json:"doorStyles"`
+	DoorSamples    []DoorSampleFixture    `json:"doorSamples"`
+	GallerySamples []GallerySampleFixture `json:"gallerySamples"`
+	Dealers        []DealerFixture        `json:"dealers"`
+}
+
+type DoorStyleFixture struct {
+	Name           string   `json:"name"`
+	DoorStyleTypes []string `json:"doorStyleTypes"`
+}
+
+type ImageFixture struct {
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	ImageType string `json:"imageType"`
+}
+
+// DoorSampleFixture takes its images in order, the first is the primary.
+// Image is short for a sample with one.
+type DoorSampleFixture struct {
+	DoorStyle string         `json:"doorStyle"`
+	Wood      string         `json:"wood"`
+	Colour    string         `json:"colour"`
+	Image     ImageFixture   `json:"image"`
+	Images    []ImageFixture `json:"images"`
+}
+
+type GallerySampleFixture struct {
+	Image ImageFixture `json:"image"`
+}
+
+type DealerFixture struct {
+	Name        string       `json:"name"`
+	Link        string       `json:"link"`
+	Location    string       `json:"location"`
+	PhoneNumber int64        `json:"phoneNumber"`
+	Email       string       `json:"email"`
+	OrderNum    int64        `json:"orderNum"`
+	Description string       `json:"description"`
+	Image       ImageFixture `json:"image"`
+}
+
+// SeedResult counts the rows added for each entity
+type SeedResult map[string]int
+
+func LoadFixtures(path string) (*Fixtures, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fixtures := &Fixtures{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(fixtures); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	return fixtures, nil
+}
+
+// Seed adds the fixtures in one transaction. It can be run again: named rows
+// that exist are reused, and samples and dealers whose image filename exists
+// are skipped. Existing rows aren't changed.
+func (f *Fixtures) Seed(ctx stdContext.Context) (SeedResult, error) {
+	tx, err := GetDBConnection().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	seeder := &fixtureSeeder{ctx: ctx, tx: tx, result: SeedResult{}}
+	if err := seeder.seed(f); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for entity, added := range seeder.result {
+		if added > 0 {
+			InvalidateCache(ctx, entity)
+		}
+	}
+	return seeder.result, nil
+}
+
+type fixtureSeeder struct {
+	ctx    stdContext.Context
+	tx     *sql.Tx
+	result SeedResult
+}
+
+func (s *fixtureSeeder) seed(f *Fixtures) error {
+	for _, name := range f.Colours {
+		if _, err := s.named("colour", "colours", name); err != nil {
+			return err
+		}
+	}
+	for _, name := range f.Wood {
+		if _, err := s.named("wood", "wood", name); err != nil {
+			return err
+		}
+	}
+	for _, name := range f.DoorStyleTypes {
+		if _, err := s.named("door-style-type", "door_style_types", name); err != nil {
+			return err
+		}
+	}
+	for _, imageType := range f.ImageTypes {
+		if err := s.imageType(imageType); err != nil {
+			return err
+		}
+	}
+	for _, doorStyle := range f.DoorStyles {
+		if err := s.doorStyle(doorStyle); err != nil {
+			return err
+		}
+	}
+	for _, doorSample := range f.DoorSamples {
+		if err := s.doorSample(doorSample); err != nil {
+			return err
+		}
+	}
+	for _, gallerySample := range f.GallerySamples {
+		if err := s.gallerySample(gallerySample); err != nil {
+			return err
+		}
+	}
+	for _, dealer := range f.Dealers {
+		if err := s.dealer(dealer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// named returns the id of the row called name, adding it if there isn't one
+func (s *fixtureSeeder) named(entity string, table string, name string) (int64, error) {
+	if len(name) == 0 {
+		return 0, fmt.Errorf("%s without a name", entity)
+	}
+
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	var id int64
+	var added bool
+	err := s.tx.QueryRowContext(queryCtx, `
+		WITH inserted AS (
+			INSERT INTO `+table+` (name)
+			VALUES ($1)
+			ON CONFLICT DO NOTHING
+			RETURNING id
+		)
+		SELECT id, TRUE FROM inserted
+		UNION ALL
+		SELECT id, FALSE FROM `+table+` WHERE lower(name) = lower($1)
+		LIMIT 1`, name).Scan(&id, &added)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q: %v", entity, name, err)
+	}
+	if added {
+		s.result[entity]++
+	}
+	return id, nil
+}
+
+// find returns the id of an existing row called name
+func (s *fixtureSeeder) find(entity string, table string, name string) (int64, error) {
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	var id int64
+	err := s.tx.QueryRowContext(queryCtx, `SELECT id FROM `+table+` WHERE lower(name) = lower($1)`,
+		name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no %s called %q", entity, name)
+	}
+	return id, err
+}
+
+func (s *fixtureSeeder) imageType(imageType ImageType) error {
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	res, err := s.tx.ExecContext(queryCtx, `
+		INSERT INTO image_types (name, is_specific_dimension, width, height)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`,
+		imageType.Name, imageType.IsSpecificDimension, imageType.Width, imageType.Height)
+	if err != nil {
+		return fmt.Errorf("image-type %q: %v", imageType.Name, err)
+	}
+	if affect, _ := res.RowsAffected(); affect > 0 {
+		s.result["image-type"]++
+	}
+	return nil
+}
+
+func (s *fixtureSeeder) doorStyle(doorStyle DoorStyleFixture) error {
+	added := s.result["door-style"]
+	id, err := s.named("door-style", "door_styles", doorStyle.Name)
+	if err != nil {
+		return err
+	}
+	if s.result["door-style"] == added {
+		// Existing styles keep their types
+		return nil
+	}
+
+	for _, name := range doorStyle.DoorStyleTypes {
+		doorStyleTypeID, err := s.find("door-style-type", "door_style_types", name)
+		if err != nil {
+			return fmt.Errorf("door-style %q: %v", doorStyle.Name, err)
+		}
+
+		queryCtx, cancel := withQueryTimeout(s.ctx)
+		_, err = s.tx.ExecContext(queryCtx, `
+			INSERT INTO door_style_door_style_types (door_style_id, door_style_type_id)
+			VALUES ($1, $2)`, id, doorStyleTypeID)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("door-style %q: %v", doorStyle.Name, err)
+		}
+	}
+	return nil
+}
+
+// hasImage reports whether the image was seeded before, which means the row
+// it belongs to was too
+func (s *fixtureSeeder) hasImage(image ImageFixture) (bool, error) {
+	if len(image.Filename) == 0 {
+		return false, fmt.Errorf("image without a filename")
+	}
+
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	var exists bool
+	err := s.tx.QueryRowContext(queryCtx, `
+		SELECT EXISTS (SELECT 1 FROM images WHERE lower(filename) = lower($1))`,
+		image.Filename).Scan(&exists)
+	return exists, err
+}
+
+// insertImage adds image for the row in column, e.g. door_sample_id
+func (s *fixtureSeeder) insertImage(image ImageFixture, column string, id int64) error {
+	return s.insertOrderedImage(image, column, id, 0, false)
+}
+
+// insertOrderedImage adds a door sample image at position
+func (s *fixtureSeeder) insertOrderedImage(image ImageFixture, column string, id int64,
+	position int, primary bool) error {
+	imageTypeID, err := s.find("image-type", "image_types", image.ImageType)
+	if err != nil {
+		return fmt.Errorf("image %q: %v", image.Filename, err)
+	}
+
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	_, err = s.tx.ExecContext(queryCtx, `
+		INSERT INTO images (filename, size, image_type_id, `+column+`, position, is_primary)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		image.Filename, image.Size, imageTypeID, id, position, primary)
+	if err != nil {
+		return fmt.Errorf("image %q: %v", image.Filename, err)
+	}
+	return nil
+}
+
+func (s *fixtureSeeder) doorSample(doorSample DoorSampleFixture) error {
+	images := doorSample.Images
+	if len(doorSample.Image.Filename) > 0 {
+		images = append([]ImageFixture{doorSample.Image}, images...)
+	}
+	// The first image tells whether the sample was seeded before
+	if len(images) == 0 {
+		return fmt.Errorf("door-sample of %q without an image", doorSample.DoorStyle)
+	}
+	exists, err := s.hasImage(images[0])
+	if err != nil || exists {
+		return err
+	}
+
+	doorStyleID, err := s.find("door-style", "door_styles", doorSample.DoorStyle)
+	if err != nil {
+		return fmt.Errorf("door-sample %q: %v", images[0].Filename, err)
+	}
+	woodID, err := s.find("wood", "wood", doorSample.Wood)
+	if err != nil {
+		return fmt.Errorf("door-sample %q: %v", images[0].Filename, err)
+	}
+	colourID, err := s.find("colour", "colours", doorSample.Colour)
+	if err != nil {
+		return fmt.Errorf("door-sample %q: %v", images[0].Filename, err)
+	}
+
+	err = checkDoorSampleCompatibility(s.ctx, s.tx.QueryRowContext, &DoorSample{
+		DoorStyle: DoorStyle{ID: doorStyleID}, Wood: Wood{ID: woodID}, Colour: Colour{ID: colourID}})
+	if err != nil {
+		return fmt.Errorf("door-sample %q: %v", images[0].Filename, err)
+	}
+
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	var id int64
+	err = s.tx.QueryRowContext(queryCtx, `
+		INSERT INTO door_samples (door_style_id, wood_id, colour_id)
+		VALUES ($1, $2, $3)
+		RETURNING id`, doorStyleID, woodID, colourID).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("door-sample %q: %v", images[0].Filename, err)
+	}
+	for i, image := range images {
+		if err := s.insertOrderedImage(image, "door_sample_id", id, i+1, i == 0); err != nil {
+			return err
+		}
+	}
+
+	s.result["door-sample"]++
+	return nil
+}
+
+func (s *fixtureSeeder) gallerySample(gallerySample GallerySampleFixture) error {
+	exists, err := s.hasImage(gallerySample.Image)
+	if err != nil || exists {
+		return err
+	}
+
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	var id int64
+	err = s.tx.QueryRowContext(queryCtx, `INSERT INTO gallery_samples DEFAULT VALUES RETURNING id`).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("gallery-sample %q: %v", gallerySample.Image.Filename, err)
+	}
+	if err := s.insertImage(gallerySample.Image, "gallery_sample_id", id); err != nil {
+		return err
+	}
+
+	s.result["gallery-sample"]++
+	return nil
+}
+
+func (s *fixtureSeeder) dealer(dealer DealerFixture) error {
+	exists, err := s.hasImage(dealer.Image)
+	if err != nil || exists {
+		return err
+	}
+
+	queryCtx, cancel := withQueryTimeout(s.ctx)
+	defer cancel()
+	var id int64
+	err = s.tx.QueryRowContext(queryCtx, `
+		INSERT INTO dealers (name, link, location, phone_num, email, order_num, description)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+		RETURNING id`,
+		dealer.Name, dealer.Link, dealer.Location, dealer.PhoneNumber, dealer.Email,
+		dealer.OrderNum, dealer.Description).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("dealer %q: %v", dealer.Name, err)
+	}
+	if err := s.insertImage(dealer.Image, "dealer_id", id); err != nil {
+		return err
+	}
+
+	s.result["dealer"]++
+	return nil
+}
@@ -0,0 +1,446 @@
+package muskoka
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
+
+// A catalogShape describes a resource as JSON built by Postgres, so
+// ?fields= and ?include= only select, join and nest what was asked for.
+//
+// fields takes dotted paths, e.g. fields=id,doorStyle.name. Naming an object
+// gives all of its default fields, and ids are always returned. include
+// adds the optional expansions, e.g. include=doorStyle.doorStyleTypes,image.imageType.
+type catalogShape struct {
+	From   string
+	Fields []shapeField
+}
+
+type shapeField struct {
+	Name string
+	// SQL is the value of a plain field
+	SQL string
+	// Fields makes this a nested object
+	Fields []shapeField
+	// Join is added to the query when this field is selected
+	Join string
+	// Optional fields are only returned when included
+	Optional bool
+}
+
+// shapeQuery is what a handler adds to the shape. Joins are always added,
+// e.g. for filters, and go before the joins the fields need.
+type shapeQuery struct {
+	Joins []string
+	Where string
+	Args  []interface{}
+	Order string
+	// One returns a single object, and not found if there is none
+	One bool
+	// Locale is the language of translated fields, serveShaped sets it
+	Locale string
+}
+
+// placeholderParam is the placeholder image's filename, Query binds it
+const placeholderParam = "$placeholder"
+
+const (
+	joinDoorSampleDoorStyles = "INNER JOIN door_styles ON door_samples.door_style_id = door_styles.id"
+	joinDoorSampleWood       = "INNER JOIN wood ON door_samples.wood_id = wood.id"
+	joinDoorSampleColours    = "INNER JOIN colours ON door_samples.colour_id = colours.id"
+	joinDoorSampleImages     = "LEFT JOIN images ON door_samples.id = images.door_sample_id AND images.is_primary"
+	joinGallerySampleImages  = "INNER JOIN images ON gallery_samples.id = images.gallery_sample_id"
+	joinDealerImages         = "INNER JOIN images ON dealers.id = images.dealer_id"
+	joinImageImageTypes      = "INNER JOIN image_types ON images.image_type_id = image_types.id"
+	// Door samples may have no primary image to join
+	joinOptionalImageImageTypes = "LEFT JOIN image_types ON images.image_type_id = image_types.id"
+)
+
+// doorStyleTypesSQL is the door style types of the door_styles row, sorted
+// by name like the all_door_styles view
+var doorStyleTypesSQL = `(
+	SELECT COALESCE(json_agg(json_build_object('id', door_style_types.id, 'name', ` + localizedSQL("door-style-type", "name") + `)
+		ORDER BY ` + localizedSQL("door-style-type", "name") + ` ASC), '[]')
+	FROM door_style_door_style_types
+	INNER JOIN door_style_types ON door_style_door_style_types.door_style_type_id = door_style_types.id
+	WHERE door_style_door_style_types.door_style_id = door_styles.id
+)`
+
+// doorSampleImagesSQL is every image of the door_samples row in order
+var doorSampleImagesSQL = `(
+	SELECT COALESCE(json_agg(json_build_object(
+		'id', sample_images.id, 'filename', sample_images.filename, 'size', sample_images.size,
+		'position', sample_images.position, 'primary', sample_images.is_primary,
+		'imageType', json_build_object('id', sample_image_types.id, 'name', sample_image_types.name,
+			'isSpecificDimension', sample_image_types.is_specific_dimension,
+			'width', sample_image_types.width, 'height', sample_image_types.height)
+	) ORDER BY sample_images.position ASC, sample_images.id ASC), '[]')
+	FROM images sample_images
+	INNER JOIN image_types sample_image_types ON sample_images.image_type_id = sample_image_types.id
+	WHERE sample_images.door_sample_id = door_samples.id
+)`
+
+// woodImagesSQL is every image of the wood row in order
+var woodImagesSQL = `(
+	SELECT COALESCE(json_agg(json_build_object(
+		'id', wood_images.id, 'filename', wood_images.filename, 'size', wood_images.size,
+		'position', wood_images.position,
+		'imageType', json_build_object('id', wood_image_types.id, 'name', wood_image_types.name,
+			'isSpecificDimension', wood_image_types.is_specific_dimension,
+			'width', wood_image_types.width, 'height', wood_image_types.height)
+	) ORDER BY wood_images.position ASC, wood_images.id ASC), '[]')
+	FROM images wood_images
+	INNER JOIN image_types wood_image_types ON wood_images.image_type_id = wood_image_types.id
+	WHERE wood_images.wood_id = wood.id
+)`
+
+// doorStyleDrawingsSQL is every profile drawing of the door_styles row in
+// order
+var doorStyleDrawingsSQL = `(
+	SELECT COALESCE(json_agg(json_build_object(
+		'id', drawings.id, 'filename', drawings.filename, 'size', drawings.size,
+		'position', drawings.position,
+		'imageType', json_build_object('id', drawing_image_types.id, 'name', drawing_image_types.name,
+			'isSpecificDimension', drawing_image_types.is_specific_dimension,
+			'width', drawing_image_types.width, 'height', drawing_image_types.height)
+	) ORDER BY drawings.position ASC, drawings.id ASC), '[]')
+	FROM images drawings
+	INNER JOIN image_types drawing_image_types ON drawings.image_type_id = drawing_image_types.id
+	WHERE drawings.door_style_id = door_styles.id
+)`
+
+// doorStyleCompatibilitySQL is the compatibility matrix of the door_styles
+// row, each wood with the colours it comes in
+var doorStyleCompatibilitySQL = `(
+	SELECT COALESCE(json_agg(json_build_object(
+		'wood', json_build_object('id', wood.id, 'name', ` + localizedSQL("wood", "name") + `),
+		'colours', (
+			SELECT json_agg(json_build_object('id', colours.id, 'name', ` + localizedSQL("colour", "name") + `)
+				ORDER BY ` + localizedSQL("colour", "name") + ` ASC)
+			FROM door_style_compatibility compatible_colours
+			INNER JOIN colours ON compatible_colours.colour_id = colours.id
+			WHERE compatible_colours.door_style_id = door_styles.id AND compatible_colours.wood_id = wood.id
+		)
+	) ORDER BY ` + localizedSQL("wood", "name") + ` ASC), '[]')
+	FROM wood
+	WHERE wood.id IN (
+		SELECT compatible_woods.wood_id
+		FROM door_style_compatibility compatible_woods
+		WHERE compatible_woods.door_style_id = door_styles.id
+	)
+)`
+
+// colourSwatchSQL is the swatch image of the colours row, or null
+var colourSwatchSQL = `(
+	SELECT json_build_object('id', swatches.id, 'filename', swatches.filename, 'size', swatches.size)
+	FROM images swatches
+	WHERE swatches.colour_id = colours.id
+)`
+
+var (
+	colourShape = catalogShape{From: "colours", Fields: []shapeField{
+		{Name: "id", SQL: "colours.id"},
+		{Name: "name", SQL: localizedSQL("colour", "name")},
+		{Name: "hex", SQL: "colours.hex"},
+		{Name: "rgb", SQL: `CASE WHEN colours.hex IS NULL THEN NULL
+			ELSE json_build_object('r', colours.rgb_red, 'g', colours.rgb_green, 'b', colours.rgb_blue) END`},
+		{Name: "lab", SQL: `CASE WHEN colours.hex IS NULL THEN NULL
+			ELSE json_build_object('l', colours.lab_l, 'a', colours.lab_a, 'b', colours.lab_b) END`},
+		{Name: "family", SQL: "colours.family"},
+		{Name: "finish", SQL: "colours.finish"},
+		{Name: "swatch", SQL: colourSwatchSQL},
+	}}
+
+	woodShape = catalogShape{From: "wood", Fields: []shapeField{
+		{Name: "id", SQL: "wood.id"},
+		{Name: "name", SQL: localizedSQL("wood", "name")},
+		{Name: "jankaHardness", SQL: "wood.janka_hardness"},
+		{Name: "grainPattern", SQL: "wood.grain_pattern"},
+		{Name: "paintable", SQL: "wood.paintable"},
+		{Name: "stainable", SQL: "wood.stainable"},
+		{Name: "priceTier", SQL: "wood.price_tier"},
+		{Name: "sustainabilityNotes", SQL: "wood.sustainability_notes"},
+		{Name: "images", SQL: woodImagesSQL, Optional: true},
+	}}
+
+	doorStyleTypeShape = catalogShape{From: "door_style_types", Fields: []shapeField{
+		{Name: "id", SQL: "door_style_types.id"},
+		{Name: "name", SQL: localizedSQL("door-style-type", "name")},
+	}}
+
+	imageTypeShape = catalogShape{From: "image_types", Fields: []shapeField{
+		{Name: "id", SQL: "image_types.id"},
+		{Name: "name", SQL: "image_types.name"},
+		{Name: "isSpecificDimension", SQL: "image_types.is_specific_dimension"},
+		{Name: "width", SQL: "image_types.width"},
+		{Name: "height", SQL: "image_types.height"},
+	}}
+
+	doorStyleShape = catalogShape{From: "door_styles", Fields: []shapeField{
+		{Name: "id", SQL: "door_styles.id"},
+		{Name: "doorStyleTypes", SQL: doorStyleTypesSQL},
+		{Name: "name", SQL: localizedSQL("door-style", "name")},
+		{Name: "description", SQL: "door_styles.description"},
+		{Name: "profileDrawings", SQL: doorStyleDrawingsSQL, Optional: true},
+		{Name: "compatibility", SQL: doorStyleCompatibilitySQL, Optional: true},
+	}}
+
+	// imageFields are the fields of an image joined as images
+	imageFields = []shapeField{
+		{Name: "id", SQL: "images.id"},
+		{Name: "filename", SQL: "images.filename"},
+		{Name: "size", SQL: "images.size"},
+		{Name: "imageType", Fields: imageTypeShape.Fields, Join: joinImageImageTypes, Optional: true},
+	}
+
+	// doorSampleImageFields are imageFields for the primary image, which is
+	// the placeholder when a door sample has none
+	doorSampleImageFields = []shapeField{
+		{Name: "id", SQL: "COALESCE(images.id, 0)"},
+		{Name: "filename", SQL: "COALESCE(images.filename, " + placeholderParam + ")"},
+		{Name: "size", SQL: "COALESCE(images.size, 0)"},
+		{Name: "position", SQL: "COALESCE(images.position, 0)"},
+		{Name: "primary", SQL: "images.id IS NOT NULL"},
+		{Name: "placeholder", SQL: "images.id IS NULL"},
+		{Name: "imageType", Fields: imageTypeShape.Fields, Join: joinOptionalImageImageTypes, Optional: true},
+	}
+
+	doorSampleShape = catalogShape{From: "door_samples", Fields: []shapeField{
+		{Name: "id", SQL: "door_samples.id"},
+		{Name: "doorStyle", Join: joinDoorSampleDoorStyles, Fields: []shapeField{
+			{Name: "id", SQL: "door_styles.id"},
+			{Name: "doorStyleTypes", SQL: doorStyleTypesSQL, Optional: true},
+			{Name: "name", SQL: localizedSQL("door-style", "name")},
+		}},
+		{Name: "wood", Join: joinDoorSampleWood, Fields: woodShape.Fields},
+		{Name: "colour", Join: joinDoorSampleColours, Fields: colourShape.Fields},
+		{Name: "image", Join: joinDoorSampleImages, Fields: doorSampleImageFields},
+		{Name: "images", SQL: doorSampleImagesSQL, Optional: true},
+	}}
+
+	gallerySampleShape = catalogShape{From: "gallery_samples", Fields: []shapeField{
+		{Name: "id", SQL: "gallery_samples.id"},
+		{Name: "image", Fields: imageFields},
+	}}
+
+	dealerShape = catalogShape{From: "dealers", Fields: []shapeField{
+		{Name: "id", SQL: "dealers.id"},
+		{Name: "name", SQL: "dealers.name"},
+		{Name: "link", SQL: "dealers.link"},
+		{Name: "location", SQL: localizedSQL("dealer", "location")},
+		{Name: "phoneNumber", SQL: "dealers.phone_num"},
+		{Name: "email", SQL: "dealers.email"},
+		{Name: "orderNum", SQL: "dealers.order_num"},
+		{Name: "description", SQL: localizedSQL("dealer", "description")},
+		{Name: "image", Fields: imageFields},
+	}}
+)
+
+// fieldTree is a parsed list of dotted paths. A nil subtree means the whole
+// field.
+type fieldTree map[string]fieldTree
+
+func parseFieldTree(list string) fieldTree {
+	tree := fieldTree{}
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if len(path) == 0 {
+			continue
+		}
+
+		node := tree
+		names := strings.Split(path, ".")
+		for i, name := range names {
+			child, ok := node[name]
+			if ok && child == nil {
+				// The whole field is already selected
+				break
+			}
+			if i == len(names)-1 {
+				node[name] = nil
+				break
+			}
+			if !ok {
+				child = fieldTree{}
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	return tree
+}
+
+// checkFieldTree returns the first path that isn't a field
+func checkFieldTree(fields []shapeField, tree fieldTree, prefix string) error {
+	for name, child := range tree {
+		field, ok := findShapeField(fields, name)
+		if !ok || (child != nil && len(field.Fields) == 0) {
+			return fmt.Errorf("Unknown field %s%s", prefix, name)
+		}
+		if err := checkFieldTree(field.Fields, child, prefix+name+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findShapeField(fields []shapeField, name string) (shapeField, bool) {
+	for _, field := range fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return shapeField{}, false
+}
+
+// buildObject returns the json_build_object for fields and adds the joins
+// they need. selected is nil for the default fields.
+func buildObject(fields []shapeField, selected fieldTree, included fieldTree, joins *[]string) string {
+	pairs := []string{}
+	for _, field := range fields {
+		selectedChild, isSelected := selected[field.Name]
+		includedChild, isIncluded := included[field.Name]
+		if selected == nil {
+			isSelected = !field.Optional
+		}
+		if !isSelected && !isIncluded && field.Name != "id" {
+			continue
+		}
+
+		if len(field.Join) > 0 {
+			addJoin(joins, field.Join)
+		}
+		value := field.SQL
+		if len(field.Fields) > 0 {
+			if !isSelected {
+				// Only included, so its defaults
+				selectedChild = nil
+			}
+			value = buildObject(field.Fields, selectedChild, includedChild, joins)
+		}
+		pairs = append(pairs, "'"+field.Name+"', "+value)
+	}
+	return "json_build_object(" + strings.Join(pairs, ", ") + ")"
+}
+
+func addJoin(joins *[]string, join string) {
+	for _, existing := range *joins {
+		if existing == join {
+			return
+		}
+	}
+	*joins = append(*joins, join)
+}
+
+// Query returns the SQL and its arguments for the given ?fields= and
+// ?include=, one JSON object per row
+func (s catalogShape) Query(fields string, include string, query shapeQuery) (string, []interface{}, error) {
+	var selected fieldTree
+	if len(fields) > 0 {
+		selected = parseFieldTree(fields)
+		if err := checkFieldTree(s.Fields, selected, ""); err != nil {
+			return "", nil, err
+		}
+	}
+	included := parseFieldTree(include)
+	if err := checkFieldTree(s.Fields, included, ""); err != nil {
+		return "", nil, err
+	}
+
+	joins := append([]string{}, query.Joins...)
+	object := buildObject(s.Fields, selected, included, &joins)
+
+	sql := "SELECT " + object + "\nFROM " + s.From
+	for _, join := range joins {
+		sql += "\n" + join
+	}
+	if len(query.Where) > 0 {
+		sql += "\nWHERE " + query.Where
+	}
+	if len(query.Order) > 0 {
+		sql += "\nORDER BY " + query.Order
+	}
+	sql, args := bindLocale(sql, query.Args, query.Locale)
+	sql, args = bindNamedParam(sql, args, placeholderParam, GetConfig().PlaceholderImage)
+	return sql, args, nil
+}
+
+// bindNamedParam numbers a named parameter, e.g. localeParam, as the
+// argument after args
+func bindNamedParam(sql string, args []interface{}, name string, value interface{}) (string, []interface{}) {
+	if !strings.Contains(sql, name) {
+		return sql, args
+	}
+	args = append(append([]interface{}{}, args...), value)
+	return strings.Replace(sql, name, "$"+strconv.Itoa(len(args)), -1), args
+}
+
+// serveShaped answers a GET with ?fields= or ?include= using shape, and
+// reports false for the handler to answer as usual when it has neither.
+// keys are passed on to ServeCacheable.
+func serveShaped(ctx context.Context, shape catalogShape, query shapeQuery, keys ...string) bool {
+	fields := ctx.URLParam("fields")
+	include := ctx.URLParam("include")
+	if len(fields) == 0 && len(include) == 0 {
+		return false
+	}
+
+	query.Locale = RequestLocale(ctx)
+	sql, args, err := shape.Query(fields, include, query)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": err.Error()})
+		return true
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+
+	if query.One {
+		var item json.RawMessage
+		err = GetDBConnection().QueryRowContext(queryCtx, sql, args...).Scan(&item)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return true
+		}
+		ServeCacheable(ctx, item, keys...)
+		return true
+	}
+
+	rows, err := GetDBConnection().QueryContext(queryCtx, sql, args...)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return true
+	}
+	defer rows.Close()
+
+	items := []json.RawMessage{}
+	for rows.Next() {
+		var item json.RawMessage
+		if err := rows.Scan(&item); err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return true
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return true
+	}
+
+	ServeCacheable(ctx, items, keys...)
+	return true
+}
@@ -0,0 +1,85 @@
+package muskoka
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldTree(t *testing.T) {
+	tests := []struct {
+		list string
+		want fieldTree
+	}{
+		{"", fieldTree{}},
+		{"id,name", fieldTree{"id": nil, "name": nil}},
+		{" id , ,name ", fieldTree{"id": nil, "name": nil}},
+		{"doorStyle.name", fieldTree{"doorStyle": {"name": nil}}},
+		{"doorStyle.name,doorStyle.id", fieldTree{"doorStyle": {"name": nil, "id": nil}}},
+		{"image.imageType.name", fieldTree{"image": {"imageType": {"name": nil}}}},
+		// The whole object wins over some of its fields, either way round
+		{"doorStyle,doorStyle.name", fieldTree{"doorStyle": nil}},
+		{"doorStyle.name,doorStyle", fieldTree{"doorStyle": nil}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.list, func(t *testing.T) {
+			if got := parseFieldTree(test.list); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseFieldTree = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildObject(t *testing.T) {
+	fields := []shapeField{
+		{Name: "id", SQL: "t.id"},
+		{Name: "name", SQL: "t.name"},
+		{Name: "notes", SQL: "t.notes", Optional: true},
+		{Name: "child", Join: "JOIN c", Fields: []shapeField{
+			{Name: "id", SQL: "c.id"},
+			{Name: "name", SQL: "c.name"},
+			{Name: "extra", SQL: "e.extra", Join: "JOIN e", Optional: true},
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		fields    string
+		include   string
+		want      string
+		wantJoins []string
+	}{
+		{"defaults", "", "",
+			"json_build_object('id', t.id, 'name', t.name, 'child', json_build_object('id', c.id, 'name', c.name))",
+			[]string{"JOIN c"}},
+		{"id is always returned", "name", "",
+			"json_build_object('id', t.id, 'name', t.name)",
+			[]string{}},
+		{"nested field", "child.name", "",
+			"json_build_object('id', t.id, 'child', json_build_object('id', c.id, 'name', c.name))",
+			[]string{"JOIN c"}},
+		{"optional field", "", "notes",
+			"json_build_object('id', t.id, 'name', t.name, 'notes', t.notes, 'child', json_build_object('id', c.id, 'name', c.name))",
+			[]string{"JOIN c"}},
+		{"included nested field", "id", "child.extra",
+			"json_build_object('id', t.id, 'child', json_build_object('id', c.id, 'name', c.name, 'extra', e.extra))",
+			[]string{"JOIN c", "JOIN e"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var selected fieldTree
+			if len(test.fields) > 0 {
+				selected = parseFieldTree(test.fields)
+			}
+			joins := []string{}
+			got := buildObject(fields, selected, parseFieldTree(test.include), &joins)
+			if got != test.want {
+				t.Errorf("buildObject = %s, want %s", got, test.want)
+			}
+			if !reflect.DeepEqual(joins, test.wantJoins) {
+				t.Errorf("joins = %v, want %v", joins, test.wantJoins)
+			}
+		})
+	}
+}
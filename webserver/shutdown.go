@@ -0,0 +1,68 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kataras/iris"
+)
+
+var shutdownHooks []func()
+var shutdownHooksMu sync.Mutex
+
+var draining = make(chan struct{})
+
+// drained is closed once app.Shutdown has returned
+var drained = make(chan struct{})
+
+// Draining is closed when the server starts draining requests. Long lived
+// responses like event streams end then, or draining would wait for them
+// until the shutdown timeout.
+func Draining() <-chan struct{} {
+	return draining
+}
+
+// OnShutdown registers cleanup for background workers. Hooks run in reverse
+// order once the server has stopped accepting connections and drained.
+func OnShutdown(hook func()) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+func runShutdownHooks() {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	for i := len(shutdownHooks) - 1; i >= 0; i-- {
+		shutdownHooks[i]()
+	}
+	shutdownHooks = nil
+}
+
+// shutdownOnSignal fails readiness, waits for load balancers to notice and
+// then drains in-flight requests until the shutdown timeout.
+func shutdownOnSignal(app *iris.Application) {
+	defer close(drained)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	signal.Stop(signals)
+
+	config := GetConfig()
+	GetLogger().Info("shutting down", "signal", sig.String(), "timeout", config.ShutdownTimeout.String())
+	SetShuttingDown()
+	time.Sleep(config.ShutdownDelay)
+
+	ctx, cancel := stdContext.WithTimeout(stdContext.Background(), config.ShutdownTimeout)
+	defer cancel()
+	close(draining)
+	if err := app.Shutdown(ctx); err != nil {
+		GetLogger().Error("failed to drain requests", "error", err)
+	}
+}
@@ -0,0 +1,152 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/kataras/iris"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// For local testing generate a self-signed pair with
+// go run $(go env GOROOT)/src/crypto/tls/generate_cert.go --host localhost
+// and point MUSKOKA_TLS_CERT_FILE and MUSKOKA_TLS_KEY_FILE at it.
+
+// NewRunner listens with TLS when a cert/key pair or autocert domains are
+// configured and falls back to plain http otherwise.
+func NewRunner() (iris.Runner, error) {
+	config := GetConfig()
+
+	var tlsConfig *tls.Config
+	var redirectHandler http.Handler = http.HandlerFunc(redirectToHTTPS)
+
+	switch {
+	case len(config.AutocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertDomains...),
+			Cache:      autocert.DirCache(config.AutocertCacheDir),
+			Email:      config.AutocertEmail,
+		}
+		tlsConfig = manager.TLSConfig()
+		// The redirect listener also answers the http-01 challenges
+		redirectHandler = manager.HTTPHandler(redirectHandler)
+
+	case len(config.TLSCertFile) > 0:
+		reloader, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		reloader.watchSIGHUP()
+		tlsConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+
+	default:
+		return iris.Addr(config.Addr), nil
+	}
+
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	listener, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.RedirectAddr) > 0 {
+		// Listen before serving so a port that's taken fails the start up,
+		// with autocert there are no certificates without it
+		redirectListener, err := net.Listen("tcp", config.RedirectAddr)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		redirectServer := &http.Server{Handler: redirectHandler}
+		go func() {
+			err := redirectServer.Serve(redirectListener)
+			if err != nil && err != http.ErrServerClosed {
+				GetLogger().Error("redirect listener stopped", "error", err)
+			}
+		}()
+		OnShutdown(func() {
+			redirectServer.Shutdown(stdContext.Background())
+		})
+	}
+
+	return iris.Listener(tls.NewListener(listener, tlsConfig)), nil
+}
+
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	// Keep a non standard https port so local testing works
+	_, port, err := net.SplitHostPort(GetConfig().Addr)
+	if err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// certReloader serves the current certificate and swaps in a new one from
+// disk on SIGHUP, so renewed certificates don't need a restart.
+type certReloader struct {
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certFile string
+	keyFile  string
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) watchSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	OnShutdown(func() {
+		signal.Stop(signals)
+		close(signals)
+	})
+
+	go func() {
+		for range signals {
+			// Keep serving the old certificate if the new one is broken
+			if err := c.reload(); err != nil {
+				GetLogger().Error("failed to reload certificate", "error", err)
+			} else {
+				GetLogger().Info("reloaded certificate", "certFile", c.certFile)
+			}
+		}
+	}()
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
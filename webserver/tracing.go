@@ -0,0 +1,99 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "muskoka-web-api"
+
+var tracer = otel.Tracer("bitbucket.com/daemontech/muskoka-web-api/webserver")
+
+// InitTracing installs the exporter chosen by MUSKOKA_TRACE_EXPORTER. The
+// otlp exporter reads the standard OTEL_EXPORTER_OTLP_* variables. The
+// returned function flushes pending spans and should run on shutdown.
+func InitTracing() (func(stdContext.Context) error, error) {
+	config := GetConfig()
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.TraceExporter {
+	case "none", "":
+		return func(stdContext.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		exporter, err = otlptracehttp.New(stdContext.Background())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.TraceExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.TraceSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// TracingWrapper starts the server span, continuing any W3C trace context
+// sent by the client. Register it with app.WrapRouter last so it runs first.
+func TracingWrapper(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(ClientIP(r)),
+		))
+	defer span.End()
+
+	info, r := requestInfoFrom(r.WithContext(ctx))
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next(recorder, r)
+
+	if len(info.Route) > 0 {
+		span.SetName(r.Method + " " + info.Route)
+		span.SetAttributes(semconv.HTTPRoute(info.Route))
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+	if recorder.status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(recorder.status))
+	}
+}
+
+// startSpan is for outgoing calls, e.g. startSpan(ctx, "s3.DeleteObject")
+func startSpan(ctx stdContext.Context, name string, attributes ...attribute.KeyValue) (stdContext.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
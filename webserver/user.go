@@ -1,7 +1,9 @@
 package muskoka
 
 import (
-//"log"
+	stdContext "context"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -46,10 +48,47 @@ func createUserIndices() {
 	}
 }
 
-func (u User) Insert() (User, error) {
-	err := GetDBConnection().QueryRow(`INSERT INTO users (
+func (u User) Insert(ctx stdContext.Context) (User, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	err := GetDBConnection().QueryRowContext(queryCtx, `INSERT INTO users (
 		email, username, password_hash, is_admin, is_verified, verification_token)
 		VALUES($1,$2,$3,$4,$5,$6) returning id;`, u.Email, u.Username, u.PasswordHash,
 		u.IsAdmin, u.IsVerified, u.VerificationToken).Scan(&u.ID)
 	return u, err
 }
+
+// PromoteAdmin makes an existing user a verified admin, reporting false if
+// there's no user called username
+func PromoteAdmin(ctx stdContext.Context, username string) (bool, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	res, err := GetDBConnection().ExecContext(queryCtx, `
+		UPDATE users
+		SET is_admin = TRUE, is_verified = TRUE
+		WHERE lower(username) = lower($1)`, username)
+	if err != nil {
+		return false, err
+	}
+	affect, err := res.RowsAffected()
+	return affect > 0, err
+}
+
+// CreateAdmin adds a verified admin. Registration can't, so this is how the
+// first admin is made.
+func CreateAdmin(ctx stdContext.Context, username string, email string, password string) (User, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{
+		Email:             email,
+		Username:          username,
+		PasswordHash:      passwordHash,
+		IsAdmin:           true,
+		IsVerified:        true,
+		VerificationToken: randToken(),
+	}
+	return user.Insert(ctx)
+}
@@ -0,0 +1,819 @@
+package muskoka
+
+import (
+	"bytes"
+	stdContext "context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+	"github.com/kataras/iris/core/router"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookFailed    = "failed"
+
+	// Subscribing to this gets every event
+	WebhookAllEvents = "*"
+
+	webhookBatchSize = 20
+)
+
+var webhookActions = []string{"created", "updated", "deleted"}
+
+var webhookDeliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "muskoka_webhook_deliveries_total",
+	Help: "Webhook delivery attempts by result.",
+}, []string{"result"})
+
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	IsActive  bool      `json:"isActive"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscriptionId"`
+	EventID        string          `json:"eventId"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// WebhookEvent is the body POSTed to subscribers. ID is the same for every
+// subscriber and every redelivery, so receivers can drop duplicates.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+func InitWebhook() {
+	createWebhookSubscriptionTable()
+	createWebhookDeliveryTable()
+}
+
+func createWebhookSubscriptionTable() {
+	_, err := GetDBConnection().Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			id BIGSERIAL PRIMARY KEY,
+			url text NOT NULL,
+			secret text NOT NULL,
+			events text[] NOT NULL,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Deliveries are the queue as well as the log, so retries survive restarts
+// and any instance can send them.
+func createWebhookDeliveryTable() {
+	_, err := GetDBConnection().Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			subscription_id bigint references webhook_subscriptions ON DELETE CASCADE NOT NULL,
+			event_id text NOT NULL,
+			event text NOT NULL,
+			payload jsonb NOT NULL,
+			status text NOT NULL DEFAULT 'pending',
+			attempts integer NOT NULL DEFAULT 0,
+			last_status_code integer NOT NULL DEFAULT 0,
+			last_error text NOT NULL DEFAULT '',
+			next_attempt_at timestamptz DEFAULT now(),
+			delivered_at timestamptz,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);`)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = GetDBConnection().Exec(`
+		CREATE INDEX IF NOT EXISTS webhook_deliveries__next_attempt_at__idx
+		ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';`)
+	if err != nil {
+		panic(err)
+	}
+	_, err = GetDBConnection().Exec(`
+		CREATE INDEX IF NOT EXISTS webhook_deliveries__subscription_id__idx
+		ON webhook_deliveries (subscription_id, id);`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// WebhookEventName turns an entity and action into an event name, e.g.
+// "door-sample" and "created" into "door_sample.created"
+func WebhookEventName(entity string, action string) string {
+	return strings.Replace(entity, "-", "_", -1) + "." + action
+}
+
+func isWebhookEvent(event string) bool {
+	if event == WebhookAllEvents {
+		return true
+	}
+	for entity := range catalogTables {
+		for _, action := range webhookActions {
+			if event == WebhookEventName(entity, action) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queueWebhookDeliveries queues event in tx for every active subscription
+// to it
+func queueWebhookDeliveries(ctx stdContext.Context, tx *sql.Tx, event string, data interface{}) error {
+	eventID := newRequestID()
+	payload, err := json.Marshal(WebhookEvent{
+		ID:        eventID,
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err = tx.ExecContext(queryCtx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event, payload)
+		SELECT id, $1::text, $2::text, $3::jsonb
+		FROM webhook_subscriptions
+		WHERE is_active AND ($2 = ANY(events) OR $4 = ANY(events))
+		`, eventID, event, string(payload), WebhookAllEvents)
+	return err
+}
+
+// SignWebhookPayload is the X-Muskoka-Signature header for body sent at
+// timestamp. Receivers recompute the HMAC over "<t>.<body>" with their
+// secret and should reject old timestamps to stop replays.
+func SignWebhookPayload(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var webhookWake = make(chan struct{}, 1)
+
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartWebhookWorker sends due deliveries until shutdown. Other instances
+// only wake it by polling, every MUSKOKA_WEBHOOK_POLL_INTERVAL.
+func StartWebhookWorker() {
+	ctx, cancel := stdContext.WithCancel(stdContext.Background())
+	done := make(chan struct{})
+	OnShutdown(func() {
+		cancel()
+		<-done
+	})
+
+	dialer := &net.Dialer{Timeout: GetConfig().WebhookTimeout, Control: checkWebhookDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Through a proxy only the proxy's address could be checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   GetConfig().WebhookTimeout,
+		// A redirect is a failed delivery, the subscription should be fixed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	go func() {
+		defer close(done)
+		for {
+			// A full batch means more are probably due
+			for deliverDueWebhooks(ctx, client) == webhookBatchSize {
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-webhookWake:
+			case <-time.After(GetConfig().WebhookPollInterval):
+			}
+		}
+	}()
+}
+
+type claimedWebhookDelivery struct {
+	WebhookDelivery
+	URL      string
+	Secret   string
+	IsActive bool
+}
+
+// deliverDueWebhooks claims a batch of due deliveries by pushing their next
+// attempt past the timeout, so no other worker picks them up meanwhile and
+// they're retried if this one dies, then sends them concurrently.
+func deliverDueWebhooks(ctx stdContext.Context, client *http.Client) int {
+	config := GetConfig()
+	lease := config.WebhookTimeout + time.Minute
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries d
+			SET next_attempt_at = now() + make_interval(secs => $2)
+			FROM due
+			WHERE d.id = due.id
+			RETURNING d.id, d.subscription_id, d.event_id, d.event, d.payload, d.attempts
+		)
+		SELECT claimed.id, claimed.subscription_id, claimed.event_id, claimed.event,
+			claimed.payload, claimed.attempts, s.url, s.secret, s.is_active
+		FROM claimed
+		JOIN webhook_subscriptions s ON s.id = claimed.subscription_id
+		`, webhookBatchSize, lease.Seconds())
+	if err != nil {
+		if ctx.Err() == nil {
+			GetLogger().Error("unable to claim webhook deliveries", "error", err)
+		}
+		return 0
+	}
+
+	deliveries := []claimedWebhookDelivery{}
+	for rows.Next() {
+		delivery := claimedWebhookDelivery{}
+		err = rows.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.Event,
+			&delivery.Payload, &delivery.Attempts, &delivery.URL, &delivery.Secret, &delivery.IsActive)
+		if err != nil {
+			break
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		GetLogger().Error("unable to read webhook deliveries", "error", err)
+		return 0
+	}
+
+	finished := make(chan struct{})
+	for _, delivery := range deliveries {
+		go func(delivery claimedWebhookDelivery) {
+			deliverWebhook(ctx, client, delivery)
+			finished <- struct{}{}
+		}(delivery)
+	}
+	for range deliveries {
+		<-finished
+	}
+
+	return len(deliveries)
+}
+
+func deliverWebhook(ctx stdContext.Context, client *http.Client, delivery claimedWebhookDelivery) {
+	delivery.Attempts++
+
+	var statusCode int
+	var err error
+	giveUp := delivery.Attempts >= GetConfig().WebhookMaxAttempts
+	if delivery.IsActive {
+		statusCode, err = postWebhook(ctx, client, delivery)
+	} else {
+		// Queued before the subscription was turned off
+		err = fmt.Errorf("subscription is inactive")
+		giveUp = true
+	}
+	if ctx.Err() != nil {
+		// Shutting down, the lease runs out and the delivery is retried
+		return
+	}
+
+	logger := GetLogger().With("delivery", delivery.ID, "event", delivery.Event,
+		"subscription", delivery.SubscriptionID, "attempt", delivery.Attempts)
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if err == nil {
+		webhookDeliveriesTotal.WithLabelValues("success").Inc()
+		_, err = GetDBConnection().ExecContext(queryCtx, `
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = $3, last_status_code = $4, last_error = '',
+				next_attempt_at = NULL, delivered_at = now()
+			WHERE id = $1
+			`, delivery.ID, WebhookSucceeded, delivery.Attempts, statusCode)
+		if err != nil {
+			logger.Error("unable to record webhook delivery", "error", err)
+		}
+		return
+	}
+
+	if giveUp {
+		webhookDeliveriesTotal.WithLabelValues("failed").Inc()
+		logger.Warn("webhook delivery failed", "status", statusCode, "error", err)
+		_, err = GetDBConnection().ExecContext(queryCtx, `
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = $3, last_status_code = $4, last_error = $5,
+				next_attempt_at = NULL
+			WHERE id = $1
+			`, delivery.ID, WebhookFailed, delivery.Attempts, statusCode, err.Error())
+	} else {
+		webhookDeliveriesTotal.WithLabelValues("retry").Inc()
+		backoff := webhookBackoff(delivery.Attempts)
+		logger.Info("webhook delivery will be retried", "status", statusCode,
+			"backoff", backoff.String(), "error", err)
+		_, err = GetDBConnection().ExecContext(queryCtx, `
+			UPDATE webhook_deliveries
+			SET attempts = $2, last_status_code = $3, last_error = $4,
+				next_attempt_at = now() + make_interval(secs => $5)
+			WHERE id = $1
+			`, delivery.ID, delivery.Attempts, statusCode, err.Error(), backoff.Seconds())
+	}
+	if err != nil {
+		logger.Error("unable to record webhook delivery", "error", err)
+	}
+}
+
+// webhookBackoff doubles from MUSKOKA_WEBHOOK_BACKOFF with every attempt, up
+// to MUSKOKA_WEBHOOK_MAX_BACKOFF, with jitter so a receiver coming back up
+// isn't hit by every retry at once.
+func webhookBackoff(attempts int) time.Duration {
+	config := GetConfig()
+	backoff := config.WebhookBackoff
+	for i := 1; i < attempts && backoff < config.WebhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > config.WebhookMaxBackoff {
+		backoff = config.WebhookMaxBackoff
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/10+1))
+}
+
+func postWebhook(ctx stdContext.Context, client *http.Client, delivery claimedWebhookDelivery) (statusCode int, err error) {
+	ctx, span := startSpan(ctx, "webhook.Deliver",
+		attribute.String("webhook.event", delivery.Event),
+		attribute.Int64("webhook.delivery", delivery.ID))
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "muskoka-webhooks")
+	req.Header.Set("X-Muskoka-Event", delivery.Event)
+	req.Header.Set("X-Muskoka-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Muskoka-Signature", SignWebhookPayload(delivery.Secret, time.Now(), delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func CreateWebhookAPI(party router.Party) {
+	party.Use(AdminMiddleware)
+	party.Get("/findOne/:id", findOneWebhookSubscriptionHandler)
+	party.Get("", findWebhookSubscriptionsHandler)
+	party.Post("", insertWebhookSubscriptionHandler)
+	party.Put("", updateOneWebhookSubscriptionHandler)
+	party.Delete("/:id", removeOneWebhookSubscriptionHandler)
+}
+
+func CreateWebhookDeliveryAPI(party router.Party) {
+	party.Use(AdminMiddleware)
+	party.Get("/findOne/:id", findOneWebhookDeliveryHandler)
+	party.Get("", findWebhookDeliveriesHandler)
+	party.Post("/redeliver/:id", redeliverWebhookHandler)
+}
+
+// readWebhookSubscription checks the url and events, the secret is only
+// required on insert
+func readWebhookSubscription(ctx context.Context) (*WebhookSubscription, ValidationErrors) {
+	subscription := &WebhookSubscription{IsActive: true}
+	if err := ctx.ReadJSON(subscription); err != nil {
+		return nil, nil
+	}
+
+	validationErrors := ValidationErrors{}
+	target, err := url.Parse(subscription.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || len(target.Host) == 0 {
+		validationErrors["url"] = "Url must be an absolute http or https url."
+	} else if message := webhookHostError(ctx.Request().Context(), target.Hostname()); len(message) > 0 {
+		validationErrors["url"] = message
+	}
+	if len(subscription.Events) == 0 {
+		validationErrors["events"] = "Events are required."
+	}
+	for _, event := range subscription.Events {
+		if !isWebhookEvent(event) {
+			validationErrors["events"] = fmt.Sprintf("%s is not an event.", event)
+			break
+		}
+	}
+	return subscription, validationErrors
+}
+
+// isPublicWebhookIP is false for addresses a webhook mustn't reach, so a
+// subscription can't be used to call services on our own network
+func isPublicWebhookIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// webhookHostError is what's wrong with a subscription host that doesn't
+// resolve to only public addresses, or ""
+func webhookHostError(ctx stdContext.Context, host string) string {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(queryCtx, host)
+	if err != nil {
+		return "Url host " + host + " can't be found."
+	}
+	for _, addr := range addrs {
+		if !isPublicWebhookIP(addr.IP) {
+			return "Url host " + host + " isn't a public address."
+		}
+	}
+	return ""
+}
+
+// checkWebhookDial checks the address actually connected to as well, the
+// host may resolve differently by the time the webhook is sent
+func checkWebhookDial(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicWebhookIP(ip) {
+		return fmt.Errorf("webhook address %s isn't public", host)
+	}
+	return nil
+}
+
+func findOneWebhookSubscriptionHandler(ctx context.Context) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read webhook id"})
+		return
+	}
+
+	subscription := WebhookSubscription{ID: id}
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err = GetDBConnection().QueryRowContext(queryCtx, `
+		SELECT url, events, is_active, created_at
+		FROM webhook_subscriptions
+		WHERE id = $1
+		`, id).Scan(&subscription.URL, pq.Array(&subscription.Events),
+		&subscription.IsActive, &subscription.CreatedAt)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(subscription)
+}
+
+func findWebhookSubscriptionsHandler(ctx context.Context) {
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT id, url, events, is_active, created_at
+		FROM webhook_subscriptions
+		ORDER BY id ASC`)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer rows.Close()
+
+	subscriptions := []WebhookSubscription{}
+	for rows.Next() {
+		subscription := WebhookSubscription{}
+		err = rows.Scan(&subscription.ID, &subscription.URL, pq.Array(&subscription.Events),
+			&subscription.IsActive, &subscription.CreatedAt)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(subscriptions)
+}
+
+// insertWebhookSubscriptionHandler generates a secret unless one is given.
+// This is the only response with the secret in it.
+func insertWebhookSubscriptionHandler(ctx context.Context) {
+	subscription, validationErrors := readWebhookSubscription(ctx)
+	if subscription == nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read webhook"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": validationErrors})
+		return
+	}
+	if len(subscription.Secret) == 0 {
+		subscription.Secret = newRequestID() + newRequestID()
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	err := GetDBConnection().QueryRowContext(queryCtx, `
+		INSERT INTO webhook_subscriptions (url, secret, events, is_active)
+		VALUES($1,$2,$3,$4)
+		returning id, created_at;`,
+		subscription.URL, subscription.Secret, pq.Array(subscription.Events), subscription.IsActive,
+	).Scan(&subscription.ID, &subscription.CreatedAt)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(subscription)
+}
+
+// updateOneWebhookSubscriptionHandler keeps the current secret unless a new
+// one is given
+func updateOneWebhookSubscriptionHandler(ctx context.Context) {
+	subscription, validationErrors := readWebhookSubscription(ctx)
+	if subscription == nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read webhook"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"validationErrors": validationErrors})
+		return
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	res, err := GetDBConnection().ExecContext(queryCtx, `
+		UPDATE webhook_subscriptions
+		SET url=$1, events=$2, is_active=$3, secret=COALESCE(NULLIF($4, ''), secret)
+		WHERE id=$5
+		`, subscription.URL, pq.Array(subscription.Events), subscription.IsActive,
+		subscription.Secret, subscription.ID)
+	if err != nil {
+		statusCode, result := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(result)
+		return
+	}
+
+	affect, err := res.RowsAffected()
+	if err != nil {
+		statusCode, result := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(result)
+		return
+	}
+
+	if affect < 1 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]interface{}{"error": "No webhook found"})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{})
+}
+
+// removeOneWebhookSubscriptionHandler removes the delivery log with it
+func removeOneWebhookSubscriptionHandler(ctx context.Context) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read webhook id"})
+		return
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	res, err := GetDBConnection().ExecContext(queryCtx, "delete from webhook_subscriptions where id=$1", id)
+	if err != nil {
+		statusCode, result := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(result)
+		return
+	}
+
+	affect, err := res.RowsAffected()
+	if err != nil {
+		statusCode, result := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(result)
+		return
+	}
+
+	if affect < 1 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(map[string]interface{}{"error": "No webhook found"})
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(map[string]interface{}{
+		"id": strconv.FormatInt(id, 10),
+	})
+}
+
+const webhookDeliveryColumns = `id, subscription_id, event_id, event, payload, status, attempts,
+	last_status_code, last_error, next_attempt_at, delivered_at, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhookDelivery(row rowScanner) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var nextAttemptAt, deliveredAt pq.NullTime
+	err := row.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.Event,
+		&delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.LastStatusCode,
+		&delivery.LastError, &nextAttemptAt, &deliveredAt, &delivery.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return delivery, nil
+}
+
+func findOneWebhookDeliveryHandler(ctx context.Context) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read delivery id"})
+		return
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	delivery, err := scanWebhookDelivery(GetDBConnection().QueryRowContext(queryCtx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = $1`, id))
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(delivery)
+}
+
+// findWebhookDeliveriesHandler lists the newest deliveries first. It takes
+// subscriptionId, event and status filters, limit, and beforeId to page.
+func findWebhookDeliveriesHandler(ctx context.Context) {
+	subscriptionID, _ := ctx.URLParamInt64("subscriptionId")
+	beforeID, _ := ctx.URLParamInt64("beforeId")
+	limit, err := ctx.URLParamInt("limit")
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	rows, err := GetDBConnection().QueryContext(queryCtx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE ($1 = 0 OR subscription_id = $1)
+			AND ($2 = '' OR event = $2)
+			AND ($3 = '' OR status = $3)
+			AND ($4 = 0 OR id < $4)
+		ORDER BY id DESC
+		LIMIT $5
+		`, subscriptionID, ctx.URLParam("event"), ctx.URLParam("status"), beforeID, limit)
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			statusCode, errObj := HandleDBError(err)
+			ctx.StatusCode(statusCode)
+			ctx.JSON(errObj)
+			return
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(deliveries)
+}
+
+// redeliverWebhookHandler queues a copy of a delivery, whatever its status,
+// and leaves the original in the log. The copy has the same payload and event
+// id but a new signature.
+func redeliverWebhookHandler(ctx context.Context) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(map[string]interface{}{"error": "Unable to read delivery id"})
+		return
+	}
+
+	queryCtx, cancel := withQueryTimeout(ctx.Request().Context())
+	defer cancel()
+	delivery, err := scanWebhookDelivery(GetDBConnection().QueryRowContext(queryCtx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event, payload)
+		SELECT subscription_id, event_id, event, payload
+		FROM webhook_deliveries
+		WHERE id = $1
+		RETURNING `+webhookDeliveryColumns, id))
+	if err != nil {
+		statusCode, errObj := HandleDBError(err)
+		ctx.StatusCode(statusCode)
+		ctx.JSON(errObj)
+		return
+	}
+
+	wakeWebhookWorker()
+
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(delivery)
+}
@@ -0,0 +1,143 @@
+package muskoka
+
+import (
+	stdContext "context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusOK, false},
+		{"no content", http.StatusNoContent, false},
+		{"redirect", http.StatusFound, true},
+		{"receiver error", http.StatusInternalServerError, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var received *http.Request
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				body, _ = io.ReadAll(r.Body)
+				if test.status == http.StatusFound {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			payload, _ := json.Marshal(WebhookEvent{ID: "event-1", Event: "wood.updated",
+				Data: map[string]interface{}{"id": 3}})
+			delivery := claimedWebhookDelivery{
+				WebhookDelivery: WebhookDelivery{ID: 42, Event: "wood.updated", Payload: payload},
+				URL:             server.URL,
+				Secret:          "shh",
+				IsActive:        true,
+			}
+			client := &http.Client{
+				Timeout: time.Second,
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+
+			statusCode, err := postWebhook(stdContext.Background(), client, delivery)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, test.wantErr)
+			}
+			if statusCode != test.status {
+				t.Errorf("statusCode = %d, want %d", statusCode, test.status)
+			}
+
+			if received == nil {
+				t.Fatal("receiver got no request")
+			}
+			if string(body) != string(payload) {
+				t.Errorf("body = %s, want %s", body, payload)
+			}
+			if got := received.Header.Get("X-Muskoka-Event"); got != "wood.updated" {
+				t.Errorf("X-Muskoka-Event = %q", got)
+			}
+			if got := received.Header.Get("X-Muskoka-Delivery"); got != "42" {
+				t.Errorf("X-Muskoka-Delivery = %q", got)
+			}
+
+			// The receiver can check the signature with its secret
+			signature := received.Header.Get("X-Muskoka-Signature")
+			parts := strings.SplitN(strings.TrimPrefix(signature, "t="), ",", 2)
+			timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				t.Fatalf("signature %q has no timestamp", signature)
+			}
+			if want := SignWebhookPayload("shh", time.Unix(timestamp, 0), body); signature != want {
+				t.Errorf("signature = %q, want %q", signature, want)
+			}
+			if SignWebhookPayload("wrong", time.Unix(timestamp, 0), body) == signature {
+				t.Error("signature matches with the wrong secret")
+			}
+		})
+	}
+}
+
+func TestIsPublicWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if got := isPublicWebhookIP(net.ParseIP(test.ip)); got != test.want {
+				t.Errorf("isPublicWebhookIP(%s) = %v, want %v", test.ip, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookDial(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"93.184.216.34:443", false},
+		{"127.0.0.1:8080", true},
+		{"[::1]:80", true},
+		{"169.254.169.254:80", true},
+		{"not-an-address", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			err := checkWebhookDial("tcp", test.address, nil)
+			if (err != nil) != test.wantErr {
+				t.Errorf("checkWebhookDial(%s) = %v, wantErr %v", test.address, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -1,32 +1,76 @@
 package muskoka
 
 import (
+	stdContext "context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/kataras/iris"
 	"github.com/kataras/iris/context"
 	"github.com/kataras/iris/core/router"
-	"strconv"
+	"github.com/lib/pq"
+)
+
+const (
+	WoodPriceTierEconomy  = "economy"
+	WoodPriceTierStandard = "standard"
+	WoodPriceTierPremium  = "premium"
 )
 
 type Wood struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
+	// JankaHardness is in pound-force, 0 when unknown
+	JankaHardness       int    `json:"jankaHardness,omitempty"`
+	GrainPattern        string `json:"grainPattern,omitempty"`
+	Paintable           bool   `json:"paintable,omitempty"`
+	Stainable           bool   `json:"stainable,omitempty"`
+	PriceTier           string `json:"priceTier,omitempty"`
+	SustainabilityNotes string `json:"sustainabilityNotes,omitempty"`
+	// Images are in order, an update without them leaves them as they are
+	Images []Image `json:"images,omitempty"`
 }
 
 func InitWood() {
 	createWoodTable()
+	migrateWoodTable()
 	createWoodIndices()
 }
 
 func createWoodTable() {
 	_, err := GetDBConnection().Exec(`CREATE TABLE IF NOT EXISTS wood (
 		id BIGSERIAL PRIMARY KEY,
-		name text NOT NULL
+		name text NOT NULL,
+		janka_hardness integer CHECK (janka_hardness > 0),
+		grain_pattern text,
+		paintable boolean NOT NULL DEFAULT FALSE,
+		stainable boolean NOT NULL DEFAULT FALSE,
+		price_tier text CHECK (price_tier IN ('economy', 'standard', 'premium')),
+		sustainability_notes text
 	);`)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// migrateWoodTable adds the species attributes to older databases
+func migrateWoodTable() {
+	_, err := GetDBConnection().Exec(`
+		ALTER TABLE wood
+			ADD COLUMN IF NOT EXISTS janka_hardness integer CHECK (janka_hardness > 0),
+			ADD COLUMN IF NOT EXISTS grain_pattern text,
+			ADD COLUMN IF NOT EXISTS paintable boolean NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS stainable boolean NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS price_tier text CHECK (price_tier IN ('economy', 'standard', 'premium')),
+			ADD COLUMN IF NOT EXISTS sustainability_notes text;`)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func createWoodIndices() {
 	_, err := GetDBConnection().Exec(`CREATE UNIQUE INDEX IF NOT EXISTS wood__name__key ON wood (lower(name));`)
 	if err != nil {
@@ -34,12 +78,28 @@ func createWoodIndices() {
 	}
 }
 
+const woodColumns = `wood.id, wood.name, COALESCE(wood.janka_hardness, 0), COALESCE(wood.grain_pattern, ''),
+	wood.paintable, wood.stainable, COALESCE(wood.price_tier, ''), COALESCE(wood.sustainability_notes, '')`
+
+func scanWood(row rowScanner) (*Wood, error) {
+	wood := &Wood{}
+	err := row.Scan(&wood.ID, &wood.Name, &wood.JankaHardness, &wood.GrainPattern,
+		&wood.Paintable, &wood.Stainable, &wood.PriceTier, &wood.SustainabilityNotes)
+	if err != nil {
+		return nil, err
+	}
+	return wood, nil
+}
+
 func CreateWoodAPI(party router.Party) {
-	party.Get("/findOne/:id", findOneWoodHandler)
-	party.Get("", findWoodHandler)
-	party.Post("", insertWoodHandler)
-	party.Put("", updateOneWoodHandler)
-	party.Delete("/:id", removeOneWoodHandler)
+	cachePolicy := CatalogCachePolicy("wood")
+	party.Get("/findOne/:id", CacheMiddleware(cachePolicy), findOneWoodHandler)
+	party.Get("", CacheMiddleware(cachePolicy), findWoodHandler)
+	party.Post("", IdempotencyMiddleware, SingleHandler(woodBulkResource, "create"))
+	party.Post("/bulk", AdminMiddleware, IdempotencyMiddleware, BulkHandler(woodBulkResource))
+	party.Put("", SingleHandler(woodBulkResource, "update"))
+	party.Patch("/:id", AdminMiddleware, PatchHandler(woodPatchResource))
+	party.Delete("/:id", SingleHandler(woodBulkResource, "delete"))
 }
 
 func findOneWoodHandler(ctx context.Context) {
@@ -50,100 +110,134 @@ func findOneWoodHandler(ctx context.Context) {
 		return
 	}
 
-	wood := Wood{ID: id}
-	err = GetDBConnection().QueryRow(`
-		SELECT name 
-		FROM wood
-		WHERE id = $1`,
-		id).Scan(&wood.Name)
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
+	if serveShaped(ctx, woodShape, shapeQuery{Where: "wood.id = $1", Args: []interface{}{id}, One: true},
+		"wood/"+strconv.FormatInt(id, 10)) {
 		return
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(wood)
-}
-
-func findWoodHandler(ctx context.Context) {
-	rows, err := GetDBConnection().Query(`
-		SELECT id, name 
-		FROM wood
-		ORDER BY name`)
+	wood, err := FindWoodFromID(ctx.Request().Context(), id)
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
 		ctx.JSON(errObj)
 		return
 	}
-	defer rows.Close()
 
-	woods := []Wood{}
-	for rows.Next() {
-		wood := Wood{}
-		err = rows.Scan(&wood.ID, &wood.Name)
+	ServeCacheable(ctx, localize(ctx, wood), "wood/"+strconv.FormatInt(id, 10))
+}
+
+func FindWoodFromID(ctx stdContext.Context, id int64) (*Wood, error) {
+	value, err := cachedRead("wood", strconv.FormatInt(id, 10), func() (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		wood, err := scanWood(GetDBConnection().QueryRowContext(queryCtx, `
+			SELECT `+woodColumns+`
+			FROM wood
+			WHERE wood.id = $1`,
+			id))
 		if err != nil {
-			statusCode, errObj := HandleDBError(err)
-			ctx.StatusCode(statusCode)
-			ctx.JSON(errObj)
-			return
+			return nil, err
 		}
-		woods = append(woods, wood)
+
+		images, err := findOwnedImages(ctx, woodImageOwner, []int64{id})
+		wood.Images = images[id]
+		return wood, err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return value.(*Wood), nil
+}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(woods)
+// WoodFilter narrows wood to the species with the given attributes, for the
+// wood list and door sample search. Zero values don't filter.
+type WoodFilter struct {
+	Paintable        bool     `json:"paintable,omitempty"`
+	Stainable        bool     `json:"stainable,omitempty"`
+	PriceTiers       []string `json:"priceTiers,omitempty"`
+	GrainPatterns    []string `json:"grainPatterns,omitempty"`
+	MinJankaHardness int      `json:"minJankaHardness,omitempty"`
 }
 
-func insertWoodHandler(ctx context.Context) {
-	wood := &Wood{}
-	if err := ctx.ReadJSON(wood); err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read wood"})
-		return
-	}
+// readWoodFilter reads ?paintable=true, ?stainable=true, ?priceTiers=,
+// ?grainPatterns= (comma separated) and ?minJankaHardness=
+func readWoodFilter(ctx context.Context) WoodFilter {
+	filter := WoodFilter{}
+	filter.Paintable, _ = strconv.ParseBool(ctx.URLParam("paintable"))
+	filter.Stainable, _ = strconv.ParseBool(ctx.URLParam("stainable"))
+	filter.PriceTiers = splitFilterValues(ctx.URLParam("priceTiers"))
+	filter.GrainPatterns = splitFilterValues(ctx.URLParam("grainPatterns"))
+	filter.MinJankaHardness, _ = strconv.Atoi(ctx.URLParam("minJankaHardness"))
+	return filter
+}
 
-	err := GetDBConnection().QueryRow(`INSERT INTO wood (name)
-		VALUES($1) returning id;`, wood.Name).Scan(&wood.ID)
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+func splitFilterValues(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil
 	}
+	return values
+}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(wood)
+// IsEmpty is true when the filter lets every wood through
+func (f WoodFilter) IsEmpty() bool {
+	return !f.Paintable && !f.Stainable && len(f.PriceTiers) == 0 && len(f.GrainPatterns) == 0 &&
+		f.MinJankaHardness <= 0
 }
 
-func removeOneWoodHandler(ctx context.Context) {
+// Matches is the filter applied to a loaded wood
+func (f WoodFilter) Matches(wood Wood) bool {
+	return (!f.Paintable || wood.Paintable) && (!f.Stainable || wood.Stainable) &&
+		(len(f.PriceTiers) == 0 || containsField(f.PriceTiers, wood.PriceTier)) &&
+		(len(f.GrainPatterns) == 0 || containsField(f.GrainPatterns, wood.GrainPattern)) &&
+		(f.MinJankaHardness <= 0 || wood.JankaHardness >= f.MinJankaHardness)
+}
 
-	id, err := ctx.Params().GetInt64("id")
-	if err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read wood id"})
-		return
+// SQL is the filter on the wood table, without WHERE, numbering its
+// arguments after args
+func (f WoodFilter) SQL(args []interface{}) (string, []interface{}) {
+	whereQueries := []string{}
+	if f.Paintable {
+		whereQueries = append(whereQueries, "wood.paintable")
+	}
+	if f.Stainable {
+		whereQueries = append(whereQueries, "wood.stainable")
+	}
+	if len(f.PriceTiers) > 0 {
+		args = append(args, pq.Array(f.PriceTiers))
+		whereQueries = append(whereQueries, fmt.Sprintf("wood.price_tier = ANY($%d)", len(args)))
+	}
+	if len(f.GrainPatterns) > 0 {
+		args = append(args, pq.Array(f.GrainPatterns))
+		whereQueries = append(whereQueries, fmt.Sprintf("wood.grain_pattern = ANY($%d)", len(args)))
+	}
+	if f.MinJankaHardness > 0 {
+		args = append(args, f.MinJankaHardness)
+		whereQueries = append(whereQueries, fmt.Sprintf("wood.janka_hardness >= $%d", len(args)))
 	}
+	return strings.Join(whereQueries, " AND "), args
+}
 
-	stmt, err := GetDBConnection().Prepare("delete from wood where id=$1")
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
+// findWoodHandler lists the wood, optionally only the species matching the
+// WoodFilter parameters, e.g. ?paintable=true
+func findWoodHandler(ctx context.Context) {
+	filter := readWoodFilter(ctx)
+	where, args := filter.SQL(nil)
+	if serveExport(ctx, exportQuery{Name: "wood", From: "wood", Where: where, Args: args,
+		Order: localizedSQL("wood", "name"), Columns: woodExportColumns}) {
 		return
 	}
-
-	res, err := stmt.Exec(id)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
+	if serveShaped(ctx, woodShape, shapeQuery{Where: where, Args: args, Order: localizedSQL("wood", "name")}) {
 		return
 	}
 
-	err = stmt.Close()
+	woods, err := FindWoods(ctx.Request().Context())
 	if err != nil {
 		statusCode, errObj := HandleDBError(err)
 		ctx.StatusCode(statusCode)
@@ -151,77 +245,245 @@ func removeOneWoodHandler(ctx context.Context) {
 		return
 	}
 
-	affect, err := res.RowsAffected()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	if !filter.IsEmpty() {
+		// The cached list is shared, so filter a copy
+		filtered := []Wood{}
+		for _, wood := range *woods {
+			if filter.Matches(wood) {
+				filtered = append(filtered, wood)
+			}
+		}
+		woods = &filtered
 	}
 
-	if affect < 1 {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No colour found"})
-		return
-	}
+	ServeCacheable(ctx, localize(ctx, woods))
+}
 
-	ctx.StatusCode(iris.StatusOK)
-	idString := strconv.FormatInt(id, 10)
-	ctx.JSON(map[string]interface{}{
-		"id": idString,
+func FindWoods(ctx stdContext.Context) (*[]Wood, error) {
+	value, err := cachedRead("wood", "list", func() (interface{}, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		rows, err := GetDBConnection().QueryContext(queryCtx, `
+			SELECT `+woodColumns+`
+			FROM wood
+			ORDER BY wood.name`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		woods := []Wood{}
+		ids := []int64{}
+		for rows.Next() {
+			wood, err := scanWood(rows)
+			if err != nil {
+				return nil, err
+			}
+			woods = append(woods, *wood)
+			ids = append(ids, wood.ID)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+
+		images, err := findOwnedImages(ctx, woodImageOwner, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range woods {
+			woods[i].Images = images[woods[i].ID]
+		}
+		return &woods, nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*[]Wood), nil
 }
 
-func updateOneWoodHandler(ctx context.Context) {
-	wood := &Wood{}
-	if err := ctx.ReadJSON(wood); err != nil {
-		ctx.StatusCode(iris.StatusBadRequest)
-		ctx.JSON(map[string]interface{}{"error": "Unable to read wood"})
-		return
+// readWood reads a wood sent to be saved over wood and validates it.
+// Fields left out of data keep their value in wood.
+func readWood(wood *Wood, data json.RawMessage) (*Wood, error) {
+	if err := json.Unmarshal(data, wood); err != nil {
+		return nil, err
 	}
 
-	stmt, err := GetDBConnection().Prepare(`
-		UPDATE wood 
-		SET name=$1  
-		WHERE id=$2
-	`)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	validationErrors := ValidationErrors{}
+	if len(strings.TrimSpace(wood.Name)) == 0 {
+		validationErrors["name"] = "Name is required."
+	}
+	if wood.JankaHardness < 0 {
+		validationErrors["jankaHardness"] = "Janka Hardness must be a positive number of pound-force."
 	}
 
-	res, err := stmt.Exec(wood.Name, wood.ID)
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+	wood.GrainPattern = strings.ToLower(strings.TrimSpace(wood.GrainPattern))
+	wood.PriceTier = strings.ToLower(strings.TrimSpace(wood.PriceTier))
+	switch wood.PriceTier {
+	case "", WoodPriceTierEconomy, WoodPriceTierStandard, WoodPriceTierPremium:
+	default:
+		validationErrors["priceTier"] = "Price Tier must be economy, standard or premium."
 	}
+	wood.SustainabilityNotes = strings.TrimSpace(wood.SustainabilityNotes)
 
-	err = stmt.Close()
-	if err != nil {
-		statusCode, errObj := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(errObj)
-		return
+	checkOwnedImages(wood.Images, "images", validationErrors)
+
+	if len(validationErrors) > 0 {
+		return nil, validationErrors
 	}
+	return wood, nil
+}
 
-	affect, err := res.RowsAffected()
-	if err != nil {
-		statusCode, result := HandleDBError(err)
-		ctx.StatusCode(statusCode)
-		ctx.JSON(result)
-		return
+// woodValues are the wood's columns after the name, NULL when unset
+func woodValues(wood *Wood) []interface{} {
+	values := []interface{}{nil, nil, wood.Paintable, wood.Stainable, nil, nil}
+	if wood.JankaHardness > 0 {
+		values[0] = wood.JankaHardness
 	}
+	if len(wood.GrainPattern) > 0 {
+		values[1] = wood.GrainPattern
+	}
+	if len(wood.PriceTier) > 0 {
+		values[4] = wood.PriceTier
+	}
+	if len(wood.SustainabilityNotes) > 0 {
+		values[5] = wood.SustainabilityNotes
+	}
+	return values
+}
 
-	if affect < 1 {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(map[string]interface{}{"error": "No wood found"})
-		return
+var woodBulkResource = BulkResource{
+	Entity: "wood",
+	Create: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		wood, err := readWood(&Wood{}, data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		for i := range wood.Images {
+			wood.Images[i].ID = 0
+		}
+
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		err = tx.QueryRowContext(queryCtx, `
+			INSERT INTO wood (name, janka_hardness, grain_pattern, paintable, stainable, price_tier,
+				sustainability_notes)
+			VALUES($1,$2,$3,$4,$5,$6,$7) returning id;`,
+			append([]interface{}{wood.Name}, woodValues(wood)...)...).Scan(&wood.ID)
+		if err != nil {
+			return BulkChange{}, err
+		}
+
+		_, err = saveOwnedImages(ctx, tx, woodImageOwner, wood.ID, "images", wood.Images)
+		return BulkChange{ID: wood.ID, Data: wood}, err
+	},
+	// Only the fields sent change, e.g. {"id": 1, "name": "Maple"} keeps the
+	// hardness and images
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		var sent struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(data, &sent); err != nil {
+			return BulkChange{}, err
+		}
+		current, err := findWoodForUpdate(ctx, tx, sent.ID)
+		if err != nil {
+			return BulkChange{ID: sent.ID}, err
+		}
+		wood, err := readWood(current, data)
+		if err != nil {
+			return BulkChange{ID: sent.ID}, err
+		}
+		wood.ID = sent.ID
+		return saveWood(ctx, tx, wood)
+	},
+	Delete: func(ctx stdContext.Context, tx *sql.Tx, id int64) (BulkChange, error) {
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+
+		// The images go with the wood through ON DELETE CASCADE
+		images, err := findOwnedImagesForUpdate(queryCtx, tx, woodImageOwner, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		filenames := []string{}
+		for _, image := range images {
+			filenames = append(filenames, image.Filename)
+		}
+
+		res, err := tx.ExecContext(queryCtx, `DELETE FROM wood WHERE id=$1`, id)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		if err = requireRowsAffected(res); err != nil {
+			return BulkChange{}, err
+		}
+		return BulkChange{AfterCommit: deleteS3ObjectAfterCommit(ctx, filenames...)}, nil
+	},
+}
+
+var woodPatchResource = PatchResource{
+	Entity: "wood",
+	Load: func(ctx stdContext.Context, tx *sql.Tx, id int64) (interface{}, error) {
+		wood, err := findWoodForUpdate(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		queryCtx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		wood.Images, err = findOwnedImagesForUpdate(queryCtx, tx, woodImageOwner, id)
+		return wood, err
+	},
+	Update: func(ctx stdContext.Context, tx *sql.Tx, data json.RawMessage) (BulkChange, error) {
+		wood, err := readWood(&Wood{}, data)
+		if err != nil {
+			return BulkChange{}, err
+		}
+		return saveWood(ctx, tx, wood)
+	},
+}
+
+// findWoodForUpdate locks the wood row, without its images so an update
+// that doesn't send them leaves them alone
+func findWoodForUpdate(ctx stdContext.Context, tx *sql.Tx, id int64) (*Wood, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	return scanWood(tx.QueryRowContext(queryCtx, `
+		SELECT `+woodColumns+`
+		FROM wood
+		WHERE wood.id = $1
+		FOR UPDATE`,
+		id))
+}
+
+// saveWood updates every column of the wood, and its images if it has any
+func saveWood(ctx stdContext.Context, tx *sql.Tx, wood *Wood) (BulkChange, error) {
+	change := BulkChange{ID: wood.ID, Data: wood}
+
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	res, err := tx.ExecContext(queryCtx, `
+		UPDATE wood
+		SET name=$1, janka_hardness=$2, grain_pattern=$3, paintable=$4, stainable=$5, price_tier=$6,
+			sustainability_notes=$7
+		WHERE id=$8`,
+		append(append([]interface{}{wood.Name}, woodValues(wood)...), wood.ID)...)
+	if err != nil {
+		return change, err
+	}
+	if err = requireRowsAffected(res); err != nil {
+		return change, err
 	}
 
-	ctx.StatusCode(iris.StatusOK)
-	ctx.JSON(map[string]interface{}{})
+	if wood.Images == nil {
+		return change, nil
+	}
+	removed, err := saveOwnedImages(ctx, tx, woodImageOwner, wood.ID, "images", wood.Images)
+	if err != nil {
+		return change, err
+	}
+	if len(removed) > 0 {
+		change.AfterCommit = deleteS3ObjectAfterCommit(ctx, removed...)
+	}
+	return change, nil
 }